@@ -28,18 +28,23 @@ func main() {
 
 	// Fetch the HTML content from the provided URL
 	fmt.Println("Fetching URL:", url)
-	html, err := httpclient.FetchHTML(url, headers)
+	body, err := httpclient.FetchReader(url, headers)
 	if err != nil {
 		log.Fatalf("Error fetching URL: %v", err)
 	}
+	defer body.Close()
 
-	// Parse the fetched HTML content
-	p := parser.New(html)
-	root := p.Parse()
+	// Parse the HTML content as it is downloaded
+	root, err := parser.ParseReader(body)
+	if err != nil {
+		log.Fatalf("Error reading response: %v", err)
+	}
+
+	fmt.Println("Encoding:", body.Encoding)
 
 	// Print the parsed tree
 	fmt.Println("\nParsed Tree:")
-	printTree(root, "")
+	printTree(root.Node, "")
 }
 
 // printTree recursively prints the parsed tree
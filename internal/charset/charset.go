@@ -0,0 +1,237 @@
+// Package charset detects the character encoding of HTML documents and
+// decodes them to UTF-8, following the encoding sniffing algorithm of the
+// HTML5 specification. It supports UTF-8, UTF-16 and the single-byte
+// encodings common on European sites, such as windows-1250 and windows-1251.
+package charset
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"mime"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// prescanSize is how much of a document is searched for a <meta> charset.
+const prescanSize = 1024
+
+// decoder appends the UTF-8 text for the start of src to dst and returns it
+// with the number of bytes of src it used. Unless atEOF is set it may leave
+// a character split across reads for the next call.
+type decoder func(dst, src []byte, atEOF bool) ([]byte, int)
+
+// encodings are the supported encodings by canonical name.
+var encodings = map[string]decoder{
+	"utf-8":        decodeUTF8,
+	"utf-16le":     decodeUTF16(binary.LittleEndian),
+	"utf-16be":     decodeUTF16(binary.BigEndian),
+	"windows-1250": decodeSingleByte(&windows1250),
+	"windows-1251": decodeSingleByte(&windows1251),
+	"windows-1252": decodeSingleByte(&windows1252),
+	"iso-8859-2":   decodeSingleByte(&iso88592),
+	"iso-8859-5":   decodeSingleByte(&iso88595),
+	"koi8-r":       decodeSingleByte(&koi8r),
+}
+
+// labels maps the labels of the WHATWG Encoding Standard to the canonical
+// names of the supported encodings.
+var labels = map[string]string{
+	"unicode-1-1-utf-8": "utf-8", "unicode11utf8": "utf-8", "unicode20utf8": "utf-8",
+	"utf-8": "utf-8", "utf8": "utf-8", "x-unicode20utf8": "utf-8",
+
+	"csunicode": "utf-16le", "iso-10646-ucs-2": "utf-16le", "ucs-2": "utf-16le",
+	"unicode": "utf-16le", "unicodefeff": "utf-16le", "utf-16": "utf-16le", "utf-16le": "utf-16le",
+	"unicodefffe": "utf-16be", "utf-16be": "utf-16be",
+
+	"cp1250": "windows-1250", "windows-1250": "windows-1250", "x-cp1250": "windows-1250",
+	"cp1251": "windows-1251", "windows-1251": "windows-1251", "x-cp1251": "windows-1251",
+
+	"ansi_x3.4-1968": "windows-1252", "ascii": "windows-1252", "cp1252": "windows-1252",
+	"cp819": "windows-1252", "csisolatin1": "windows-1252", "ibm819": "windows-1252",
+	"iso-8859-1": "windows-1252", "iso-ir-100": "windows-1252", "iso8859-1": "windows-1252",
+	"iso88591": "windows-1252", "iso_8859-1": "windows-1252", "iso_8859-1:1987": "windows-1252",
+	"l1": "windows-1252", "latin1": "windows-1252", "us-ascii": "windows-1252",
+	"windows-1252": "windows-1252", "x-cp1252": "windows-1252",
+
+	"csisolatin2": "iso-8859-2", "iso-8859-2": "iso-8859-2", "iso-ir-101": "iso-8859-2",
+	"iso8859-2": "iso-8859-2", "iso88592": "iso-8859-2", "iso_8859-2": "iso-8859-2",
+	"iso_8859-2:1987": "iso-8859-2", "l2": "iso-8859-2", "latin2": "iso-8859-2",
+
+	"csisolatincyrillic": "iso-8859-5", "cyrillic": "iso-8859-5", "iso-8859-5": "iso-8859-5",
+	"iso-ir-144": "iso-8859-5", "iso8859-5": "iso-8859-5", "iso88595": "iso-8859-5",
+	"iso_8859-5": "iso-8859-5", "iso_8859-5:1988": "iso-8859-5",
+
+	"cskoi8r": "koi8-r", "koi": "koi8-r", "koi8": "koi8-r", "koi8-r": "koi8-r", "koi8_r": "koi8-r",
+}
+
+// Lookup returns the canonical name of the encoding with the given label,
+// such as "windows-1251" for "CP1251", or "" if it is not supported.
+func Lookup(label string) string {
+	return labels[strings.ToLower(strings.Trim(label, "\t\n\f\r "))]
+}
+
+// Detect returns the name of the encoding of a document starting with
+// prefix, which should hold its first 1024 bytes or all of it if shorter.
+// The encoding is taken from a byte order mark, the charset parameter of
+// contentType, or a <meta> tag near the start of the document, in that
+// order. Without any of them the document is taken to be UTF-8 if prefix is
+// valid UTF-8 and windows-1252 otherwise.
+func Detect(prefix []byte, contentType string) string {
+	if name, _ := bomEncoding(prefix); name != "" {
+		return name
+	}
+	if _, params, err := mime.ParseMediaType(contentType); err == nil {
+		if name := Lookup(params["charset"]); name != "" {
+			return name
+		}
+	}
+	if len(prefix) > prescanSize {
+		prefix = prefix[:prescanSize]
+	}
+	if name := prescan(prefix); name != "" {
+		return name
+	}
+	if validUTF8Prefix(prefix) {
+		return "utf-8"
+	}
+	return "windows-1252"
+}
+
+// bomEncoding returns the encoding given by a byte order mark at the start
+// of b and the length of the mark.
+func bomEncoding(b []byte) (string, int) {
+	switch {
+	case bytes.HasPrefix(b, []byte{0xEF, 0xBB, 0xBF}):
+		return "utf-8", 3
+	case bytes.HasPrefix(b, []byte{0xFE, 0xFF}):
+		return "utf-16be", 2
+	case bytes.HasPrefix(b, []byte{0xFF, 0xFE}):
+		return "utf-16le", 2
+	}
+	return "", 0
+}
+
+// validUTF8Prefix reports whether b is valid UTF-8, apart from a character
+// cut off at its end.
+func validUTF8Prefix(b []byte) bool {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				b = b[:i]
+			}
+			break
+		}
+	}
+	return utf8.Valid(b)
+}
+
+// NewReader returns a reader that decodes the HTML document read from r to
+// UTF-8, together with the name of the encoding found by Detect. contentType
+// is the Content-Type header the document was served with, if any. A byte
+// order mark is not part of the decoded text. The error is that of reading
+// the start of the document.
+func NewReader(r io.Reader, contentType string) (io.Reader, string, error) {
+	br := bufio.NewReaderSize(r, prescanSize)
+	prefix, err := br.Peek(prescanSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, "", err
+	}
+
+	name := Detect(prefix, contentType)
+	if bom, n := bomEncoding(prefix); bom == name {
+		br.Discard(n)
+	}
+	return &reader{r: br, decode: encodings[name]}, name, nil
+}
+
+// reader decodes the bytes read from r.
+type reader struct {
+	r      io.Reader
+	decode decoder
+	in     []byte // Bytes read but not decoded yet
+	out    []byte // Decoded text not returned yet
+	err    error
+}
+
+func (d *reader) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		var buf [4096]byte
+		n, err := d.r.Read(buf[:])
+		d.in = append(d.in, buf[:n]...)
+		d.err = err
+
+		var used int
+		d.out, used = d.decode(d.out[:0], d.in, err != nil)
+		d.in = append(d.in[:0], d.in[used:]...)
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+func decodeUTF8(dst, src []byte, atEOF bool) ([]byte, int) {
+	i := 0
+	for i < len(src) {
+		if src[i] < utf8.RuneSelf {
+			dst = append(dst, src[i])
+			i++
+			continue
+		}
+		if !atEOF && !utf8.FullRune(src[i:]) {
+			break
+		}
+		// Invalid bytes decode to U+FFFD one at a time
+		r, size := utf8.DecodeRune(src[i:])
+		dst = utf8.AppendRune(dst, r)
+		i += size
+	}
+	return dst, i
+}
+
+func decodeUTF16(order binary.ByteOrder) decoder {
+	return func(dst, src []byte, atEOF bool) ([]byte, int) {
+		i := 0
+		for i+2 <= len(src) {
+			r, size := rune(order.Uint16(src[i:])), 2
+			if utf16.IsSurrogate(r) {
+				if r < 0xDC00 && i+4 > len(src) && !atEOF {
+					break
+				}
+				// A lone surrogate is an error, decoded to U+FFFD
+				low := utf8.RuneError
+				if r < 0xDC00 && i+4 <= len(src) {
+					low = rune(order.Uint16(src[i+2:]))
+				}
+				if r = utf16.DecodeRune(r, low); r != utf8.RuneError {
+					size = 4
+				}
+			}
+			dst = utf8.AppendRune(dst, r)
+			i += size
+		}
+		if atEOF && i < len(src) {
+			dst = utf8.AppendRune(dst, utf8.RuneError)
+			i = len(src)
+		}
+		return dst, i
+	}
+}
+
+func decodeSingleByte(table *[128]rune) decoder {
+	return func(dst, src []byte, atEOF bool) ([]byte, int) {
+		for _, b := range src {
+			if b < utf8.RuneSelf {
+				dst = append(dst, b)
+			} else {
+				dst = utf8.AppendRune(dst, table[b-0x80])
+			}
+		}
+		return dst, len(src)
+	}
+}
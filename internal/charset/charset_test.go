@@ -0,0 +1,159 @@
+package charset
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		label    string
+		expected string
+	}{
+		{"utf-8", "utf-8"},
+		{" UTF8 ", "utf-8"},
+		{"CP1250", "windows-1250"},
+		{"windows-1251", "windows-1251"},
+		{"iso-8859-1", "windows-1252"},
+		{"US-ASCII", "windows-1252"},
+		{"latin2", "iso-8859-2"},
+		{"utf-16", "utf-16le"},
+		{"shift_jis", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := Lookup(tt.label); got != tt.expected {
+			t.Fatalf("Test '%s' - expected=%q, got=%q", tt.label, tt.expected, got)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		contentType string
+		expected    string
+	}{
+		{"UTF-8 BOM", "\xEF\xBB\xBF<meta charset=cp1251>", "text/html; charset=cp1250", "utf-8"},
+		{"UTF-16LE BOM", "\xFF\xFE<\x00p\x00>\x00", "", "utf-16le"},
+		{"UTF-16BE BOM", "\xFE\xFF\x00<\x00p\x00>", "", "utf-16be"},
+		{"Content-Type", `<meta charset="cp1251">`, "text/html; charset=windows-1250", "windows-1250"},
+		{"Quoted Content-Type", ``, `text/html; charset="CP1251"`, "windows-1251"},
+		{"Unknown Content-Type", `<meta charset="cp1251">`, "text/html; charset=x-unknown", "windows-1251"},
+		{"Meta Charset", `<!DOCTYPE html><html><head><meta charset="windows-1250">`, "text/html", "windows-1250"},
+		{"Meta Charset Unquoted", `<META CHARSET=Windows-1251>`, "", "windows-1251"},
+		{"Meta Http-Equiv", `<meta http-equiv="Content-Type" content="text/html; charset=windows-1251">`, "", "windows-1251"},
+		{"Meta Content First", `<meta content='text/html;charset="iso-8859-2"' http-equiv=content-type>`, "", "iso-8859-2"},
+		{"Meta Content Without Pragma", `<meta content="text/html; charset=cp1251"><p>é`, "", "utf-8"},
+		{"Meta In Comment", `<!-- <meta charset=cp1251> --><meta charset=cp1250>`, "", "windows-1250"},
+		{"Meta In Attribute", `<div title="<meta charset=cp1251>"><meta charset=cp1250>`, "", "windows-1250"},
+		{"Meta UTF-16", `<meta charset=utf-16>`, "", "utf-8"},
+		{"Meta After 1024 Bytes", strings.Repeat(" ", 1024) + `<meta charset=cp1251>`, "", "utf-8"},
+		{"Meta Cut Off", `<meta charset=cp1251`, "", "utf-8"},
+		{"Valid UTF-8", "<p>Ђурђевдан</p>", "", "utf-8"},
+		{"Invalid UTF-8", "<p>\x80\xf3\xf0\x90</p>", "", "windows-1252"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Detect([]byte(tt.input), tt.contentType); got != tt.expected {
+				t.Fatalf("Test '%s' - expected=%q, got=%q", tt.name, tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestNewReader(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		contentType string
+		encoding    string
+		expected    string
+	}{
+		{
+			name:        "Windows-1250",
+			input:       "<p>Beograd: \x9a\xf0\xe8\xe6\x9e \x8a\xd0\xc8\xc6\x8e</p>",
+			contentType: "text/html; charset=windows-1250",
+			encoding:    "windows-1250",
+			expected:    "<p>Beograd: šđčćž ŠĐČĆŽ</p>",
+		},
+		{
+			name:     "Windows-1251 From Meta",
+			input:    "<meta charset=windows-1251><p>\x80\xf3\xf0\x90\xe5\xe2\xe4\xe0\xed</p>",
+			encoding: "windows-1251",
+			expected: "<meta charset=windows-1251><p>Ђурђевдан</p>",
+		},
+		{
+			name:     "UTF-8 With BOM",
+			input:    "\xEF\xBB\xBF<p>Ђ</p>",
+			encoding: "utf-8",
+			expected: "<p>Ђ</p>",
+		},
+		{
+			name:     "Invalid UTF-8",
+			input:    "<meta charset=utf-8>a\xffb\xe2\x82",
+			encoding: "utf-8",
+			expected: "<meta charset=utf-8>a�b��",
+		},
+		{
+			name:     "UTF-16LE",
+			input:    "\xFF\xFEa\x00\x02\x04=\xD8\x00\xDE\x00\xDC",
+			encoding: "utf-16le",
+			expected: "aЂ😀�",
+		},
+		{
+			name:     "UTF-16BE",
+			input:    "\xFE\xFF\x00a\x04\x02\x00",
+			encoding: "utf-16be",
+			expected: "aЂ�",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Read a byte at a time, so that characters are split across reads
+			r, encoding, err := NewReader(iotest.OneByteReader(strings.NewReader(tt.input)), tt.contentType)
+			if err != nil {
+				t.Fatalf("Test '%s' - unexpected error: %v", tt.name, err)
+			}
+			if encoding != tt.encoding {
+				t.Fatalf("Test '%s' - encoding wrong. expected=%q, got=%q", tt.name, tt.encoding, encoding)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("Test '%s' - unexpected error: %v", tt.name, err)
+			}
+			if string(got) != tt.expected {
+				t.Fatalf("Test '%s' - expected=%q, got=%q", tt.name, tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestNewReaderLargeInput(t *testing.T) {
+	input := bytes.Repeat([]byte("<p>\x8a\x9a</p>\n"), 2000)
+	r, encoding, err := NewReader(bytes.NewReader(input), "text/html; charset=cp1250")
+	if err != nil || encoding != "windows-1250" {
+		t.Fatalf("unexpected result: encoding=%q, err=%v", encoding, err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := strings.Repeat("<p>Šš</p>\n", 2000); string(got) != expected {
+		t.Fatalf("decoded text wrong, got %d bytes", len(got))
+	}
+}
+
+func TestNewReaderError(t *testing.T) {
+	_, _, err := NewReader(iotest.ErrReader(io.ErrUnexpectedEOF), "")
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected=%v, got=%v", io.ErrUnexpectedEOF, err)
+	}
+}
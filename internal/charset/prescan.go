@@ -0,0 +1,221 @@
+package charset
+
+import "bytes"
+
+// prescan looks for the encoding in <meta charset> or <meta http-equiv>
+// tags at the start of a document, skipping comments and other tags, as in
+// "prescan a byte stream to determine its encoding" of the HTML5
+// specification. It returns "" if none of the tags names a supported
+// encoding.
+func prescan(b []byte) string {
+	s := &scanner{b: b}
+	for s.pos < len(b) {
+		switch {
+		case s.hasPrefix("<!--"):
+			// The dashes of "<!--" may also end the comment, as in "<!-->"
+			end := bytes.Index(b[s.pos+2:], []byte("-->"))
+			if end < 0 {
+				return ""
+			}
+			s.pos += 2 + end + 3
+			continue
+		case s.hasPrefix("<meta") && s.pos+5 < len(b) && (isSpace(b[s.pos+5]) || b[s.pos+5] == '/'):
+			s.pos += 5
+			if name := s.meta(); name != "" {
+				return name
+			}
+		case s.hasPrefix("<") && s.pos+1 < len(b) && isLetter(b[s.pos+1]),
+			s.hasPrefix("</") && s.pos+2 < len(b) && isLetter(b[s.pos+2]):
+			// Skip the name and the attributes of any other tag
+			for s.pos < len(b) && !isSpace(b[s.pos]) && b[s.pos] != '>' {
+				s.pos++
+			}
+			for {
+				if name, _, ok := s.attribute(); !ok || name == "" {
+					break
+				}
+			}
+		case s.hasPrefix("<!"), s.hasPrefix("</"), s.hasPrefix("<?"):
+			end := bytes.IndexByte(b[s.pos:], '>')
+			if end < 0 {
+				return ""
+			}
+			s.pos += end
+		}
+		s.pos++
+	}
+	return ""
+}
+
+// scanner reads attributes for prescan.
+type scanner struct {
+	b   []byte
+	pos int
+}
+
+// hasPrefix reports whether the input at pos starts with the lower-case
+// prefix, ignoring case.
+func (s *scanner) hasPrefix(prefix string) bool {
+	rest := s.b[s.pos:]
+	return len(rest) >= len(prefix) && bytes.EqualFold(rest[:len(prefix)], []byte(prefix))
+}
+
+// meta reads the attributes of a <meta> tag and returns the encoding they
+// name, if any. A tag cut off by the end of the input names none.
+func (s *scanner) meta() string {
+	seen := map[string]bool{}
+	gotPragma, needPragma, charset := false, false, ""
+	for {
+		name, value, ok := s.attribute()
+		if !ok {
+			return ""
+		}
+		if name == "" {
+			break
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		switch name {
+		case "http-equiv":
+			gotPragma = gotPragma || value == "content-type"
+		case "content":
+			if charset == "" {
+				if label, ok := extractCharset(value); ok {
+					charset, needPragma = Lookup(label), true
+				}
+			}
+		case "charset":
+			charset, needPragma = Lookup(value), false
+		}
+	}
+	if charset == "" || needPragma && !gotPragma {
+		return ""
+	}
+	// A document that can be read this far is not UTF-16
+	if charset == "utf-16le" || charset == "utf-16be" {
+		return "utf-8"
+	}
+	return charset
+}
+
+// attribute reads the next attribute of a tag and returns its lower-cased
+// name and value. The name is empty at the end of the tag, and ok is false
+// at the end of the input.
+func (s *scanner) attribute() (name, value string, ok bool) {
+	b := s.b
+	for s.pos < len(b) && (isSpace(b[s.pos]) || b[s.pos] == '/') {
+		s.pos++
+	}
+	if s.pos >= len(b) {
+		return "", "", false
+	}
+	if b[s.pos] == '>' {
+		return "", "", true
+	}
+
+	// Name, which may start with '='
+	var n []byte
+	for {
+		if s.pos >= len(b) {
+			return "", "", false
+		}
+		c := b[s.pos]
+		if c == '=' && len(n) > 0 || isSpace(c) || c == '/' || c == '>' {
+			break
+		}
+		n = append(n, toLower(c))
+		s.pos++
+	}
+	for s.pos < len(b) && isSpace(b[s.pos]) {
+		s.pos++
+	}
+	if s.pos >= len(b) {
+		return "", "", false
+	}
+	if b[s.pos] != '=' {
+		return string(n), "", true
+	}
+	s.pos++
+	for s.pos < len(b) && isSpace(b[s.pos]) {
+		s.pos++
+	}
+	if s.pos >= len(b) {
+		return "", "", false
+	}
+
+	// Value
+	var v []byte
+	if quote := b[s.pos]; quote == '"' || quote == '\'' {
+		end := bytes.IndexByte(b[s.pos+1:], quote)
+		if end < 0 {
+			return "", "", false
+		}
+		v = bytes.ToLower(b[s.pos+1 : s.pos+1+end])
+		s.pos += end + 2
+		return string(n), string(v), true
+	}
+	for s.pos < len(b) && !isSpace(b[s.pos]) && b[s.pos] != '>' {
+		v = append(v, toLower(b[s.pos]))
+		s.pos++
+	}
+	if s.pos >= len(b) {
+		return "", "", false
+	}
+	return string(n), string(v), true
+}
+
+// extractCharset returns the encoding label in the content attribute of a
+// <meta http-equiv="Content-Type"> tag, such as "text/html; charset=cp1251".
+func extractCharset(content string) (string, bool) {
+	b := []byte(content)
+	pos := 0
+	for {
+		i := bytes.Index(bytes.ToLower(b[pos:]), []byte("charset"))
+		if i < 0 {
+			return "", false
+		}
+		pos += i + len("charset")
+		for pos < len(b) && isSpace(b[pos]) {
+			pos++
+		}
+		if pos < len(b) && b[pos] == '=' {
+			break
+		}
+	}
+	pos++
+	for pos < len(b) && isSpace(b[pos]) {
+		pos++
+	}
+	if pos >= len(b) {
+		return "", false
+	}
+	if quote := b[pos]; quote == '"' || quote == '\'' {
+		end := bytes.IndexByte(b[pos+1:], quote)
+		if end < 0 {
+			return "", false
+		}
+		return string(b[pos+1 : pos+1+end]), true
+	}
+	end := pos
+	for end < len(b) && !isSpace(b[end]) && b[end] != ';' {
+		end++
+	}
+	return string(b[pos:end]), true
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func toLower(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
@@ -5,13 +5,52 @@ import (
 	"io"
 	"net/http"
 	"strings"
+
+	"github.com/rsolovyeaws/go-html-parser/internal/charset"
 )
 
-func FetchHTML(url string, headers map[string]string) (string, error) {
+// Body is the body of an HTML response, decoded to UTF-8.
+type Body struct {
+	io.Reader
+	closer io.Closer
+
+	// Encoding is the encoding the response was decoded from, such as
+	// "windows-1250", detected by charset.Detect.
+	Encoding string
+}
+
+// Close closes the response body.
+func (b *Body) Close() error {
+	return b.closer.Close()
+}
+
+// FetchHTML fetches url and returns the HTML it serves, decoded to UTF-8
+// from the encoding given by the response or the page itself, together with
+// the name of that encoding.
+func FetchHTML(url string, headers map[string]string) (string, string, error) {
+	body, err := FetchReader(url, headers)
+	if err != nil {
+		return "", "", err
+	}
+	defer body.Close()
+
+	// Read the response body
+	html, err := io.ReadAll(body)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read response body: %v", err)
+	}
+
+	return string(html), body.Encoding, nil
+}
+
+// FetchReader makes the same request and checks as FetchHTML but returns the
+// response body unread, so it can be parsed while it streams in. The caller
+// must close the body.
+func FetchReader(url string, headers map[string]string) (*Body, error) {
 	client := &http.Client{}
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %v", err)
+		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
 
 	// Set user-provided headers
@@ -21,25 +60,25 @@ func FetchHTML(url string, headers map[string]string) (string, error) {
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("request failed: %v", err)
+		return nil, fmt.Errorf("request failed: %v", err)
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("non-200 response: %d", resp.StatusCode)
+		resp.Body.Close()
+		return nil, fmt.Errorf("non-200 response: %d", resp.StatusCode)
 	}
 
 	// Check if content is HTML
 	contentType := resp.Header.Get("Content-Type")
 	if contentType == "" || (!strings.HasPrefix(contentType, "text/html") && !strings.HasPrefix(contentType, "application/xhtml+xml")) {
-		return "", fmt.Errorf("unexpected content type: %s", contentType)
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected content type: %s", contentType)
 	}
 
-	// Read the response body
-	body, err := io.ReadAll(resp.Body)
+	r, encoding, err := charset.NewReader(resp.Body, contentType)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %v", err)
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to read response body: %v", err)
 	}
-
-	return string(body), nil
+	return &Body{Reader: r, closer: resp.Body, Encoding: encoding}, nil
 }
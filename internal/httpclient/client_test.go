@@ -1,6 +1,7 @@
 package httpclient
 
 import (
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -74,7 +75,7 @@ func TestFetchHTML(t *testing.T) {
 			defer server.Close()
 
 			// Call FetchHTML with the test server URL and headers
-			body, err := FetchHTML(server.URL, tt.headers)
+			body, encoding, err := FetchHTML(server.URL, tt.headers)
 
 			// Validate the result
 			if tt.expectError {
@@ -88,7 +89,73 @@ func TestFetchHTML(t *testing.T) {
 				if body != tt.serverResponse {
 					t.Errorf("Expected body to be %q, got %q", tt.serverResponse, body)
 				}
+				if encoding != "utf-8" {
+					t.Errorf("Expected encoding to be %q, got %q", "utf-8", encoding)
+				}
 			}
 		})
 	}
 }
+
+func TestFetchReader(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/json" {
+			w.Header().Set("Content-Type", "application/json")
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte("<p>streamed</p>"))
+	}))
+	defer server.Close()
+
+	body, err := FetchReader(server.URL, nil)
+	if err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil || string(data) != "<p>streamed</p>" {
+		t.Errorf("Expected body to be %q, got %q (err %v)", "<p>streamed</p>", data, err)
+	}
+
+	if _, err := FetchReader(server.URL+"/json", nil); err == nil {
+		t.Errorf("Expected an error for a non-HTML response but got none")
+	}
+}
+
+func TestFetchEncoding(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/meta" {
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<meta charset=windows-1250><p>\x9a\xf0\xe8\xe6\x9e</p>"))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=windows-1251")
+		_, _ = w.Write([]byte("<p>\x80\xf3\xf0\x90\xe5\xe2\xe4\xe0\xed</p>"))
+	}))
+	defer server.Close()
+
+	html, encoding, err := FetchHTML(server.URL, nil)
+	if err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+	if html != "<p>Ђурђевдан</p>" {
+		t.Errorf("Expected body to be %q, got %q", "<p>Ђурђевдан</p>", html)
+	}
+	if encoding != "windows-1251" {
+		t.Errorf("Expected encoding to be %q, got %q", "windows-1251", encoding)
+	}
+
+	body, err := FetchReader(server.URL+"/meta", nil)
+	if err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+	defer body.Close()
+	if body.Encoding != "windows-1250" {
+		t.Errorf("Expected encoding to be %q, got %q", "windows-1250", body.Encoding)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil || string(data) != "<meta charset=windows-1250><p>šđčćž</p>" {
+		t.Errorf("Expected body to be decoded, got %q (err %v)", data, err)
+	}
+}
@@ -0,0 +1,178 @@
+package lexer
+
+import (
+	"html"
+	"unicode"
+	"unicode/utf8"
+)
+
+// legacyEntities are the named character references that may appear
+// without their semicolon, as in "&copy 2024" or "?a=1&amp=2".
+var legacyEntities = map[string]bool{
+	"AElig": true, "AMP": true, "Aacute": true, "Acirc": true, "Agrave": true,
+	"Aring": true, "Atilde": true, "Auml": true, "COPY": true, "Ccedil": true,
+	"ETH": true, "Eacute": true, "Ecirc": true, "Egrave": true, "Euml": true,
+	"GT": true, "Iacute": true, "Icirc": true, "Igrave": true, "Iuml": true,
+	"LT": true, "Ntilde": true, "Oacute": true, "Ocirc": true, "Ograve": true,
+	"Oslash": true, "Otilde": true, "Ouml": true, "QUOT": true, "REG": true,
+	"THORN": true, "Uacute": true, "Ucirc": true, "Ugrave": true, "Uuml": true,
+	"Yacute": true, "aacute": true, "acirc": true, "acute": true, "aelig": true,
+	"agrave": true, "amp": true, "aring": true, "atilde": true, "auml": true,
+	"brvbar": true, "ccedil": true, "cedil": true, "cent": true, "copy": true,
+	"curren": true, "deg": true, "divide": true, "eacute": true, "ecirc": true,
+	"egrave": true, "eth": true, "euml": true, "frac12": true, "frac14": true,
+	"frac34": true, "gt": true, "iacute": true, "icirc": true, "iexcl": true,
+	"igrave": true, "iquest": true, "iuml": true, "laquo": true, "lt": true,
+	"macr": true, "micro": true, "middot": true, "nbsp": true, "not": true,
+	"ntilde": true, "oacute": true, "ocirc": true, "ograve": true, "ordf": true,
+	"ordm": true, "oslash": true, "otilde": true, "ouml": true, "para": true,
+	"plusmn": true, "pound": true, "quot": true, "raquo": true, "reg": true,
+	"sect": true, "shy": true, "sup1": true, "sup2": true, "sup3": true,
+	"szlig": true, "thorn": true, "times": true, "uacute": true, "ucirc": true,
+	"ugrave": true, "uml": true, "uuml": true, "yacute": true, "yen": true,
+	"yuml": true,
+}
+
+// c1Replacements maps numeric references in the C1 control range to the
+// windows-1252 characters that pages using them mean.
+var c1Replacements = map[int]rune{
+	0x80: '€', 0x82: '‚', 0x83: 'ƒ', 0x84: '„',
+	0x85: '…', 0x86: '†', 0x87: '‡', 0x88: 'ˆ',
+	0x89: '‰', 0x8A: 'Š', 0x8B: '‹', 0x8C: 'Œ',
+	0x8E: 'Ž', 0x91: '‘', 0x92: '’', 0x93: '“',
+	0x94: '”', 0x95: '•', 0x96: '–', 0x97: '—',
+	0x98: '˜', 0x99: '™', 0x9A: 'š', 0x9B: '›',
+	0x9C: 'œ', 0x9E: 'ž', 0x9F: 'Ÿ',
+}
+
+// SetKeepEntities makes the lexer leave character references such as
+// "&amp;" in text and attribute values as they are instead of decoding them.
+func (l *Lexer) SetKeepEntities(keep bool) {
+	l.keepEntities = keep
+}
+
+// readCharRef reads the character reference at the current '&' and returns
+// the text it stands for, or the characters read if they are not a
+// reference. In attribute values a legacy reference without its semicolon
+// is left alone when followed by '=' or an alphanumeric, so that URLs such
+// as "?x=1&not=2" keep their meaning.
+func (l *Lexer) readCharRef(inAttribute bool) string {
+	start := l.position
+	l.readChar() // Consume '&'
+	if l.ch == '#' {
+		return l.readNumericCharRef(start)
+	}
+
+	nameStart := l.position
+	for isLetter(l.ch) || isDigit(l.ch) {
+		l.readChar()
+	}
+	name := l.slice(nameStart)
+	if name == "" {
+		return "&"
+	}
+	if l.ch == ';' {
+		if value, ok := lookupEntity(name); ok {
+			l.readChar() // Consume ';'
+			return value
+		}
+	}
+
+	// The longest legacy reference the name starts with
+	for k := len(name); k > 0; k-- {
+		if !legacyEntities[name[:k]] {
+			continue
+		}
+		next := l.ch
+		if k < len(name) {
+			next = name[k]
+		}
+		if inAttribute && (next == '=' || isLetter(next) || isDigit(next)) {
+			return l.slice(start)
+		}
+		l.reportError("missing-semicolon-after-character-reference")
+		value, _ := lookupEntity(name[:k])
+		return value + name[k:]
+	}
+
+	if l.ch == ';' {
+		l.reportError("unknown-named-character-reference")
+	}
+	return l.slice(start)
+}
+
+// readNumericCharRef reads a reference such as "&#38;" or "&#x26;" whose
+// '&' is at start and returns the character it stands for. Invalid code
+// points become U+FFFD and C1 controls their windows-1252 characters.
+func (l *Lexer) readNumericCharRef(start int) string {
+	l.readChar() // Consume '#'
+	base := 10
+	if l.ch == 'x' || l.ch == 'X' {
+		base = 16
+		l.readChar()
+	}
+
+	digitsStart := l.position
+	code := 0
+	for d := digitValue(l.ch, base); d >= 0; d = digitValue(l.ch, base) {
+		if code <= unicode.MaxRune { // Stop growing once out of range
+			code = code*base + d
+		}
+		l.readChar()
+	}
+	if l.position == digitsStart {
+		l.reportError("absence-of-digits-in-numeric-character-reference")
+		return l.slice(start)
+	}
+	if l.ch == ';' {
+		l.readChar()
+	} else {
+		l.reportError("missing-semicolon-after-character-reference")
+	}
+
+	switch {
+	case code == 0:
+		l.reportError("null-character-reference")
+		return "\uFFFD"
+	case code > unicode.MaxRune:
+		l.reportError("character-reference-outside-unicode-range")
+		return "\uFFFD"
+	case code >= 0xD800 && code <= 0xDFFF:
+		l.reportError("surrogate-character-reference")
+		return "\uFFFD"
+	case (code >= 0xFDD0 && code <= 0xFDEF) || code&0xFFFE == 0xFFFE:
+		l.reportError("noncharacter-character-reference")
+	case code == '\r' || unicode.IsControl(rune(code)) && !isSpace(byte(code)):
+		l.reportError("control-character-reference")
+	}
+	if r, ok := c1Replacements[code]; ok {
+		return string(r)
+	}
+	return string(rune(code))
+}
+
+// lookupEntity returns the text of the named reference "&name;". The names
+// come from the table of the html package: html.UnescapeString decodes a
+// known name to one or two characters, while an unknown one is left as is
+// or only has a legacy prefix decoded, leaving at least "x;" behind.
+func lookupEntity(name string) (string, bool) {
+	ref := "&" + name + ";"
+	value := html.UnescapeString(ref)
+	if value == ref || utf8.RuneCountInString(value) > 2 {
+		return "", false
+	}
+	return value, true
+}
+
+// digitValue returns the value of ch as a digit in base 10 or 16, or -1.
+func digitValue(ch byte, base int) int {
+	switch {
+	case isDigit(ch):
+		return int(ch - '0')
+	case base == 16 && ch >= 'a' && ch <= 'f':
+		return int(ch-'a') + 10
+	case base == 16 && ch >= 'A' && ch <= 'F':
+		return int(ch-'A') + 10
+	}
+	return -1
+}
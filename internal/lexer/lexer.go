@@ -1,7 +1,9 @@
 package lexer
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"strings"
 )
 
@@ -11,55 +13,277 @@ const (
 	TokenSelfClosingTag = "SelfClosingTag"
 	TokenText           = "Text"
 	TokenComment        = "Comment"
+	TokenDoctype        = "Doctype"
 	TokenEOF            = "EOF"
 	doctypeDeclaration  = "DOCTYPE"
 	commentOpen         = "<!--"
 	commentClose        = "-->"
+	cdataOpen           = "<![CDATA["
+	cdataClose          = "]]>"
 )
 
+// Token is a unit of HTML read by the lexer. Tag and attribute names are
+// lower-cased, as HTML names are case-insensitive.
 type Token struct {
 	Type       string
 	Value      string
-	Position   int               // Position in input for debugging
-	Attributes map[string]string // Add this field for tag attributes
+	Position   int         // Position in input for debugging (same as Span.Start.Offset)
+	Span       Span        // Where the token starts and ends in the input
+	Attributes []Attribute // Attributes of start tags, in source order
+
+	// Doctype tokens only; the doctype name is in Value
+	PublicID    string
+	SystemID    string
+	HasSystemID bool // SystemID was given, possibly empty
+	ForceQuirks bool // The doctype was malformed
+}
+
+// Attribute is a name and value from a start tag. Only the first of several
+// attributes with the same name is kept, as HTML requires.
+type Attribute struct {
+	Name      string
+	Value     string
+	Namespace string // Namespace URL of foreign attributes such as xlink:href, set by the parser
+	Quote     byte   // The quote the value was written in: '"', '\'' or 0 if unquoted or missing
+}
+
+// Attr returns the value of the attribute named name and whether the token
+// has it.
+func (t Token) Attr(name string) (string, bool) {
+	for _, a := range t.Attributes {
+		if a.Name == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// Pos is a location in the input. Offset is in bytes; Line and Column are
+// 1-based and Column counts characters rather than bytes. Positions are in
+// the input after "\r\n" and lone "\r" are turned into "\n", so they count
+// each line break as one byte.
+type Pos struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("line %d, col %d", p.Line, p.Column)
+}
+
+// Span is the range of input between Start (inclusive) and End (exclusive).
+type Span struct {
+	Start Pos
+	End   Pos
+}
+
+// Error is a recoverable problem found while tokenizing. Code is the WHATWG
+// parse error code, such as "eof-in-comment" or "duplicate-attribute".
+type Error struct {
+	Code string
+	Pos  Pos
 }
 
 type Lexer struct {
-	input        string // The HTML input
-	position     int    // Current position in the input
-	readPosition int    // Position after the current character
-	ch           byte   // Current character
+	input        []byte    // The HTML input, or the unconsumed window of a stream
+	offset       int       // Stream offset of input[0]
+	reader       io.Reader // Source of more input; nil once it is exhausted
+	err          error     // First read error from reader
+	position     int       // Current position in the input
+	readPosition int       // Position after the current character
+	ch           byte      // Current character
+	line         int       // Line of the current character
+	column       int       // Column of the current character
+	rawTag       string    // Element whose contents are being read as raw text
+	keepEntities bool      // Leave character references undecoded
+	allowCDATA   bool      // Read CDATA sections, which only foreign content has
+	cr           bool      // The input read so far ends with "\r"
+	onError      func(Error)
+}
+
+// readChunkSize is how much NewReader lexers read from their source at once
+const readChunkSize = 32 * 1024
+
+// rawTextElements hold text that is never parsed as markup: everything up to
+// the matching end tag is emitted as a single text token. textarea and title
+// (RCDATA) are lexed the same way, except that their character references
+// are decoded.
+var rawTextElements = map[string]bool{
+	"script": true, "style": true, "xmp": true, "iframe": true,
+	"noembed": true, "noframes": true, "plaintext": true,
+	"textarea": true, "title": true,
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	l := &Lexer{line: 1, column: 1}
+	l.input, l.cr = normalizeNewlines([]byte(input), false)
 	l.readChar()
 	return l
 }
 
+// NewReader creates a Lexer that tokenizes incrementally from r. Only the
+// token being read is buffered, so large documents are never held in memory
+// as a whole. Read errors end the token stream; check Err afterwards.
+func NewReader(r io.Reader) *Lexer {
+	l := &Lexer{reader: r, line: 1, column: 1}
+	l.readChar()
+	return l
+}
+
+// SetErrorHandler makes the lexer report parse errors to fn as it finds
+// them. By default they are ignored.
+func (l *Lexer) SetErrorHandler(fn func(Error)) {
+	l.onError = fn
+}
+
+// reportError reports a parse error at the current character.
+func (l *Lexer) reportError(code string) {
+	if l.onError != nil {
+		l.onError(Error{Code: code, Pos: l.pos()})
+	}
+}
+
+// Err returns the first error, other than io.EOF, returned by the reader
+// given to NewReader.
+func (l *Lexer) Err() error {
+	return l.err
+}
+
 //////////////////////////
 // Character Processing //
 //////////////////////////
 
+// fill makes sure at least n bytes are buffered, reading more from the
+// reader if needed. It reports whether that many bytes are available.
+func (l *Lexer) fill(n int) bool {
+	for len(l.input) < n && l.reader != nil {
+		if cap(l.input)-len(l.input) < readChunkSize {
+			grown := make([]byte, len(l.input), 2*cap(l.input)+readChunkSize)
+			copy(grown, l.input)
+			l.input = grown
+		}
+		read, err := l.reader.Read(l.input[len(l.input):cap(l.input)])
+		var chunk []byte
+		chunk, l.cr = normalizeNewlines(l.input[len(l.input):len(l.input)+read], l.cr)
+		l.input = l.input[:len(l.input)+len(chunk)]
+		if err != nil {
+			if err != io.EOF {
+				l.err = err
+			}
+			l.reader = nil
+		}
+	}
+	return len(l.input) >= n
+}
+
+// normalizeNewlines turns "\r\n" and lone "\r" in b into "\n" in place, as
+// the HTML5 specification preprocesses its input, and returns the result.
+// cr tells whether the input before b ended with "\r", so that a "\n" at the
+// start of b is dropped; the returned flag tells the same about b.
+func normalizeNewlines(b []byte, cr bool) ([]byte, bool) {
+	if len(b) == 0 {
+		return b, cr
+	}
+	if !cr && bytes.IndexByte(b, '\r') < 0 {
+		return b, false
+	}
+	out := b[:0]
+	for _, c := range b {
+		switch {
+		case c == '\n' && cr:
+			// The "\r" before it already became the line break
+		case c == '\r':
+			out = append(out, '\n')
+		default:
+			out = append(out, c)
+		}
+		cr = c == '\r'
+	}
+	return out, cr
+}
+
+// discard drops the consumed part of a streamed input between tokens.
+func (l *Lexer) discard() {
+	if l.position == 0 || l.position > len(l.input) {
+		return
+	}
+	l.offset += l.position
+	l.input = l.input[l.position:]
+	l.readPosition -= l.position
+	l.position = 0
+}
+
+// slice returns the input from start to the current position
+func (l *Lexer) slice(start int) string {
+	return string(l.input[start:l.position])
+}
+
 func (l *Lexer) readChar() {
+	l.fill(l.readPosition + 1)
+
+	// Move the line and column past the character being left behind
+	if l.readPosition > 0 && l.position < len(l.input) {
+		if l.ch == '\n' {
+			l.line++
+			l.column = 1
+		} else if l.readPosition >= len(l.input) || !isContinuationByte(l.input[l.readPosition]) {
+			l.column++
+		}
+	}
+
 	if l.readPosition >= len(l.input) {
 		l.ch = 0 // ASCII code for "NUL"
-	} else {
-		l.ch = l.input[l.readPosition]
+		l.position = len(l.input)
+		l.readPosition = len(l.input) + 1
+		return
 	}
+	l.ch = l.input[l.readPosition]
 	l.position = l.readPosition
 	l.readPosition++
 }
 
+// eof reports whether all input has been consumed. A NUL byte in the input
+// is an ordinary character, so l.ch == 0 alone does not mean EOF.
+func (l *Lexer) eof() bool {
+	return l.position >= len(l.input)
+}
+
+// hasPrefix reports whether the input at the current position starts with s
+func (l *Lexer) hasPrefix(s string) bool {
+	l.fill(l.position + len(s))
+	return bytes.HasPrefix(l.input[l.position:], []byte(s))
+}
+
+// hasPrefixFold is hasPrefix ignoring ASCII case
+func (l *Lexer) hasPrefixFold(s string) bool {
+	if !l.fill(l.position + len(s)) {
+		return false
+	}
+	return strings.EqualFold(string(l.input[l.position:l.position+len(s)]), s)
+}
+
 func (l *Lexer) peekChar() byte {
-	if l.readPosition >= len(l.input) {
+	if !l.fill(l.readPosition + 1) {
 		return 0
 	}
 	return l.input[l.readPosition]
 }
 
+// advance reads n characters
+func (l *Lexer) advance(n int) {
+	for i := 0; i < n; i++ {
+		l.readChar()
+	}
+}
+
+// pos returns the location of the current character
+func (l *Lexer) pos() Pos {
+	return Pos{Offset: l.offset + l.position, Line: l.line, Column: l.column}
+}
+
 func (l *Lexer) skipWhitespace() {
-	for l.ch == ' ' || l.ch == '\n' || l.ch == '\t' || l.ch == '\r' {
+	for isSpace(l.ch) {
 		l.readChar()
 	}
 }
@@ -68,111 +292,292 @@ func (l *Lexer) skipWhitespace() {
 // Token Processing //
 //////////////////////
 
+// SetRawTextElement makes the lexer read the input as the contents of the
+// named element when that element holds raw text, as it does after a
+// <script> or <textarea> start tag. Fragment parsers call it before the first
+// NextToken to lex the input in the context element's state. Any other name,
+// such as "", makes the lexer read markup as usual, which the parser needs
+// after a start tag it ignores or an SVG <style> or <title> start tag.
+func (l *Lexer) SetRawTextElement(name string) {
+	if name = strings.ToLower(name); rawTextElements[name] {
+		l.rawTag = name
+	} else {
+		l.rawTag = ""
+	}
+}
+
+// SetAllowCDATA makes the lexer read <![CDATA[...]]> sections as text. The
+// parser allows them inside SVG and MathML; elsewhere they are bogus
+// comments.
+func (l *Lexer) SetAllowCDATA(allow bool) {
+	l.allowCDATA = allow
+}
+
 func (l *Lexer) NextToken() Token {
-	// Skip leading whitespace only when outside of text
-	if l.ch == '<' {
-		switch {
-		case l.peekChar() == '/':
-			l.readChar()
-			return l.readEndTag()
-		case l.peekChar() == '!':
-			if l.input[l.position+2:l.position+9] == doctypeDeclaration {
-				return l.readDoctype()
-			}
-			return l.readComment()
-		default:
-			return l.readStartTag()
+	l.discard()
+	start := l.pos()
+	tok := l.readToken()
+	tok.Position = start.Offset
+	tok.Span = Span{Start: start, End: l.pos()}
+	return tok
+}
+
+func (l *Lexer) readToken() Token {
+	// Contents of script, style, textarea etc. are not markup
+	if l.rawTag != "" {
+		tok := l.readRawText()
+		l.rawTag = ""
+		if tok.Value != "" {
+			return tok
 		}
-	} else if l.ch == 0 {
+	}
+
+	if l.eof() {
 		return Token{Type: TokenEOF, Value: ""}
-	} else {
+	}
+	if !l.atMarkup() {
 		return l.readText()
 	}
+
+	switch next := l.peekChar(); {
+	case next == '/':
+		return l.readEndTag()
+	case l.hasPrefix(commentOpen):
+		return l.readComment()
+	case l.hasPrefixFold("<!" + doctypeDeclaration):
+		return l.readDoctype()
+	case l.hasPrefix(cdataOpen) && l.allowCDATA:
+		return l.readCDATA()
+	case l.hasPrefix(cdataOpen):
+		l.advance(2) // Consume '<!'
+		l.reportError("cdata-in-html-content")
+		return l.readBogusComment()
+	case next == '!':
+		l.advance(2) // Consume '<!'
+		l.reportError("incorrectly-opened-comment")
+		return l.readBogusComment()
+	case next == '?':
+		l.readChar() // Consume '<', the '?' is part of the comment
+		l.reportError("unexpected-question-mark-instead-of-tag-name")
+		return l.readBogusComment()
+	default:
+		return l.readStartTag()
+	}
 }
 
+// atMarkup reports whether the current '<' starts a tag, comment or
+// declaration. Per HTML5 any other '<', as in "a < b", is text.
+func (l *Lexer) atMarkup() bool {
+	if l.ch != '<' {
+		return false
+	}
+	next := l.peekChar()
+	return isLetter(next) || next == '/' || next == '!' || next == '?'
+}
+
+// readDoctype reads a doctype declaration such as
+// <!DOCTYPE html PUBLIC "-//W3C//DTD HTML 4.01//EN" "http://www.w3.org/TR/html4/strict.dtd">.
+// The lower-cased name is the token value. Malformed declarations set
+// ForceQuirks, as browsers then render the page in quirks mode.
 func (l *Lexer) readDoctype() Token {
-	l.readChar() // Consume '<'
-	l.readChar() // Consume '!'
+	l.advance(len("<!" + doctypeDeclaration))
+	tok := Token{Type: TokenDoctype}
 
+	if !isSpace(l.ch) && l.ch != '>' && !l.eof() {
+		l.reportError("missing-whitespace-before-doctype-name")
+	}
+	l.skipWhitespace()
 	start := l.position
-	for l.ch != '>' && l.ch != 0 {
+	for l.ch != '>' && !isSpace(l.ch) && !l.eof() {
 		l.readChar()
 	}
+	tok.Value = strings.ToLower(l.slice(start))
+	if tok.Value == "" {
+		if l.ch == '>' {
+			l.reportError("missing-doctype-name")
+		}
+		tok.ForceQuirks = true
+	}
 
-	value := l.input[start:l.position]
-	l.readChar() // Consume '>'
-	return Token{Type: TokenComment, Value: value}
-}
-
-func (l *Lexer) readStartTag() Token {
-	l.readChar() // Consume '<'
-	tagName := l.readIdentifier()
-
-	attributes := make(map[string]string)
-	var fullTag strings.Builder
-	fullTag.WriteString(tagName) // Start with the tag name
-
-	for {
-		l.skipWhitespace()
-		if l.ch == '/' && l.peekChar() == '>' {
-			return l.readSelfClosingTag(tagName, attributes)
+	l.skipWhitespace()
+	var missing bool
+	switch {
+	case l.ch == '>' || l.eof():
+	case l.hasPrefixFold("PUBLIC"):
+		l.advance(len("PUBLIC"))
+		if !l.skipKeywordSpace("public") {
+			missing = true
+			break
 		}
-		if l.ch == '>' {
+		if tok.PublicID, missing = l.readDoctypeID("public"); missing {
 			break
 		}
-		key := l.readIdentifier()
-		var value string
-		isQuoted := false
-		if l.ch == '=' {
-			l.readChar() // Consume '='
-			if l.ch == '"' || l.ch == '\'' {
-				isQuoted = true
-				quote := l.ch
-				l.readChar() // Consume opening quote
-				value = l.readUntil(string(quote))
-				l.readChar() // Consume closing quote
-			} else {
-				value = l.readIdentifier()
+		spaced := isSpace(l.ch)
+		l.skipWhitespace()
+		if l.ch == '"' || l.ch == '\'' {
+			if !spaced {
+				l.reportError("missing-whitespace-between-doctype-public-and-system-identifiers")
 			}
+			tok.HasSystemID = true
+			tok.SystemID, missing = l.readDoctypeID("system")
+		} else if l.ch != '>' && !l.eof() {
+			l.reportError("missing-quote-before-doctype-system-identifier")
+			missing = true
 		}
-		attributes[key] = value
-
-		// Append the key-value pair to the tag string
-		if value != "" {
-			if isQuoted {
-				fullTag.WriteString(fmt.Sprintf(` %s="%s"`, key, value))
-			} else {
-				fullTag.WriteString(fmt.Sprintf(` %s=%s`, key, value))
-			}
-		} else {
-			fullTag.WriteString(fmt.Sprintf(" %s", key))
+	case l.hasPrefixFold("SYSTEM"):
+		l.advance(len("SYSTEM"))
+		if !l.skipKeywordSpace("system") {
+			missing = true
+			break
 		}
+		tok.HasSystemID = l.ch == '"' || l.ch == '\''
+		tok.SystemID, missing = l.readDoctypeID("system")
+	default:
+		l.reportError("invalid-character-sequence-after-doctype-name")
+		missing = true
+	}
+	if missing {
+		tok.ForceQuirks = true
+	} else if l.skipWhitespace(); l.ch != '>' && !l.eof() {
+		l.reportError("unexpected-character-after-doctype-system-identifier")
 	}
 
+	// Anything else up to the '>' is ignored
+	for l.ch != '>' && !l.eof() {
+		l.readChar()
+	}
+	if l.eof() {
+		l.reportError("eof-in-doctype")
+		tok.ForceQuirks = true
+	}
 	l.readChar() // Consume '>'
+	return tok
+}
 
-	return Token{
-		Type:       TokenStartTag,
-		Value:      tagName, // fullTag.String(),
-		Attributes: attributes,
+// skipKeywordSpace skips the whitespace after a PUBLIC or SYSTEM keyword. It
+// reports whether an identifier may follow.
+func (l *Lexer) skipKeywordSpace(keyword string) bool {
+	switch {
+	case l.ch == '>':
+		l.reportError("missing-doctype-" + keyword + "-identifier")
+		return false
+	case l.ch == '"' || l.ch == '\'':
+		l.reportError("missing-whitespace-after-doctype-" + keyword + "-keyword")
+	}
+	l.skipWhitespace()
+	return true
+}
+
+// readDoctypeID reads the quoted public or system identifier named by kind.
+// It reports whether the identifier was missing or cut short by a '>'.
+func (l *Lexer) readDoctypeID(kind string) (string, bool) {
+	switch {
+	case l.eof():
+		return "", true
+	case l.ch == '>':
+		l.reportError("missing-doctype-" + kind + "-identifier")
+		return "", true
+	case l.ch != '"' && l.ch != '\'':
+		l.reportError("missing-quote-before-doctype-" + kind + "-identifier")
+		return "", true
 	}
+	quote := l.ch
+	l.readChar() // Consume the opening quote
+	start := l.position
+	for l.ch != quote && l.ch != '>' && !l.eof() {
+		l.readChar()
+	}
+	id := l.slice(start)
+	if l.ch == '>' {
+		l.reportError("abrupt-doctype-" + kind + "-identifier")
+	}
+	if l.ch != quote {
+		return id, true
+	}
+	l.readChar() // Consume the closing quote
+	return id, false
+}
+
+// readCDATA reads a CDATA section as a text token holding its contents
+// verbatim.
+func (l *Lexer) readCDATA() Token {
+	l.advance(len(cdataOpen))
+	text := l.readUntil(cdataClose)
+	if l.eof() {
+		l.reportError("eof-in-cdata")
+	} else {
+		l.advance(len(cdataClose))
+	}
+	return Token{Type: TokenText, Value: text}
 }
 
-func (l *Lexer) readSelfClosingTag(tagName string, attributes map[string]string) Token {
-	l.readChar() // Consume '/'
+// readBogusComment turns malformed markup such as "<!x>", "<?xml ?>" or
+// "</1>" into a comment running to the next '>'. The lexer is positioned at
+// the start of the comment text.
+func (l *Lexer) readBogusComment() Token {
+	start := l.position
+	for l.ch != '>' && !l.eof() {
+		l.readChar()
+	}
+	value := l.slice(start)
 	l.readChar() // Consume '>'
+	return Token{Type: TokenComment, Value: value}
+}
+
+func (l *Lexer) readStartTag() Token {
+	l.readChar() // Consume '<'
+	tagName := l.readTagName()
+
+	attributes, selfClosing, ok := l.readAttributes()
+	if !ok {
+		// A tag cut off by the end of input is dropped
+		return Token{Type: TokenEOF, Value: ""}
+	}
+	// The '/' of "<script/>" is ignored, so its contents are raw text too.
+	// The parser clears rawTag again for elements it does not insert as raw
+	// text, such as an SVG <style/>, which the '/' does close.
+	if rawTextElements[tagName] {
+		l.rawTag = tagName
+	}
+	if selfClosing {
+		return Token{Type: TokenSelfClosingTag, Value: tagName, Attributes: attributes}
+	}
 
 	return Token{
-		Type:       TokenSelfClosingTag,
+		Type:       TokenStartTag,
 		Value:      tagName,
 		Attributes: attributes,
 	}
 }
 
 func (l *Lexer) readEndTag() Token {
-	l.readChar()                  // Consume '<' and '/'
-	tagName := l.readIdentifier() // Read the tag name
-	l.readChar()                  // Consume '>'
+	l.advance(2) // Consume '<' and '/'
+	switch {
+	case l.eof():
+		l.reportError("eof-before-tag-name")
+		return Token{Type: TokenText, Value: "</"}
+	case l.ch == '>':
+		l.reportError("missing-end-tag-name")
+		l.readChar() // "</>" is ignored entirely
+		return l.readToken()
+	case !isLetter(l.ch):
+		l.reportError("invalid-first-character-of-tag-name")
+		return l.readBogusComment()
+	}
+
+	tagName := l.readTagName()
+	l.skipWhitespace()
+	switch {
+	case l.ch == '/' && l.peekChar() == '>':
+		l.reportError("end-tag-with-trailing-solidus")
+	case l.ch != '>' && !l.eof():
+		l.reportError("end-tag-with-attributes")
+	}
+	// Attributes are read like those of a start tag, so that a '>' in a
+	// quoted value does not end the tag, and then dropped
+	if _, _, ok := l.readAttributes(); !ok {
+		return Token{Type: TokenEOF, Value: ""}
+	}
 	return Token{Type: TokenEndTag, Value: tagName}
 }
 
@@ -182,51 +587,137 @@ func (l *Lexer) readComment() Token {
 	l.readChar() // Consume '-'
 	l.readChar() // Consume '-'
 
-	start := l.position
-	depth := 1
+	// "<!-->" and "<!--->" are empty comments
+	if l.ch == '>' || l.hasPrefix("->") {
+		l.reportError("abrupt-closing-of-empty-comment")
+		for l.ch != '>' {
+			l.readChar()
+		}
+		l.readChar() // Consume '>'
+		return Token{Type: TokenComment, Value: ""}
+	}
 
+	// The comment ends at the first "-->", or "--!>". A "<!--" inside it is
+	// reported but does not nest.
+	start := l.position
+	closeLen := 0
 	for {
-		// Check if we've reached the end of input
-		if l.position >= len(l.input) {
+		if l.eof() {
+			l.reportError("eof-in-comment")
 			break
 		}
-
-		// Handle nested `<!--` safely
-		if l.position+4 <= len(l.input) && l.input[l.position:l.position+4] == "<!--" {
-			depth++
-			l.position += 4
-			continue
+		if l.hasPrefix(commentClose) {
+			closeLen = len(commentClose)
+			break
 		}
-
-		// Handle closing `-->` safely
-		if l.position+3 <= len(l.input) && l.input[l.position:l.position+3] == "-->" {
-			depth--
-			if depth == 0 {
-				break
-			}
-			l.position += 3
+		if l.hasPrefix("--!>") {
+			l.reportError("incorrectly-closed-comment")
+			closeLen = len("--!>")
+			break
+		}
+		// The dashes of "<!-->" close the comment instead
+		if l.hasPrefix(commentOpen) && !l.hasPrefix("<!-->") {
+			l.reportError("nested-comment")
+			l.advance(len(commentOpen))
 			continue
 		}
-
 		l.readChar()
 	}
 
 	// Extract the comment value and trim spaces
-	comment := trimSpaces(l.input[start:l.position])
-	l.readChar() // Consume '-'
-	l.readChar() // Consume '-'
-	l.readChar() // Consume '>'
+	comment := trimSpaces(l.slice(start))
+	l.advance(closeLen)
 
 	return Token{Type: TokenComment, Value: comment}
 }
 
 func (l *Lexer) readText() Token {
+	var text strings.Builder
 	start := l.position
-	for l.ch != '<' && l.ch != 0 { // Read until a '<' or EOF
+	for {
+		if l.ch == '&' && !l.keepEntities {
+			text.WriteString(l.slice(start))
+			text.WriteString(l.readCharRef(false))
+			start = l.position
+		} else {
+			switch {
+			case l.ch == '<' && !l.fill(l.readPosition+1):
+				l.reportError("eof-before-tag-name")
+			case l.ch == '<': // The first character is text even if it is a '<'
+				l.reportError("invalid-first-character-of-tag-name")
+			case l.ch == 0:
+				l.reportError("unexpected-null-character")
+			}
+			l.readChar()
+		}
+		if l.atMarkup() || l.eof() { // Read until markup or EOF
+			break
+		}
+	}
+	text.WriteString(l.slice(start))
+	return Token{Type: TokenText, Value: text.String()}
+}
+
+// readRawText reads the contents of a raw text or RCDATA element up to, but
+// not including, its end tag. Script contents additionally follow the HTML5
+// escaped states, so "<!-- <script></script> -->" does not end the script.
+func (l *Lexer) readRawText() Token {
+	var text strings.Builder
+	start := l.position
+	escaped, doubleEscaped := false, false
+	rcdata := l.rawTag == "textarea" || l.rawTag == "title"
+
+	for !l.eof() && l.rawTag != "plaintext" {
+		if l.ch == '<' && l.peekChar() == '/' && l.isTagNameAt(l.position+2, l.rawTag) {
+			if !doubleEscaped {
+				break
+			}
+			doubleEscaped = false // "</script>" only leaves the inner script
+		}
+		if rcdata && l.ch == '&' && !l.keepEntities {
+			text.WriteString(l.slice(start))
+			text.WriteString(l.readCharRef(false))
+			start = l.position
+			continue
+		}
+		if l.rawTag == "script" {
+			switch {
+			case !escaped && l.hasPrefix(commentOpen):
+				escaped = true
+			case escaped && !doubleEscaped && l.ch == '<' && l.isTagNameAt(l.position+1, "script"):
+				doubleEscaped = true
+			case escaped && l.hasPrefix(commentClose):
+				escaped, doubleEscaped = false, false
+			}
+		}
 		l.readChar()
 	}
-	text := l.input[start:l.position] // Extract raw text
-	return Token{Type: TokenText, Value: text}
+
+	if l.rawTag == "plaintext" {
+		for !l.eof() {
+			l.readChar()
+		}
+	}
+	if escaped && l.eof() {
+		l.reportError("eof-in-script-html-comment-like-text")
+	}
+
+	text.WriteString(l.slice(start))
+	return Token{Type: TokenText, Value: text.String()}
+}
+
+// isTagNameAt reports whether name (case-insensitively) starts at pos and is
+// followed by a character that terminates a tag name.
+func (l *Lexer) isTagNameAt(pos int, name string) bool {
+	end := pos + len(name)
+	if !l.fill(end+1) || !strings.EqualFold(string(l.input[pos:end]), name) {
+		return false
+	}
+	switch l.input[end] {
+	case ' ', '\n', '\t', '\r', '\f', '/', '>':
+		return true
+	}
+	return false
 }
 
 ////////////////////////
@@ -235,32 +726,148 @@ func (l *Lexer) readText() Token {
 
 func (l *Lexer) readUntil(stop string) string {
 	start := l.position
+	for !l.eof() && !l.hasPrefix(stop) {
+		l.readChar()
+	}
+	return l.slice(start)
+}
+
+// readTagName reads a lower-cased tag name, which runs to whitespace, '/'
+// or '>' like an attribute name, so that names such as "o:p" are kept whole.
+func (l *Lexer) readTagName() string {
+	start := l.position
+	for !l.eof() && !isSpace(l.ch) && l.ch != '/' && l.ch != '>' {
+		if l.ch == 0 {
+			l.reportError("unexpected-null-character")
+		}
+		l.readChar()
+	}
+	return replaceNull(strings.ToLower(l.slice(start)))
+}
+
+// readAttributes reads the attributes of a tag and the '>' closing it,
+// following the HTML5 attribute states: names run to whitespace, '/', '>'
+// or '=', and unquoted values run to whitespace or '>'. It reports whether
+// the tag ended with "/>", and ok is false if the input ended inside the tag.
+func (l *Lexer) readAttributes() (attributes []Attribute, selfClosing, ok bool) {
 	for {
-		if l.position >= len(l.input) {
-			break
+		l.skipWhitespace()
+		switch {
+		case l.eof():
+			l.reportError("eof-in-tag")
+			return attributes, false, false
+		case l.ch == '>':
+			l.readChar()
+			return attributes, false, true
+		case l.ch == '/':
+			l.readChar()
+			if l.ch == '>' {
+				l.readChar()
+				return attributes, true, true
+			}
+			if !l.eof() {
+				l.reportError("unexpected-solidus-in-tag")
+			}
+			continue
 		}
-		if l.input[l.position:l.position+len(stop)] == stop {
-			break
+
+		name := l.readAttributeName()
+		duplicate := hasAttribute(attributes, name)
+		if duplicate {
+			l.reportError("duplicate-attribute")
+		}
+		l.skipWhitespace()
+
+		var value string
+		var quote byte
+		if l.ch == '=' {
+			l.readChar() // Consume '='
+			l.skipWhitespace()
+			switch l.ch {
+			case '"', '\'':
+				quote = l.ch
+				l.readChar() // Consume the opening quote
+				value = l.readAttributeValue(func(ch byte) bool { return ch == quote })
+				if l.eof() {
+					l.reportError("eof-in-tag")
+					return attributes, false, false
+				}
+				l.readChar() // Consume the closing quote
+				if !isSpace(l.ch) && l.ch != '/' && l.ch != '>' && !l.eof() {
+					l.reportError("missing-whitespace-between-attributes")
+				}
+			case '>':
+				l.reportError("missing-attribute-value")
+			default:
+				value = l.readAttributeValue(func(ch byte) bool {
+					if ch == '"' || ch == '\'' || ch == '<' || ch == '=' || ch == '`' {
+						l.reportError("unexpected-character-in-unquoted-attribute-value")
+					}
+					return isSpace(ch) || ch == '>'
+				})
+			}
+		}
+		if !duplicate {
+			attributes = append(attributes, Attribute{Name: name, Value: value, Quote: quote})
 		}
-		l.readChar()
 	}
-	return l.input[start:l.position]
 }
 
-func (l *Lexer) readIdentifier() string {
+// readAttributeName reads a lower-cased attribute name. A leading '=' is
+// part of the name, as in <p =x>.
+func (l *Lexer) readAttributeName() string {
 	start := l.position
-	for isLetter(l.ch) || isDigit(l.ch) || l.ch == '-' || l.ch == '_' {
+	if l.ch == '=' {
+		l.reportError("unexpected-equals-sign-before-attribute-name")
+		l.readChar()
+	}
+	for !l.eof() && !isSpace(l.ch) && l.ch != '/' && l.ch != '>' && l.ch != '=' {
+		switch l.ch {
+		case '"', '\'', '<':
+			l.reportError("unexpected-character-in-attribute-name")
+		case 0:
+			l.reportError("unexpected-null-character")
+		}
 		l.readChar()
 	}
-	return l.input[start:l.position]
+	return replaceNull(strings.ToLower(l.slice(start)))
 }
 
-func (l *Lexer) readAttributes() string {
+// readAttributeValue reads an attribute value up to the first character for
+// which stop returns true, or the end of input, and decodes its character
+// references.
+func (l *Lexer) readAttributeValue(stop func(ch byte) bool) string {
+	var value strings.Builder
 	start := l.position
-	for l.ch != '>' && l.ch != '/' && l.ch != 0 {
+	for !l.eof() && !stop(l.ch) {
+		switch {
+		case l.ch == '&' && !l.keepEntities:
+			value.WriteString(replaceNull(l.slice(start)))
+			value.WriteString(l.readCharRef(true))
+			start = l.position
+			continue
+		case l.ch == 0:
+			l.reportError("unexpected-null-character")
+		}
 		l.readChar()
 	}
-	return trimSpaces(l.input[start:l.position])
+	value.WriteString(replaceNull(l.slice(start)))
+	return value.String()
+}
+
+// replaceNull replaces NUL characters, which attributes may not contain, with
+// U+FFFD.
+func replaceNull(s string) string {
+	return strings.ReplaceAll(s, "\x00", "\uFFFD")
+}
+
+func hasAttribute(attributes []Attribute, name string) bool {
+	for _, a := range attributes {
+		if a.Name == name {
+			return true
+		}
+	}
+	return false
 }
 
 func trimSpaces(s string) string {
@@ -282,6 +889,14 @@ func isLetter(ch byte) bool {
 	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
 }
 
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\n' || ch == '\t' || ch == '\r' || ch == '\f'
+}
+
 func isDigit(ch byte) bool {
 	return ch >= '0' && ch <= '9'
 }
+
+func isContinuationByte(ch byte) bool {
+	return ch&0xC0 == 0x80
+}
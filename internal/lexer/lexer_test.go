@@ -1,8 +1,13 @@
 package lexer
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"reflect"
+	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 func TestLexer(t *testing.T) {
@@ -15,7 +20,7 @@ func TestLexer(t *testing.T) {
 			name:  "Basic Tags",
 			input: `<div class="test" id="1">Hello</div>`,
 			expectedTokens: []Token{
-				{Type: TokenStartTag, Value: "div", Attributes: map[string]string{"class": "test", "id": "1"}},
+				{Type: TokenStartTag, Value: "div", Attributes: []Attribute{{Name: "class", Value: "test", Quote: '"'}, {Name: "id", Value: "1", Quote: '"'}}},
 				{Type: TokenText, Value: "Hello"},
 				{Type: TokenEndTag, Value: "div"},
 				{Type: TokenEOF, Value: ""},
@@ -25,7 +30,25 @@ func TestLexer(t *testing.T) {
 			name:  "Self-Closing Tag",
 			input: `<img src="image.jpg" />`,
 			expectedTokens: []Token{
-				{Type: TokenSelfClosingTag, Value: "img", Attributes: map[string]string{"src": "image.jpg"}},
+				{Type: TokenSelfClosingTag, Value: "img", Attributes: []Attribute{{Name: "src", Value: "image.jpg", Quote: '"'}}},
+				{Type: TokenEOF, Value: ""},
+			},
+		},
+		{
+			name:  "Upper-Case Names",
+			input: `<DIV Class="A" ID=x>Hi</Div>`,
+			expectedTokens: []Token{
+				{Type: TokenStartTag, Value: "div", Attributes: []Attribute{{Name: "class", Value: "A", Quote: '"'}, {Name: "id", Value: "x"}}},
+				{Type: TokenText, Value: "Hi"},
+				{Type: TokenEndTag, Value: "div"},
+				{Type: TokenEOF, Value: ""},
+			},
+		},
+		{
+			name:  "Attribute Order And Duplicates",
+			input: `<p z=1 a='2' Z="3">`,
+			expectedTokens: []Token{
+				{Type: TokenStartTag, Value: "p", Attributes: []Attribute{{Name: "z", Value: "1"}, {Name: "a", Value: "2", Quote: '\''}}},
 				{Type: TokenEOF, Value: ""},
 			},
 		},
@@ -63,7 +86,7 @@ func TestLexer(t *testing.T) {
 			name:  "Attributes Without Quotes",
 			input: `<div id=test class=test-class>Content</div>`,
 			expectedTokens: []Token{
-				{Type: TokenStartTag, Value: "div", Attributes: map[string]string{"id": "test", "class": "test-class"}},
+				{Type: TokenStartTag, Value: "div", Attributes: []Attribute{{Name: "id", Value: "test"}, {Name: "class", Value: "test-class"}}},
 				{Type: TokenText, Value: "Content"},
 				{Type: TokenEndTag, Value: "div"},
 				{Type: TokenEOF, Value: ""},
@@ -73,15 +96,22 @@ func TestLexer(t *testing.T) {
 			name:  "Special Characters in Attributes",
 			input: `<input value="Tom & Jerry" disabled>`,
 			expectedTokens: []Token{
-				{Type: TokenStartTag, Value: "input", Attributes: map[string]string{"value": "Tom & Jerry", "disabled": ""}},
+				{Type: TokenStartTag, Value: "input", Attributes: []Attribute{{Name: "value", Value: "Tom & Jerry", Quote: '"'}, {Name: "disabled"}}},
 				{Type: TokenEOF, Value: ""},
 			},
 		},
 		{
-			name:  "Nested Comments",
-			input: `<!-- Outer <!-- Inner --> -->`,
+			name:  "Comments Do Not Nest",
+			input: `<!-- Outer <!-- Inner --> --><p>a<!-- <!--> b<!-- x --!>c`,
 			expectedTokens: []Token{
-				{Type: TokenComment, Value: "Outer <!-- Inner -->"},
+				{Type: TokenComment, Value: "Outer <!-- Inner"},
+				{Type: TokenText, Value: " -->"},
+				{Type: TokenStartTag, Value: "p"},
+				{Type: TokenText, Value: "a"},
+				{Type: TokenComment, Value: "<!"},
+				{Type: TokenText, Value: " b"},
+				{Type: TokenComment, Value: "x"},
+				{Type: TokenText, Value: "c"},
 				{Type: TokenEOF, Value: ""},
 			},
 		},
@@ -99,9 +129,8 @@ func TestLexer(t *testing.T) {
 			name:  "Invalid Tags",
 			input: `<123invalid>Text</123invalid>`,
 			expectedTokens: []Token{
-				{Type: TokenStartTag, Value: "123invalid"},
-				{Type: TokenText, Value: "Text"},
-				{Type: TokenEndTag, Value: "123invalid"},
+				{Type: TokenText, Value: "<123invalid>Text"},
+				{Type: TokenComment, Value: "123invalid"},
 				{Type: TokenEOF, Value: ""},
 			},
 		},
@@ -109,7 +138,7 @@ func TestLexer(t *testing.T) {
 			name:  "HTML with Doctype Declaration",
 			input: `<!DOCTYPE html><html></html>`,
 			expectedTokens: []Token{
-				{Type: TokenComment, Value: "DOCTYPE html"},
+				{Type: TokenDoctype, Value: "html"},
 				{Type: TokenStartTag, Value: "html"},
 				{Type: TokenEndTag, Value: "html"},
 				{Type: TokenEOF, Value: ""},
@@ -143,7 +172,721 @@ func TestLexer(t *testing.T) {
 	}
 }
 
-func normalizeAttributes(attrs map[string]string) map[string]string {
+func TestLexerRawText(t *testing.T) {
+	tests := []struct {
+		name           string
+		input          string
+		expectedTokens []Token
+	}{
+		{
+			name:  "Less-Than In Script",
+			input: `<script>if (a<b) { x(); }</script>`,
+			expectedTokens: []Token{
+				{Type: TokenStartTag, Value: "script"},
+				{Type: TokenText, Value: "if (a<b) { x(); }"},
+				{Type: TokenEndTag, Value: "script"},
+				{Type: TokenEOF, Value: ""},
+			},
+		},
+		{
+			name:  "End Tag In String Literal",
+			input: `<script>var s = "</div><p>";</script><p>`,
+			expectedTokens: []Token{
+				{Type: TokenStartTag, Value: "script"},
+				{Type: TokenText, Value: `var s = "</div><p>";`},
+				{Type: TokenEndTag, Value: "script"},
+				{Type: TokenStartTag, Value: "p"},
+				{Type: TokenEOF, Value: ""},
+			},
+		},
+		{
+			name:  "Upper-Case End Tag",
+			input: `<script>a()</SCRIPT >`,
+			expectedTokens: []Token{
+				{Type: TokenStartTag, Value: "script"},
+				{Type: TokenText, Value: "a()"},
+				{Type: TokenEndTag, Value: "script"},
+				{Type: TokenEOF, Value: ""},
+			},
+		},
+		{
+			name:  "End Tag Prefix Does Not Close",
+			input: `<script>a = "</scripts>";</script>`,
+			expectedTokens: []Token{
+				{Type: TokenStartTag, Value: "script"},
+				{Type: TokenText, Value: `a = "</scripts>";`},
+				{Type: TokenEndTag, Value: "script"},
+				{Type: TokenEOF, Value: ""},
+			},
+		},
+		{
+			name:  "Double-Escaped Script",
+			input: `<script><!-- document.write("<script></script>") --></script>`,
+			expectedTokens: []Token{
+				{Type: TokenStartTag, Value: "script"},
+				{Type: TokenText, Value: `<!-- document.write("<script></script>") -->`},
+				{Type: TokenEndTag, Value: "script"},
+				{Type: TokenEOF, Value: ""},
+			},
+		},
+		{
+			name:  "Escaped Script Still Closes",
+			input: `<script><!-- a() </script>b`,
+			expectedTokens: []Token{
+				{Type: TokenStartTag, Value: "script"},
+				{Type: TokenText, Value: `<!-- a() `},
+				{Type: TokenEndTag, Value: "script"},
+				{Type: TokenText, Value: "b"},
+				{Type: TokenEOF, Value: ""},
+			},
+		},
+		{
+			name:  "Style With Markup",
+			input: `<style>p > a { color: red }</style>`,
+			expectedTokens: []Token{
+				{Type: TokenStartTag, Value: "style"},
+				{Type: TokenText, Value: "p > a { color: red }"},
+				{Type: TokenEndTag, Value: "style"},
+				{Type: TokenEOF, Value: ""},
+			},
+		},
+		{
+			name:  "Textarea RCDATA",
+			input: `<textarea><b>bold</b> &amp;</textarea>`,
+			expectedTokens: []Token{
+				{Type: TokenStartTag, Value: "textarea"},
+				{Type: TokenText, Value: "<b>bold</b> &"},
+				{Type: TokenEndTag, Value: "textarea"},
+				{Type: TokenEOF, Value: ""},
+			},
+		},
+		{
+			name:  "Title RCDATA",
+			input: `<title>a <i>b</i></title>`,
+			expectedTokens: []Token{
+				{Type: TokenStartTag, Value: "title"},
+				{Type: TokenText, Value: "a <i>b</i>"},
+				{Type: TokenEndTag, Value: "title"},
+				{Type: TokenEOF, Value: ""},
+			},
+		},
+		{
+			name:  "Empty Script",
+			input: `<script src="a.js"></script>`,
+			expectedTokens: []Token{
+				{Type: TokenStartTag, Value: "script", Attributes: []Attribute{{Name: "src", Value: "a.js", Quote: '"'}}},
+				{Type: TokenEndTag, Value: "script"},
+				{Type: TokenEOF, Value: ""},
+			},
+		},
+		{
+			name:  "Self-Closing Script",
+			input: `<script/>alert("<b>x</b>")</script><p>`,
+			expectedTokens: []Token{
+				{Type: TokenSelfClosingTag, Value: "script"},
+				{Type: TokenText, Value: `alert("<b>x</b>")`},
+				{Type: TokenEndTag, Value: "script"},
+				{Type: TokenStartTag, Value: "p"},
+				{Type: TokenEOF, Value: ""},
+			},
+		},
+		{
+			name:  "Self-Closing Title",
+			input: `<title/>Hi <b>x</b></title>`,
+			expectedTokens: []Token{
+				{Type: TokenSelfClosingTag, Value: "title"},
+				{Type: TokenText, Value: "Hi <b>x</b>"},
+				{Type: TokenEndTag, Value: "title"},
+				{Type: TokenEOF, Value: ""},
+			},
+		},
+		{
+			name:  "Unterminated Script",
+			input: `<script>a < b`,
+			expectedTokens: []Token{
+				{Type: TokenStartTag, Value: "script"},
+				{Type: TokenText, Value: "a < b"},
+				{Type: TokenEOF, Value: ""},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(tt.input)
+
+			for i, expected := range tt.expectedTokens {
+				tok := l.NextToken()
+
+				if tok.Type != expected.Type {
+					t.Fatalf("test '%s' [%d] - tokentype wrong. expected=%q, got=%q",
+						tt.name, i, expected.Type, tok.Type)
+				}
+
+				if tok.Value != expected.Value {
+					t.Fatalf("test '%s' [%d] - tokenvalue wrong. expected=%q, got=%q",
+						tt.name, i, expected.Value, tok.Value)
+				}
+
+				if !reflect.DeepEqual(normalizeAttributes(tok.Attributes), normalizeAttributes(expected.Attributes)) {
+					t.Fatalf("test '%s' [%d] - tokenattributes wrong. expected=%v, got=%v",
+						tt.name, i, expected.Attributes, tok.Attributes)
+				}
+			}
+		})
+	}
+}
+
+func TestLexerMalformed(t *testing.T) {
+	tests := []struct {
+		name           string
+		input          string
+		expectedTokens []Token
+	}{
+		{
+			name:           "Short Declaration",
+			input:          `<!-x`,
+			expectedTokens: []Token{{Type: TokenComment, Value: "-x"}, {Type: TokenEOF}},
+		},
+		{
+			name:           "Lone Less-Than",
+			input:          `<`,
+			expectedTokens: []Token{{Type: TokenText, Value: "<"}, {Type: TokenEOF}},
+		},
+		{
+			name:           "Less-Than In Text",
+			input:          `a < b <= c <3`,
+			expectedTokens: []Token{{Type: TokenText, Value: "a < b <= c <3"}, {Type: TokenEOF}},
+		},
+		{
+			name:           "Unterminated Attribute Quote",
+			input:          `<a href="x`,
+			expectedTokens: []Token{{Type: TokenEOF}},
+		},
+		{
+			name:           "Truncated Start Tag",
+			input:          `<p>a<div class`,
+			expectedTokens: []Token{{Type: TokenStartTag, Value: "p"}, {Type: TokenText, Value: "a"}, {Type: TokenEOF}},
+		},
+		{
+			name:           "Truncated End Tag",
+			input:          `a</di`,
+			expectedTokens: []Token{{Type: TokenText, Value: "a"}, {Type: TokenEOF}},
+		},
+		{
+			name:           "End Tag Opener At EOF",
+			input:          `a</`,
+			expectedTokens: []Token{{Type: TokenText, Value: "a"}, {Type: TokenText, Value: "</"}, {Type: TokenEOF}},
+		},
+		{
+			name:           "Empty End Tag",
+			input:          `a</>b`,
+			expectedTokens: []Token{{Type: TokenText, Value: "a"}, {Type: TokenText, Value: "b"}, {Type: TokenEOF}},
+		},
+		{
+			name:           "Bogus End Tag",
+			input:          `</ x>`,
+			expectedTokens: []Token{{Type: TokenComment, Value: " x"}, {Type: TokenEOF}},
+		},
+		{
+			name:           "Processing Instruction",
+			input:          `<?xml version="1.0"?><p>`,
+			expectedTokens: []Token{{Type: TokenComment, Value: `?xml version="1.0"?`}, {Type: TokenStartTag, Value: "p"}, {Type: TokenEOF}},
+		},
+		{
+			name:           "Unterminated Comment",
+			input:          `<!-- open`,
+			expectedTokens: []Token{{Type: TokenComment, Value: "open"}, {Type: TokenEOF}},
+		},
+		{
+			name:           "Abrupt Comments",
+			input:          `<!--><!--->x`,
+			expectedTokens: []Token{{Type: TokenComment, Value: ""}, {Type: TokenComment, Value: ""}, {Type: TokenText, Value: "x"}, {Type: TokenEOF}},
+		},
+		{
+			name:           "Lower-Case Doctype",
+			input:          `<!doctype html>`,
+			expectedTokens: []Token{{Type: TokenDoctype, Value: "html"}, {Type: TokenEOF}},
+		},
+		{
+			name:  "Framework Attribute Names",
+			input: `<button :class="x" @click=go v-on:key.enter ng.model>`,
+			expectedTokens: []Token{{Type: TokenStartTag, Value: "button", Attributes: []Attribute{
+				{Name: ":class", Value: "x", Quote: '"'}, {Name: "@click", Value: "go"}, {Name: "v-on:key.enter"}, {Name: "ng.model"},
+			}}, {Type: TokenEOF}},
+		},
+		{
+			name:  "Unquoted Values With Slashes",
+			input: `<a data-x=a/b href=/path?x=1&y=2 title = "t">x</a><a href=/>`,
+			expectedTokens: []Token{
+				{Type: TokenStartTag, Value: "a", Attributes: []Attribute{
+					{Name: "data-x", Value: "a/b"}, {Name: "href", Value: "/path?x=1&y=2"}, {Name: "title", Value: "t", Quote: '"'},
+				}},
+				{Type: TokenText, Value: "x"},
+				{Type: TokenEndTag, Value: "a"},
+				{Type: TokenStartTag, Value: "a", Attributes: []Attribute{{Name: "href", Value: "/"}}},
+				{Type: TokenEOF},
+			},
+		},
+		{
+			name:  "Stray Solidus And Equals",
+			input: `<img / src=a =b/>`,
+			expectedTokens: []Token{
+				{Type: TokenSelfClosingTag, Value: "img", Attributes: []Attribute{{Name: "src", Value: "a"}, {Name: "=b"}}},
+				{Type: TokenEOF},
+			},
+		},
+		{
+			name:  "Greater-Than In Quoted Value",
+			input: `<p title="a>b"></p title=">">x`,
+			expectedTokens: []Token{
+				{Type: TokenStartTag, Value: "p", Attributes: []Attribute{{Name: "title", Value: "a>b", Quote: '"'}}},
+				{Type: TokenEndTag, Value: "p"},
+				{Type: TokenText, Value: "x"},
+				{Type: TokenEOF},
+			},
+		},
+		{
+			name:  "Namespaced Tag Names",
+			input: `<o:p>x</O:P><st1:place w:st="on">`,
+			expectedTokens: []Token{
+				{Type: TokenStartTag, Value: "o:p"},
+				{Type: TokenText, Value: "x"},
+				{Type: TokenEndTag, Value: "o:p"},
+				{Type: TokenStartTag, Value: "st1:place", Attributes: []Attribute{{Name: "w:st", Value: "on", Quote: '"'}}},
+				{Type: TokenEOF},
+			},
+		},
+		{
+			name:  "Dotted Tag Names",
+			input: `<my.el a="1"/><x-y.z></my.el>`,
+			expectedTokens: []Token{
+				{Type: TokenSelfClosingTag, Value: "my.el", Attributes: []Attribute{{Name: "a", Value: "1", Quote: '"'}}},
+				{Type: TokenStartTag, Value: "x-y.z"},
+				{Type: TokenEndTag, Value: "my.el"},
+				{Type: TokenEOF},
+			},
+		},
+		{
+			name:           "Stray Characters In Tag",
+			input:          `<div "x" =y class=a>`,
+			expectedTokens: []Token{{Type: TokenStartTag, Value: "div", Attributes: []Attribute{{Name: `"x"`, Value: "y"}, {Name: "class", Value: "a"}}}, {Type: TokenEOF}},
+		},
+		{
+			name:           "NUL Is Not EOF",
+			input:          "a\x00b<p>",
+			expectedTokens: []Token{{Type: TokenText, Value: "a\x00b"}, {Type: TokenStartTag, Value: "p"}, {Type: TokenEOF}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(tt.input)
+
+			for i, expected := range tt.expectedTokens {
+				tok := l.NextToken()
+
+				if tok.Type != expected.Type {
+					t.Fatalf("test '%s' [%d] - tokentype wrong. expected=%q, got=%q",
+						tt.name, i, expected.Type, tok.Type)
+				}
+
+				if tok.Value != expected.Value {
+					t.Fatalf("test '%s' [%d] - tokenvalue wrong. expected=%q, got=%q",
+						tt.name, i, expected.Value, tok.Value)
+				}
+
+				if !reflect.DeepEqual(normalizeAttributes(tok.Attributes), normalizeAttributes(expected.Attributes)) {
+					t.Fatalf("test '%s' [%d] - tokenattributes wrong. expected=%v, got=%v",
+						tt.name, i, expected.Attributes, tok.Attributes)
+				}
+			}
+		})
+	}
+}
+
+func TestLexerDoctype(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected Token
+	}{
+		{"HTML5", `<!DOCTYPE html>`, Token{Value: "html"}},
+		{"Upper-Case Name", `<!doctype HTML >`, Token{Value: "html"}},
+		{
+			name:     "Public And System",
+			input:    `<!DOCTYPE html PUBLIC "-//W3C//DTD HTML 4.01//EN" 'http://www.w3.org/TR/html4/strict.dtd'>`,
+			expected: Token{Value: "html", PublicID: "-//W3C//DTD HTML 4.01//EN", SystemID: "http://www.w3.org/TR/html4/strict.dtd", HasSystemID: true},
+		},
+		{
+			name:     "Public Only",
+			input:    `<!DOCTYPE html PUBLIC "-//W3C//DTD HTML 4.01 Transitional//EN">`,
+			expected: Token{Value: "html", PublicID: "-//W3C//DTD HTML 4.01 Transitional//EN"},
+		},
+		{
+			name:     "System Only",
+			input:    `<!DOCTYPE html SYSTEM "about:legacy-compat">`,
+			expected: Token{Value: "html", SystemID: "about:legacy-compat", HasSystemID: true},
+		},
+		{"Empty System", `<!DOCTYPE html SYSTEM "">`, Token{Value: "html", HasSystemID: true}},
+		{"Missing Name", `<!DOCTYPE>`, Token{ForceQuirks: true}},
+		{"Missing Quote", `<!DOCTYPE html PUBLIC foo>`, Token{Value: "html", ForceQuirks: true}},
+		{"Unterminated Identifier", `<!DOCTYPE html PUBLIC "foo>`, Token{Value: "html", PublicID: "foo", ForceQuirks: true}},
+		{"Unknown Keyword", `<!DOCTYPE html bogus>`, Token{Value: "html", ForceQuirks: true}},
+		{"Trailing Junk Is Ignored", `<!DOCTYPE html SYSTEM "a" junk>`, Token{Value: "html", SystemID: "a", HasSystemID: true}},
+		{"Cut Off", `<!DOCTYPE html`, Token{Value: "html", ForceQuirks: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(tt.input)
+			tok := l.NextToken()
+			tt.expected.Type = TokenDoctype
+			if tok.Type != tt.expected.Type || tok.Value != tt.expected.Value ||
+				tok.PublicID != tt.expected.PublicID || tok.SystemID != tt.expected.SystemID ||
+				tok.HasSystemID != tt.expected.HasSystemID || tok.ForceQuirks != tt.expected.ForceQuirks {
+				t.Fatalf("test '%s' - doctype wrong. expected=%+v, got=%+v", tt.name, tt.expected, tok)
+			}
+		})
+	}
+}
+
+func FuzzLexer(f *testing.F) {
+	for _, seed := range []string{
+		`<div class="test" id="1">Hello</div>`,
+		`<!DOCTYPE html><html><body><!-- c --></body></html>`,
+		`<script>if (a<b) { s = "</div>"; }</script>`,
+		`<!-x`, `<a href="x`, `</`, `<?`, `<!--`, `<p a=1 b='2' c>`,
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		l := New(input)
+		for i := 0; ; i++ {
+			tok := l.NextToken()
+			if tok.Type == TokenEOF {
+				return
+			}
+			if tok.Span.End.Offset <= tok.Span.Start.Offset || tok.Span.End.Offset > len(input) {
+				t.Fatalf("token %d (%s %q) has bad span %+v", i, tok.Type, tok.Value, tok.Span)
+			}
+			if i > len(input) {
+				t.Fatalf("lexer produced more tokens than input bytes")
+			}
+		}
+	})
+}
+
+func TestLexerSetRawTextElement(t *testing.T) {
+	tests := []struct {
+		name     string
+		element  string
+		input    string
+		expected []Token
+	}{
+		{"Script", "script", `a<b>c</script>d`, []Token{
+			{Type: TokenText, Value: "a<b>c"},
+			{Type: TokenEndTag, Value: "script"},
+			{Type: TokenText, Value: "d"},
+		}},
+		{"Upper Case Name", "TEXTAREA", `<p>`, []Token{
+			{Type: TokenText, Value: "<p>"},
+		}},
+		{"Not Raw Text", "div", `<p>`, []Token{
+			{Type: TokenStartTag, Value: "p"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(tt.input)
+			l.SetRawTextElement(tt.element)
+			for i, expected := range tt.expected {
+				tok := l.NextToken()
+				if tok.Type != expected.Type || tok.Value != expected.Value {
+					t.Fatalf("test '%s' - token %d wrong. expected=%s %q, got=%s %q",
+						tt.name, i, expected.Type, expected.Value, tok.Type, tok.Value)
+				}
+			}
+			if tok := l.NextToken(); tok.Type != TokenEOF {
+				t.Fatalf("test '%s' - expected EOF, got=%s %q", tt.name, tok.Type, tok.Value)
+			}
+		})
+	}
+}
+
+func TestLexerForeignContent(t *testing.T) {
+	l := New(`<style>a<b/></style><![CDATA[x<y>&amp;]]><![CDATA[z`)
+	l.SetAllowCDATA(true)
+	tok := l.NextToken()
+	l.SetRawTextElement("") // An SVG <style> holds markup
+	expected := []Token{
+		{Type: TokenText, Value: "a"},
+		{Type: TokenSelfClosingTag, Value: "b"},
+		{Type: TokenEndTag, Value: "style"},
+		{Type: TokenText, Value: "x<y>&amp;"},
+		{Type: TokenText, Value: "z"},
+		{Type: TokenEOF},
+	}
+	for i, exp := range expected {
+		if tok = l.NextToken(); tok.Type != exp.Type || tok.Value != exp.Value {
+			t.Fatalf("[%d] - expected=%s %q, got=%s %q", i, exp.Type, exp.Value, tok.Type, tok.Value)
+		}
+	}
+
+	l = New(`<![CDATA[x]]>`)
+	if tok = l.NextToken(); tok.Type != TokenComment || tok.Value != "[CDATA[x]]" {
+		t.Fatalf("expected a bogus comment outside foreign content, got=%s %q", tok.Type, tok.Value)
+	}
+}
+
+func TestLexerErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string // Codes with their positions, separated by spaces
+	}{
+		{"Valid Markup", `<!DOCTYPE html><p class="a" id=b>x</p><!-- c -->`, ""},
+		{"Duplicate Attribute", `<p a="1" a="2">`, "duplicate-attribute@1:11"},
+		{"Duplicate Attribute In Other Case", `<p a="1" A="2">`, "duplicate-attribute@1:11"},
+		{"Missing Attribute Value", `<p a=>`, "missing-attribute-value@1:6"},
+		{"Missing Whitespace Between Attributes", `<p a="1"b="2">`, "missing-whitespace-between-attributes@1:9"},
+		{"Quote In Attribute Name", `<p "a">`, "unexpected-character-in-attribute-name@1:4 unexpected-character-in-attribute-name@1:6"},
+		{"EOF In Tag", `<p a="1`, "eof-in-tag@1:8"},
+		{"CDATA In HTML", `<![CDATA[x]]>`, "cdata-in-html-content@1:3"},
+		{"Missing Semicolon", `&amp x&#65`, "missing-semicolon-after-character-reference@1:5 missing-semicolon-after-character-reference@1:11"},
+		{"Unknown Named Reference", `&foo;`, "unknown-named-character-reference@1:5"},
+		{"Absent Digits", `&#x;`, "absence-of-digits-in-numeric-character-reference@1:4"},
+		{"Bad Numeric References", `&#0;&#xD800;&#x110000;&#xFFFF;&#x80;`, "null-character-reference@1:5 surrogate-character-reference@1:13 character-reference-outside-unicode-range@1:23 noncharacter-character-reference@1:31 control-character-reference@1:37"},
+		{"Equals Before Attribute Name", `<p =a>`, "unexpected-equals-sign-before-attribute-name@1:4"},
+		{"Quote In Unquoted Value", `<p a=b"c>`, "unexpected-character-in-unquoted-attribute-value@1:7"},
+		{"Solidus In Tag", `<p / a>`, "unexpected-solidus-in-tag@1:5"},
+		{"Null In Attribute", "<p a=\x00>", "unexpected-null-character@1:6"},
+		{"EOF In Comment", `<!-- x`, "eof-in-comment@1:7"},
+		{"Empty Comment", `<!-->`, "abrupt-closing-of-empty-comment@1:5"},
+		{"Nested Comment", `<!-- <!-- --> -->`, "nested-comment@1:6"},
+		{"Incorrectly Closed Comment", `<!-- x --!>`, "incorrectly-closed-comment@1:8"},
+		{"Bogus Comment", `<!x>`, "incorrectly-opened-comment@1:3"},
+		{"Processing Instruction", `<?xml?>`, "unexpected-question-mark-instead-of-tag-name@1:2"},
+		{"Stray Less Than", "a <3\n<", "invalid-first-character-of-tag-name@1:3 eof-before-tag-name@2:1"},
+		{"Null Character", "a\x00b", "unexpected-null-character@1:2"},
+		{"Missing End Tag Name", `</>`, "missing-end-tag-name@1:3"},
+		{"Invalid End Tag Name", `</1>`, "invalid-first-character-of-tag-name@1:3"},
+		{"End Tag With Attributes", `</p class="a">`, "end-tag-with-attributes@1:5"},
+		{"End Tag With Trailing Solidus", `</p/>`, "end-tag-with-trailing-solidus@1:4"},
+		{"Missing Doctype Name", `<!DOCTYPE>`, "missing-doctype-name@1:10"},
+		{"EOF In Doctype", `<!DOCTYPE html`, "eof-in-doctype@1:15"},
+		{"Missing Doctype Whitespace", `<!DOCTYPEhtml>`, "missing-whitespace-before-doctype-name@1:10"},
+		{"Bad Doctype Keyword", `<!DOCTYPE html FOO>`, "invalid-character-sequence-after-doctype-name@1:16"},
+		{"Unquoted Public ID", `<!DOCTYPE html PUBLIC foo>`, "missing-quote-before-doctype-public-identifier@1:23"},
+		{"Abrupt System ID", `<!DOCTYPE html SYSTEM "abc>`, "abrupt-doctype-system-identifier@1:27"},
+		{"Junk After System ID", `<!DOCTYPE html SYSTEM "a" b>`, "unexpected-character-after-doctype-system-identifier@1:27"},
+		{"EOF In Escaped Script", `<script><!-- x`, "eof-in-script-html-comment-like-text@1:15"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			l := New(tt.input)
+			l.SetErrorHandler(func(e Error) {
+				got = append(got, fmt.Sprintf("%s@%d:%d", e.Code, e.Pos.Line, e.Pos.Column))
+			})
+			for l.NextToken().Type != TokenEOF {
+			}
+			if strings.Join(got, " ") != tt.expected {
+				t.Fatalf("test '%s' - errors wrong. expected=%q, got=%q", tt.name, tt.expected, strings.Join(got, " "))
+			}
+		})
+	}
+}
+
+func TestLexerCharRefs(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string // Put in both text and a quoted attribute value
+		text  string
+		attr  string
+	}{
+		{"Named", `&lt;&amp;&gt;&quot;`, `<&>"`, `<&>"`},
+		{"Numeric", `&#65;&#x42;&#X43;&#0068`, `ABCD`, `ABCD`},
+		{"Two Code Points", `&NotEqualTilde;`, "\u2242\u0338", "\u2242\u0338"},
+		{"Unknown", `&foo; &; & x`, `&foo; &; & x`, `&foo; &; & x`},
+		{"Legacy Without Semicolon", `&copy 2024 &amp`, `© 2024 &`, `© 2024 &`},
+		{"Legacy Prefix", `&notit; &ampx`, `¬it; &x`, `&notit; &ampx`},
+		{"Legacy Before Equals", `?a=1&amp=2&lt=3`, `?a=1&=2<=3`, `?a=1&amp=2&lt=3`},
+		{"Semicolon Reference In URL", `?x=1&amp;y=2`, `?x=1&y=2`, `?x=1&y=2`},
+		{"C1 Replacements", `&#128;&#x99;&#x81;`, "€™\u0081", "€™\u0081"},
+		{"Invalid Code Points", `&#0;&#xD800;&#x110000;&#99999999999;`, "\uFFFD\uFFFD\uFFFD\uFFFD", "\uFFFD\uFFFD\uFFFD\uFFFD"},
+		{"No Digits", `&#; &#x;`, `&#; &#x;`, `&#; &#x;`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(`<p title="` + tt.input + `">` + tt.input + `</p>`)
+			tok := l.NextToken()
+			if value, _ := tok.Attr("title"); value != tt.attr {
+				t.Fatalf("test '%s' - attribute wrong. expected=%q, got=%q", tt.name, tt.attr, value)
+			}
+			if tok = l.NextToken(); tok.Value != tt.text {
+				t.Fatalf("test '%s' - text wrong. expected=%q, got=%q", tt.name, tt.text, tok.Value)
+			}
+		})
+	}
+
+	t.Run("Raw Text", func(t *testing.T) {
+		l := New(`<script>a &amp;&amp; b</script><title>&lt;T&gt;</title>`)
+		for _, expected := range []string{"script", "a &amp;&amp; b", "script", "title", "<T>"} {
+			if tok := l.NextToken(); tok.Value != expected {
+				t.Fatalf("expected=%q, got=%q", expected, tok.Value)
+			}
+		}
+	})
+
+	t.Run("Keep Entities", func(t *testing.T) {
+		l := New(`<a href="?a&amp;b">&lt;</a>`)
+		l.SetKeepEntities(true)
+		if tok := l.NextToken(); tok.Attributes[0].Value != "?a&amp;b" {
+			t.Fatalf("attribute decoded: %q", tok.Attributes[0].Value)
+		}
+		if tok := l.NextToken(); tok.Value != "&lt;" {
+			t.Fatalf("text decoded: %q", tok.Value)
+		}
+	})
+}
+
+func TestLexerReader(t *testing.T) {
+	inputs := []string{
+		`<div class="test" id="1">Hello</div>`,
+		"<!DOCTYPE html>\n<html>\n<body><!-- Outer <!-- Inner --> --><p>héllo</p></body></html>",
+		`<script>var s = "</div><p>"; <!-- <script></script> --></script><p>`,
+		`<textarea>a <b> &amp;</textarea><!--><?x?></ x></>`,
+		`<p>a < b <a href="x`,
+		strings.Repeat(`<tr><td class="c">cell</td></tr>`, 5000),
+	}
+
+	for i, input := range inputs {
+		expected := New(input)
+		streamed := NewReader(iotest.OneByteReader(strings.NewReader(input)))
+		for j := 0; ; j++ {
+			want, got := expected.NextToken(), streamed.NextToken()
+			if !reflect.DeepEqual(want, got) {
+				t.Fatalf("input %d, token %d - expected=%+v, got=%+v", i, j, want, got)
+			}
+			if want.Type == TokenEOF {
+				break
+			}
+		}
+		if err := streamed.Err(); err != nil {
+			t.Fatalf("input %d - unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestLexerReaderError(t *testing.T) {
+	failure := errors.New("connection reset")
+	r := io.MultiReader(strings.NewReader("<p>partial"), iotest.ErrReader(failure))
+	l := NewReader(r)
+
+	var types []string
+	for tok := l.NextToken(); tok.Type != TokenEOF; tok = l.NextToken() {
+		types = append(types, tok.Type)
+	}
+	if strings.Join(types, ",") != "StartTag,Text" {
+		t.Fatalf("expected tokens before the error, got %v", types)
+	}
+	if !errors.Is(l.Err(), failure) {
+		t.Fatalf("expected the read error, got %v", l.Err())
+	}
+}
+
+func TestLexerPositions(t *testing.T) {
+	input := "<div>\n  <p class=\"x\">héllo</p>\n</div>"
+	expected := []struct {
+		typ  string
+		span Span
+	}{
+		{TokenStartTag, Span{Pos{0, 1, 1}, Pos{5, 1, 6}}},
+		{TokenText, Span{Pos{5, 1, 6}, Pos{8, 2, 3}}},
+		{TokenStartTag, Span{Pos{8, 2, 3}, Pos{21, 2, 16}}},
+		{TokenText, Span{Pos{21, 2, 16}, Pos{27, 2, 21}}},
+		{TokenEndTag, Span{Pos{27, 2, 21}, Pos{31, 2, 25}}},
+		{TokenText, Span{Pos{31, 2, 25}, Pos{32, 3, 1}}},
+		{TokenEndTag, Span{Pos{32, 3, 1}, Pos{38, 3, 7}}},
+		{TokenEOF, Span{Pos{38, 3, 7}, Pos{38, 3, 7}}},
+	}
+
+	l := New(input)
+	for i, exp := range expected {
+		tok := l.NextToken()
+		if tok.Type != exp.typ {
+			t.Fatalf("[%d] - tokentype wrong. expected=%q, got=%q", i, exp.typ, tok.Type)
+		}
+		if tok.Span != exp.span {
+			t.Fatalf("[%d] - span wrong. expected=%+v, got=%+v", i, exp.span, tok.Span)
+		}
+		if tok.Position != exp.span.Start.Offset {
+			t.Fatalf("[%d] - position wrong. expected=%d, got=%d", i, exp.span.Start.Offset, tok.Position)
+		}
+	}
+}
+
+func TestLexerNewlines(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []Token
+	}{
+		{
+			name:  "CRLF",
+			input: "a\r\nb\r\n<p title=\"x\r\ny\">c",
+			expected: []Token{
+				{Type: TokenText, Value: "a\nb\n", Span: Span{Pos{0, 1, 1}, Pos{4, 3, 1}}},
+				{Type: TokenStartTag, Value: "p", Span: Span{Pos{4, 3, 1}, Pos{19, 4, 4}}},
+				{Type: TokenText, Value: "c", Span: Span{Pos{19, 4, 4}, Pos{20, 4, 5}}},
+			},
+		},
+		{
+			name:  "CR Only",
+			input: "a\rb\r<p title=\"x\ry\">c",
+			expected: []Token{
+				{Type: TokenText, Value: "a\nb\n", Span: Span{Pos{0, 1, 1}, Pos{4, 3, 1}}},
+				{Type: TokenStartTag, Value: "p", Span: Span{Pos{4, 3, 1}, Pos{19, 4, 4}}},
+				{Type: TokenText, Value: "c", Span: Span{Pos{19, 4, 4}, Pos{20, 4, 5}}},
+			},
+		},
+		{
+			name:  "Mixed",
+			input: "\r\r\n\n<!--\r\n-->",
+			expected: []Token{
+				{Type: TokenText, Value: "\n\n\n", Span: Span{Pos{0, 1, 1}, Pos{3, 4, 1}}},
+				{Type: TokenComment, Value: "\n", Span: Span{Pos{3, 4, 1}, Pos{11, 5, 4}}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Read a byte at a time too, so that "\r\n" is split across reads
+			for _, l := range []*Lexer{New(tt.input), NewReader(iotest.OneByteReader(strings.NewReader(tt.input)))} {
+				for i, exp := range tt.expected {
+					tok := l.NextToken()
+					if tok.Type != exp.Type || tok.Value != exp.Value || tok.Span != exp.Span {
+						t.Fatalf("Test '%s' [%d] - expected=%s %q %+v, got=%s %q %+v", tt.name, i,
+							exp.Type, exp.Value, exp.Span, tok.Type, tok.Value, tok.Span)
+					}
+					if exp.Type == TokenStartTag {
+						if v, _ := tok.Attr("title"); v != "x\ny" {
+							t.Fatalf("Test '%s' [%d] - attribute wrong. expected=%q, got=%q", tt.name, i, "x\ny", v)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func normalizeAttributes(attrs []Attribute) []Attribute {
 	if len(attrs) == 0 {
 		return nil
 	}
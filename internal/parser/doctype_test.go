@@ -0,0 +1,117 @@
+package parser
+
+import (
+	"testing"
+)
+
+func TestDoctype(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		doctype  *Node // nil if no doctype node is expected
+		quirks   QuirksMode
+		rendered string
+	}{
+		{
+			name:     "HTML5",
+			input:    `<!DOCTYPE html><p>x`,
+			doctype:  &Node{Content: "html"},
+			quirks:   NoQuirks,
+			rendered: `<!DOCTYPE html>`,
+		},
+		{
+			name:   "Missing Doctype",
+			input:  `<p>x`,
+			quirks: Quirks,
+		},
+		{
+			name:  "HTML 4.01 Strict",
+			input: `<!DOCTYPE HTML PUBLIC "-//W3C//DTD HTML 4.01//EN" "http://www.w3.org/TR/html4/strict.dtd">`,
+			doctype: &Node{
+				Content:  "html",
+				PublicID: "-//W3C//DTD HTML 4.01//EN",
+				SystemID: "http://www.w3.org/TR/html4/strict.dtd",
+			},
+			quirks:   NoQuirks,
+			rendered: `<!DOCTYPE html>`,
+		},
+		{
+			name:  "HTML 4.01 Transitional",
+			input: `<!DOCTYPE html PUBLIC "-//W3C//DTD HTML 4.01 Transitional//EN" "http://www.w3.org/TR/html4/loose.dtd">`,
+			doctype: &Node{
+				Content:  "html",
+				PublicID: "-//W3C//DTD HTML 4.01 Transitional//EN",
+				SystemID: "http://www.w3.org/TR/html4/loose.dtd",
+			},
+			quirks:   LimitedQuirks,
+			rendered: `<!DOCTYPE html>`,
+		},
+		{
+			name:     "HTML 4.01 Transitional Without System ID",
+			input:    `<!DOCTYPE html PUBLIC "-//W3C//DTD HTML 4.01 Transitional//EN">`,
+			doctype:  &Node{Content: "html", PublicID: "-//W3C//DTD HTML 4.01 Transitional//EN"},
+			quirks:   Quirks,
+			rendered: `<!DOCTYPE html>`,
+		},
+		{
+			name:     "XHTML 1.0 Transitional",
+			input:    `<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Transitional//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd">`,
+			doctype:  &Node{Content: "html", PublicID: "-//W3C//DTD XHTML 1.0 Transitional//EN", SystemID: "http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd"},
+			quirks:   LimitedQuirks,
+			rendered: `<!DOCTYPE html>`,
+		},
+		{
+			name:     "Legacy Public ID",
+			input:    `<!DOCTYPE html PUBLIC "-//IETF//DTD HTML 2.0//EN">`,
+			doctype:  &Node{Content: "html", PublicID: "-//IETF//DTD HTML 2.0//EN"},
+			quirks:   Quirks,
+			rendered: `<!DOCTYPE html>`,
+		},
+		{
+			name:     "Other Name",
+			input:    `<!DOCTYPE svg>`,
+			doctype:  &Node{Content: "svg"},
+			quirks:   Quirks,
+			rendered: `<!DOCTYPE svg>`,
+		},
+		{
+			name:     "Malformed",
+			input:    `<!DOCTYPE>`,
+			doctype:  &Node{},
+			quirks:   Quirks,
+			rendered: `<!DOCTYPE >`,
+		},
+		{
+			name:   "Doctype After Content Is Ignored",
+			input:  `<p>x</p><!DOCTYPE html>`,
+			quirks: Quirks,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := New(tt.input).Parse()
+			if doc.QuirksMode != tt.quirks {
+				t.Fatalf("Test '%s' - quirks mode wrong. expected=%q, got=%q", tt.name, tt.quirks, doc.QuirksMode)
+			}
+
+			var doctype *Node
+			if first := doc.FirstChildNode(); first != nil && first.Type == NodeDoctype {
+				doctype = first
+			}
+			if (doctype == nil) != (tt.doctype == nil) {
+				t.Fatalf("Test '%s' - expected doctype=%v, got=%v", tt.name, tt.doctype != nil, doctype != nil)
+			}
+			if doctype != nil {
+				if doctype.Content != tt.doctype.Content || doctype.PublicID != tt.doctype.PublicID || doctype.SystemID != tt.doctype.SystemID {
+					t.Fatalf("Test '%s' - doctype wrong. expected=%q %q %q, got=%q %q %q", tt.name,
+						tt.doctype.Content, tt.doctype.PublicID, tt.doctype.SystemID,
+						doctype.Content, doctype.PublicID, doctype.SystemID)
+				}
+				if got := doctype.OuterHTML(); got != tt.rendered {
+					t.Fatalf("Test '%s' - rendered wrong. expected=%q, got=%q", tt.name, tt.rendered, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package parser
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Document is a parsed HTML document. It wraps the NodeDocument node at the
+// root of the tree, so all Node methods can be called on it directly. Parse
+// always gives the document an html element with head and body (or frameset)
+// children, as browsers do.
+type Document struct {
+	*Node
+
+	// QuirksMode is the rendering mode selected by the doctype
+	QuirksMode QuirksMode
+
+	// Encoding is the character encoding the document was decoded from,
+	// such as "windows-1251". The parser reads text that is already decoded,
+	// so it is only set by callers that know it, such as the scraper.
+	Encoding string
+}
+
+// documentElement returns the html element.
+func (d *Document) documentElement() *Node {
+	for _, child := range d.Children {
+		if child.Type == NodeElement {
+			return child
+		}
+	}
+	return nil
+}
+
+// Head returns the head element, or nil if it was removed from the tree.
+func (d *Document) Head() *Node {
+	return d.htmlChild("head")
+}
+
+// Body returns the body element, or the frameset element of a frameset
+// document. It returns nil if neither is in the tree.
+func (d *Document) Body() *Node {
+	return d.htmlChild("body", "frameset")
+}
+
+func (d *Document) htmlChild(names ...string) *Node {
+	html := d.documentElement()
+	if html == nil {
+		return nil
+	}
+	for _, child := range html.Children {
+		if isOneOf(child, names...) {
+			return child
+		}
+	}
+	return nil
+}
+
+// Title returns the text of the first title element with whitespace
+// collapsed, or "" if the document has no title.
+func (d *Document) Title() string {
+	var title *Node
+	walkDescendants(d.Node, func(n *Node) bool {
+		if isOneOf(n, "title") {
+			title = n
+			return false
+		}
+		return true
+	})
+	if title == nil {
+		return ""
+	}
+	words := strings.FieldsFunc(title.Text(), func(r rune) bool {
+		return r < utf8.RuneSelf && isHTMLSpace(byte(r))
+	})
+	return strings.Join(words, " ")
+}
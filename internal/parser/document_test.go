@@ -0,0 +1,81 @@
+package parser
+
+import (
+	"testing"
+)
+
+func TestDocument(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		head  string // InnerHTML of Head()
+		body  string // OuterHTML of Body()
+		title string
+	}{
+		{
+			name:  "Full Document",
+			input: `<!DOCTYPE html><html><head><title>Red   Line</title></head><body><p>x</p></body></html>`,
+			head:  `<title>Red   Line</title>`,
+			body:  `<body><p>x</p></body>`,
+			title: "Red Line",
+		},
+		{
+			name:  "Implied Elements",
+			input: `<meta charset="utf-8"><title> Outages </title><div>x</div>`,
+			head:  `<meta charset="utf-8"><title> Outages </title>`,
+			body:  `<body><div>x</div></body>`,
+			title: "Outages",
+		},
+		{
+			name:  "Head Elements After Body Content",
+			input: "<p>x</p><title>Late\n title</title>",
+			head:  ``,
+			body:  "<body><p>x</p><title>Late\n title</title></body>",
+			title: "Late title",
+		},
+		{
+			name:  "No Title",
+			input: `text`,
+			head:  ``,
+			body:  `<body>text</body>`,
+			title: "",
+		},
+		{
+			name:  "Frameset",
+			input: `<frameset><frame src="a"></frameset>`,
+			head:  ``,
+			body:  `<frameset><frame src="a"></frameset>`,
+			title: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := New(tt.input).Parse()
+			if doc.Type != NodeDocument || doc.Parent != nil {
+				t.Fatalf("Test '%s' - root is not a document node", tt.name)
+			}
+			if got := doc.Head().InnerHTML(); got != tt.head {
+				t.Fatalf("Test '%s' - head wrong. expected=%q, got=%q", tt.name, tt.head, got)
+			}
+			if got := doc.Body().OuterHTML(); got != tt.body {
+				t.Fatalf("Test '%s' - body wrong. expected=%q, got=%q", tt.name, tt.body, got)
+			}
+			if got := doc.Title(); got != tt.title {
+				t.Fatalf("Test '%s' - title wrong. expected=%q, got=%q", tt.name, tt.title, got)
+			}
+		})
+	}
+}
+
+func TestDocumentMissingElements(t *testing.T) {
+	doc := New(`<p>x</p>`).Parse()
+	doc.Body().Detach()
+	if doc.Body() != nil {
+		t.Fatalf("expected no body after removing it")
+	}
+	doc.Children[0].Detach()
+	if doc.Head() != nil || doc.Body() != nil || doc.Title() != "" {
+		t.Fatalf("expected accessors to handle a document without an html element")
+	}
+}
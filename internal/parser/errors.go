@@ -0,0 +1,90 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/rsolovyeaws/go-html-parser/internal/lexer"
+)
+
+// ParseError is a recoverable problem in the input. Parsing never fails, as
+// in browsers, so errors are only collected when Options.ReportErrors is set,
+// for example to lint generated markup.
+//
+// Tokenizer errors use the WHATWG codes, such as "eof-in-comment" or
+// "duplicate-attribute". The standard leaves tree construction errors
+// unnamed; those use these codes:
+//
+//	expected-doctype                  the document does not start with a doctype
+//	unknown-doctype                   the doctype is not <!DOCTYPE html>
+//	unexpected-doctype                a doctype after the start of the document
+//	unexpected-start-tag              a start tag that is ignored or moved
+//	unexpected-end-tag                an end tag without a matching open element
+//	end-tag-too-early                 an end tag that also closes other elements
+//	unexpected-character              text where no text is allowed
+//	expected-closing-tag-but-got-eof  elements left open at the end of input
+//
+// plus "non-void-html-element-start-tag-with-trailing-solidus" for a
+// self-closing tag such as <div/>.
+type ParseError struct {
+	Code string
+	Pos  lexer.Pos
+}
+
+func (e ParseError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Code)
+}
+
+// Errors returns the parse errors found so far, in input order. It is empty
+// unless the parser was created with Options.ReportErrors.
+func (p *Parser) Errors() []ParseError {
+	// The tree builder reports errors at the start of a token only after the
+	// lexer has reported those inside it
+	sort.SliceStable(p.errors, func(i, j int) bool {
+		return p.errors[i].Pos.Offset < p.errors[j].Pos.Offset
+	})
+	return p.errors
+}
+
+// parseError records a tree construction error at the current token.
+func (p *Parser) parseError(code string) {
+	if p.opts.ReportErrors {
+		p.errors = append(p.errors, ParseError{Code: code, Pos: p.curr.Span.Start})
+	}
+}
+
+// unexpected reports the current token as misplaced.
+func (p *Parser) unexpected() {
+	switch p.curr.Type {
+	case lexer.TokenStartTag, lexer.TokenSelfClosingTag:
+		p.parseError("unexpected-start-tag")
+	case lexer.TokenEndTag:
+		p.parseError("unexpected-end-tag")
+	case lexer.TokenText:
+		p.parseError("unexpected-character")
+	case lexer.TokenDoctype:
+		p.parseError("unexpected-doctype")
+	case lexer.TokenEOF:
+		p.parseError("expected-closing-tag-but-got-eof")
+	}
+}
+
+// expectCurrent reports an end tag that has to close elements other than
+// the named ones.
+func (p *Parser) expectCurrent(names ...string) {
+	if !isOneOf(p.currentNode(), names...) {
+		p.parseError("end-tag-too-early")
+	}
+}
+
+// checkUnclosed reports elements still open when the body ends, apart from
+// those whose end tags may be omitted.
+func (p *Parser) checkUnclosed(code string) {
+	for _, n := range p.oe {
+		if !isOneOf(n, "dd", "dt", "li", "optgroup", "option", "p", "rb", "rp", "rt", "rtc",
+			"tbody", "td", "tfoot", "th", "thead", "tr", "body", "html") {
+			p.parseError(code)
+			return
+		}
+	}
+}
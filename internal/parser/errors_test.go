@@ -0,0 +1,58 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string // Codes with their positions, separated by spaces
+	}{
+		{"Valid Document", "<!DOCTYPE html><title>x</title><p>a<p>b<ul><li>1<li>2</ul>", ""},
+		{"Valid Table", "<!DOCTYPE html><table><tr><td>1<td>2</table>", ""},
+		{"Missing Doctype", "<p>x</p>", "expected-doctype@1:1"},
+		{"Legacy Doctype", `<!DOCTYPE html PUBLIC "-//W3C//DTD HTML 4.01//EN"><p>x`, "unknown-doctype@1:1"},
+		{"Late Doctype", "<!DOCTYPE html><p>x</p><!DOCTYPE html>", "unexpected-doctype@1:24"},
+		{"Stray End Tag", "<!DOCTYPE html><p>x</div></p>", "unexpected-end-tag@1:20"},
+		{"Misnested End Tag", "<!DOCTYPE html><div><span>x</div>", "end-tag-too-early@1:28"},
+		{"Misnested Formatting", "<!DOCTYPE html><b><i>x</b></i>", "end-tag-too-early@1:23 unexpected-end-tag@1:27"},
+		{"Unclosed Element", "<!DOCTYPE html><div>x", "expected-closing-tag-but-got-eof@1:22"},
+		{"Self-Closing Div", "<!DOCTYPE html><div/>", "non-void-html-element-start-tag-with-trailing-solidus@1:16 expected-closing-tag-but-got-eof@1:22"},
+		{"Text In Table", "<!DOCTYPE html><table>x</table>", "unexpected-character@1:23"},
+		{"Text After Body", "<!DOCTYPE html><body></body>x", "unexpected-character@1:29"},
+		{"Nested Form", "<!DOCTYPE html><form><form></form>", "unexpected-start-tag@1:22"},
+		{"Tokenizer Errors", "<!DOCTYPE html><p a=1 a=2>x</p><!-- x", "duplicate-attribute@1:24 eof-in-comment@1:38"},
+		{"Errors In Input Order", "<p a=1 a=2>x</div>", "expected-doctype@1:1 duplicate-attribute@1:9 unexpected-end-tag@1:13"},
+		{"Multiple Lines", "<!DOCTYPE html>\n<p>\n</span>", "unexpected-end-tag@3:1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := ParseWithOptions(tt.input, Options{ReportErrors: true})
+			var got []string
+			for _, e := range errs {
+				got = append(got, fmt.Sprintf("%s@%d:%d", e.Code, e.Pos.Line, e.Pos.Column))
+			}
+			if strings.Join(got, " ") != tt.expected {
+				t.Fatalf("Test '%s' - errors wrong. expected=%q, got=%q", tt.name, tt.expected, strings.Join(got, " "))
+			}
+		})
+	}
+}
+
+func TestParseErrorsDisabled(t *testing.T) {
+	doc, errs := ParseWithOptions("<p>x</div><!-- y", Options{})
+	if len(errs) != 0 {
+		t.Fatalf("expected no errors without ReportErrors, got %v", errs)
+	}
+	if got := doc.Body().InnerHTML(); got != "<p>x<!--y--></p>" {
+		t.Fatalf("tree wrong. got=%q", got)
+	}
+	if got := (ParseError{Code: "eof-in-comment", Pos: doc.Span.End}).Error(); got != "line 1, col 17: eof-in-comment" {
+		t.Fatalf("Error() wrong. got=%q", got)
+	}
+}
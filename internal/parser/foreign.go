@@ -0,0 +1,333 @@
+package parser
+
+import (
+	"strings"
+
+	"github.com/rsolovyeaws/go-html-parser/internal/lexer"
+)
+
+// Namespaces of elements and attributes. Elements parsed as HTML have an
+// empty Namespace, see Node.NamespaceURI.
+const (
+	NamespaceHTML   = "http://www.w3.org/1999/xhtml"
+	NamespaceSVG    = "http://www.w3.org/2000/svg"
+	NamespaceMathML = "http://www.w3.org/1998/Math/MathML"
+	NamespaceXLink  = "http://www.w3.org/1999/xlink"
+	NamespaceXML    = "http://www.w3.org/XML/1998/namespace"
+	NamespaceXMLNS  = "http://www.w3.org/2000/xmlns/"
+)
+
+// The contents of <svg> and <math> are foreign content: they are parsed with
+// rules of their own rather than by the insertion modes, until an HTML
+// integration point such as <foreignObject> switches back to HTML. The lexer
+// lower-cases all names, but SVG and MathML are case-sensitive, so names are
+// restored to their proper case with the tables of the HTML5 tree
+// construction stage.
+
+// svgTagNames maps lower-cased SVG element names to their proper case.
+var svgTagNames = map[string]string{
+	"altglyph":            "altGlyph",
+	"altglyphdef":         "altGlyphDef",
+	"altglyphitem":        "altGlyphItem",
+	"animatecolor":        "animateColor",
+	"animatemotion":       "animateMotion",
+	"animatetransform":    "animateTransform",
+	"clippath":            "clipPath",
+	"feblend":             "feBlend",
+	"fecolormatrix":       "feColorMatrix",
+	"fecomponenttransfer": "feComponentTransfer",
+	"fecomposite":         "feComposite",
+	"feconvolvematrix":    "feConvolveMatrix",
+	"fediffuselighting":   "feDiffuseLighting",
+	"fedisplacementmap":   "feDisplacementMap",
+	"fedistantlight":      "feDistantLight",
+	"fedropshadow":        "feDropShadow",
+	"feflood":             "feFlood",
+	"fefunca":             "feFuncA",
+	"fefuncb":             "feFuncB",
+	"fefuncg":             "feFuncG",
+	"fefuncr":             "feFuncR",
+	"fegaussianblur":      "feGaussianBlur",
+	"feimage":             "feImage",
+	"femerge":             "feMerge",
+	"femergenode":         "feMergeNode",
+	"femorphology":        "feMorphology",
+	"feoffset":            "feOffset",
+	"fepointlight":        "fePointLight",
+	"fespecularlighting":  "feSpecularLighting",
+	"fespotlight":         "feSpotLight",
+	"fetile":              "feTile",
+	"feturbulence":        "feTurbulence",
+	"foreignobject":       "foreignObject",
+	"glyphref":            "glyphRef",
+	"lineargradient":      "linearGradient",
+	"radialgradient":      "radialGradient",
+	"textpath":            "textPath",
+}
+
+// svgAttributeNames maps lower-cased SVG attribute names to their proper case.
+var svgAttributeNames = map[string]string{
+	"attributename":       "attributeName",
+	"attributetype":       "attributeType",
+	"basefrequency":       "baseFrequency",
+	"baseprofile":         "baseProfile",
+	"calcmode":            "calcMode",
+	"clippathunits":       "clipPathUnits",
+	"diffuseconstant":     "diffuseConstant",
+	"edgemode":            "edgeMode",
+	"filterunits":         "filterUnits",
+	"glyphref":            "glyphRef",
+	"gradienttransform":   "gradientTransform",
+	"gradientunits":       "gradientUnits",
+	"kernelmatrix":        "kernelMatrix",
+	"kernelunitlength":    "kernelUnitLength",
+	"keypoints":           "keyPoints",
+	"keysplines":          "keySplines",
+	"keytimes":            "keyTimes",
+	"lengthadjust":        "lengthAdjust",
+	"limitingconeangle":   "limitingConeAngle",
+	"markerheight":        "markerHeight",
+	"markerunits":         "markerUnits",
+	"markerwidth":         "markerWidth",
+	"maskcontentunits":    "maskContentUnits",
+	"maskunits":           "maskUnits",
+	"numoctaves":          "numOctaves",
+	"pathlength":          "pathLength",
+	"patterncontentunits": "patternContentUnits",
+	"patterntransform":    "patternTransform",
+	"patternunits":        "patternUnits",
+	"pointsatx":           "pointsAtX",
+	"pointsaty":           "pointsAtY",
+	"pointsatz":           "pointsAtZ",
+	"preservealpha":       "preserveAlpha",
+	"preserveaspectratio": "preserveAspectRatio",
+	"primitiveunits":      "primitiveUnits",
+	"refx":                "refX",
+	"refy":                "refY",
+	"repeatcount":         "repeatCount",
+	"repeatdur":           "repeatDur",
+	"requiredextensions":  "requiredExtensions",
+	"requiredfeatures":    "requiredFeatures",
+	"specularconstant":    "specularConstant",
+	"specularexponent":    "specularExponent",
+	"spreadmethod":        "spreadMethod",
+	"startoffset":         "startOffset",
+	"stddeviation":        "stdDeviation",
+	"stitchtiles":         "stitchTiles",
+	"surfacescale":        "surfaceScale",
+	"systemlanguage":      "systemLanguage",
+	"tablevalues":         "tableValues",
+	"targetx":             "targetX",
+	"targety":             "targetY",
+	"textlength":          "textLength",
+	"viewbox":             "viewBox",
+	"viewtarget":          "viewTarget",
+	"xchannelselector":    "xChannelSelector",
+	"ychannelselector":    "yChannelSelector",
+	"zoomandpan":          "zoomAndPan",
+}
+
+// foreignAttributes maps the namespaced attributes of SVG and MathML
+// elements to their namespace.
+var foreignAttributes = map[string]string{
+	"xlink:actuate": NamespaceXLink,
+	"xlink:arcrole": NamespaceXLink,
+	"xlink:href":    NamespaceXLink,
+	"xlink:role":    NamespaceXLink,
+	"xlink:show":    NamespaceXLink,
+	"xlink:title":   NamespaceXLink,
+	"xlink:type":    NamespaceXLink,
+	"xml:lang":      NamespaceXML,
+	"xml:space":     NamespaceXML,
+	"xmlns":         NamespaceXMLNS,
+	"xmlns:xlink":   NamespaceXMLNS,
+}
+
+// breakoutElements are the HTML elements whose start tags end foreign
+// content, as in "<svg><p>".
+var breakoutElements = map[string]bool{
+	"b": true, "big": true, "blockquote": true, "body": true, "br": true,
+	"center": true, "code": true, "dd": true, "div": true, "dl": true, "dt": true,
+	"em": true, "embed": true, "h1": true, "h2": true, "h3": true, "h4": true,
+	"h5": true, "h6": true, "head": true, "hr": true, "i": true, "img": true,
+	"li": true, "listing": true, "menu": true, "meta": true, "nobr": true,
+	"ol": true, "p": true, "pre": true, "ruby": true, "s": true, "small": true,
+	"span": true, "strong": true, "strike": true, "sub": true, "sup": true,
+	"table": true, "tt": true, "u": true, "ul": true, "var": true,
+}
+
+// adjustForeignNames restores the case of the tag and attribute names of an
+// SVG or MathML element, unless lowerCase is set, and sets the namespace of
+// attributes such as xlink:href.
+func adjustForeignNames(n *Node, lowerCase bool) {
+	switch {
+	case n.Namespace == "":
+		return
+	case lowerCase:
+		// Keep the names as the lexer lower-cased them
+	case n.Namespace == NamespaceSVG:
+		if name, ok := svgTagNames[n.TagName]; ok {
+			n.TagName = name
+		}
+		adjustAttributeNames(n, svgAttributeNames)
+	case n.Namespace == NamespaceMathML:
+		adjustAttributeNames(n, map[string]string{"definitionurl": "definitionURL"})
+	}
+	for i, a := range n.Attributes {
+		if ns, ok := foreignAttributes[a.Name]; ok {
+			n.Attributes[i].Namespace = ns
+		}
+	}
+}
+
+func adjustAttributeNames(n *Node, names map[string]string) {
+	for i, a := range n.Attributes {
+		if name, ok := names[a.Name]; ok {
+			n.Attributes[i].Name = name
+		}
+	}
+}
+
+// isMathMLTextIntegrationPoint reports whether n is a MathML element whose
+// text and most child elements are HTML.
+func isMathMLTextIntegrationPoint(n *Node) bool {
+	return isElement(n, NamespaceMathML, "mi", "mo", "mn", "ms", "mtext")
+}
+
+// isHTMLIntegrationPoint reports whether n is an SVG or MathML element whose
+// contents are HTML.
+func isHTMLIntegrationPoint(n *Node) bool {
+	if isElement(n, NamespaceMathML, "annotation-xml") {
+		encoding, _ := n.Attr("encoding")
+		return strings.EqualFold(encoding, "text/html") || strings.EqualFold(encoding, "application/xhtml+xml")
+	}
+	return isElement(n, NamespaceSVG, "foreignobject", "desc", "title")
+}
+
+// startsHTML reports whether a start tag named name inside the foreign
+// element n is handled as HTML.
+func startsHTML(n *Node, name string) bool {
+	return isMathMLTextIntegrationPoint(n) && name != "mglyph" && name != "malignmark" ||
+		isElement(n, NamespaceMathML, "annotation-xml") && name == "svg" ||
+		isHTMLIntegrationPoint(n)
+}
+
+// breaksOutOfForeign reports whether a start tag ends foreign content.
+func breaksOutOfForeign(tok lexer.Token) bool {
+	if tok.Value == "font" {
+		for _, name := range []string{"color", "face", "size"} {
+			if _, ok := tok.Attr(name); ok {
+				return true
+			}
+		}
+	}
+	return breakoutElements[tok.Value]
+}
+
+// elementNamespace returns the namespace of an element for the start tag tok
+// inside parent, which is nil at the top level. It follows the rules of
+// inForeignContent, except that a breakout tag does not close the foreign
+// elements around it.
+func elementNamespace(tok lexer.Token, parent *Node) string {
+	if parent != nil && parent.Namespace != "" && !startsHTML(parent, tok.Value) && !breaksOutOfForeign(tok) {
+		return parent.Namespace
+	}
+	switch tok.Value {
+	case "svg":
+		return NamespaceSVG
+	case "math":
+		return NamespaceMathML
+	}
+	return ""
+}
+
+// inForeignContent reports whether the current token is handled by the rules
+// for foreign content rather than by the insertion mode.
+func (p *Parser) inForeignContent() bool {
+	n := p.adjustedCurrentNode()
+	if n.Namespace == "" {
+		return false
+	}
+	switch p.curr.Type {
+	case lexer.TokenStartTag, lexer.TokenSelfClosingTag:
+		return !startsHTML(n, p.tokenTag())
+	case lexer.TokenText:
+		return !isMathMLTextIntegrationPoint(n) && !isHTMLIntegrationPoint(n)
+	case lexer.TokenEOF:
+		return false
+	}
+	return true
+}
+
+// foreignContentIM handles a token inside SVG or MathML, see inForeignContent.
+// Elements keep the namespace of the element they are in, and self-closing
+// tags are honoured.
+func foreignContentIM(p *Parser) bool {
+	switch p.curr.Type {
+	case lexer.TokenText:
+		text := p.curr.Value
+		if strings.Contains(text, "\x00") {
+			p.parseError("unexpected-null-character")
+			text = strings.ReplaceAll(text, "\x00", "\uFFFD")
+		}
+		p.insertText(text, p.curr.Span)
+		if !isAllSpace(text) {
+			p.framesetOK = false
+		}
+
+	case lexer.TokenComment:
+		p.insertComment(p.currentNode())
+
+	case lexer.TokenStartTag, lexer.TokenSelfClosingTag:
+		if breaksOutOfForeign(p.curr) {
+			p.unexpected()
+			p.popForeign()
+			return false
+		}
+		p.insertForeign(p.adjustedCurrentNode().Namespace)
+
+	case lexer.TokenEndTag:
+		name := p.tokenTag()
+		if name == "br" || name == "p" {
+			p.unexpected()
+			p.popForeign()
+			return false
+		}
+		if nameOf(p.currentNode()) != name {
+			p.unexpected()
+		}
+		for i := len(p.oe) - 1; i > 0; i-- {
+			if nameOf(p.oe[i]) == name {
+				p.popUntilNode(p.oe[i])
+				return true
+			}
+			if p.oe[i-1].Namespace == "" {
+				return modeHandlers[p.mode](p)
+			}
+		}
+	}
+	return true
+}
+
+// popForeign pops foreign elements until the current node is HTML or an
+// integration point, so that HTML markup in foreign content closes it.
+func (p *Parser) popForeign() {
+	for len(p.oe) > 0 {
+		n := p.currentNode()
+		if n.Namespace == "" || isMathMLTextIntegrationPoint(n) || isHTMLIntegrationPoint(n) {
+			return
+		}
+		p.pop()
+	}
+}
+
+// insertForeign inserts an element in the given namespace for the current
+// start tag and opens it unless the tag is self-closing.
+func (p *Parser) insertForeign(namespace string) *Node {
+	n := p.parseElement(namespace)
+	p.insertNode(n)
+	if p.curr.Type != lexer.TokenSelfClosingTag {
+		p.push(n)
+	}
+	return n
+}
@@ -0,0 +1,140 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestForeignContent(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string // InnerHTML of the body
+	}{
+		{
+			name:     "SVG Names",
+			input:    `<SVG ViewBox="0 0 1 1"><LinearGradient GradientUnits=x></lineargradient></SVG>`,
+			expected: `<svg viewBox="0 0 1 1"><linearGradient gradientUnits="x"></linearGradient></svg>`,
+		},
+		{
+			name:     "Self-Closing Tags",
+			input:    `<svg><g/><circle r=1 /></svg>x`,
+			expected: `<svg><g></g><circle r="1"></circle></svg>x`,
+		},
+		{
+			name:     "Self-Closing Style",
+			input:    `<svg><style/><title/><g>x</g></svg>`,
+			expected: `<svg><style></style><title></title><g>x</g></svg>`,
+		},
+		{
+			name:     "CDATA",
+			input:    `<svg><text><![CDATA[a<b>&amp;]]></text></svg>`,
+			expected: `<svg><text>a&lt;b&gt;&amp;amp;</text></svg>`,
+		},
+		{
+			name:     "CDATA In HTML",
+			input:    `<div><![CDATA[x]]></div>`,
+			expected: `<div><!--[CDATA[x]]--></div>`,
+		},
+		{
+			name:     "Style And Title Hold Markup",
+			input:    `<svg><style>a &amp; b</style><title>&lt;<tspan>t</tspan></title></svg>`,
+			expected: `<svg><style>a &amp; b</style><title>&lt;<tspan>t</tspan></title></svg>`,
+		},
+		{
+			name:     "HTML Breaks Out",
+			input:    `<svg><g><p>x</p></g></svg>`,
+			expected: `<svg><g></g></svg><p>x</p>`,
+		},
+		{
+			name:     "Font With Attributes Breaks Out",
+			input:    `<svg><font>a</font><font color=red>b</font></svg>`,
+			expected: `<svg><font>a</font></svg><font color="red">b</font>`,
+		},
+		{
+			name:     "Foreign Object",
+			input:    `<svg><foreignObject><div>x<clippath></clippath></div></foreignObject><clippath/></svg>`,
+			expected: `<svg><foreignObject><div>x<clippath></clippath></div></foreignObject><clipPath></clipPath></svg>`,
+		},
+		{
+			name:     "MathML",
+			input:    `<math definitionurl=u><mi>x<b>y</b></mi><annotation-xml encoding="text/html"><div>z</div></annotation-xml></math>`,
+			expected: `<math definitionURL="u"><mi>x<b>y</b></mi><annotation-xml encoding="text/html"><div>z</div></annotation-xml></math>`,
+		},
+		{
+			name:     "Misnested End Tag",
+			input:    `<div><svg><g></div>x`,
+			expected: `<div><svg><g></g></svg></div>x`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := New(tt.input).Parse()
+			checkLinks(t, doc.Node)
+			if got := doc.Body().InnerHTML(); got != tt.expected {
+				t.Fatalf("Test '%s' - expected=%q, got=%q", tt.name, tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestForeignNamespaces(t *testing.T) {
+	doc := New(`<svg xmlns:xlink="http://www.w3.org/1999/xlink"><a xlink:href="#x"><foreignObject><p>t</p></foreignObject></a></svg><math><mi>x</mi></math>`).Parse()
+
+	tests := []struct {
+		tag       string
+		namespace string
+	}{
+		{"svg", NamespaceSVG},
+		{"a", NamespaceSVG},
+		{"foreignObject", NamespaceSVG},
+		{"p", ""},
+		{"math", NamespaceMathML},
+		{"mi", NamespaceMathML},
+		{"body", ""},
+	}
+	for _, tt := range tests {
+		n := doc.FindByTag(tt.tag)[0]
+		if n.Namespace != tt.namespace {
+			t.Fatalf("<%s> - namespace wrong. expected=%q, got=%q", tt.tag, tt.namespace, n.Namespace)
+		}
+	}
+	if got := doc.Body().NamespaceURI(); got != NamespaceHTML {
+		t.Fatalf("expected the HTML namespace for body, got=%q", got)
+	}
+
+	attrs := doc.FindByTag("svg")[0].Attributes
+	if attrs[0].Namespace != NamespaceXMLNS {
+		t.Fatalf("xmlns:xlink namespace wrong. got=%q", attrs[0].Namespace)
+	}
+	if attrs := doc.FindByTag("a")[0].Attributes; attrs[0].Name != "xlink:href" || attrs[0].Namespace != NamespaceXLink {
+		t.Fatalf("xlink:href wrong. got=%+v", attrs[0])
+	}
+}
+
+func TestForeignFragment(t *testing.T) {
+	context := &Node{Type: NodeElement, TagName: "svg", Namespace: NamespaceSVG}
+	nodes := ParseFragment(`<clippath/><style><![CDATA[a<b]]></style>`, context)
+	if len(nodes) != 2 {
+		t.Fatalf("expected 2 nodes, got %d", len(nodes))
+	}
+	if nodes[0].TagName != "clipPath" || nodes[0].Namespace != NamespaceSVG {
+		t.Fatalf("first node wrong. got=%s %q", nodes[0].TagName, nodes[0].Namespace)
+	}
+	if got := nodes[1].Children[0].Content; got != "a<b" {
+		t.Fatalf("style text wrong. expected=%q, got=%q", "a<b", got)
+	}
+}
+
+func TestForeignParseEvents(t *testing.T) {
+	r := &recorder{}
+	p := New(`<svg viewbox=v><g/><style><b/>&amp;</style><![CDATA[<x>]]></svg><style><b/></style>`)
+	if err := p.ParseEvents(r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := `<svg viewBox=v> <g> </g> <style> <b> </b> "&" </style> "<x>" </svg> <style> "<b/>" </style>`
+	if got := strings.Join(r.events, " "); got != expected {
+		t.Fatalf("expected=%q, got=%q", expected, got)
+	}
+}
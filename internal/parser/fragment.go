@@ -0,0 +1,68 @@
+package parser
+
+import (
+	"strings"
+
+	"github.com/rsolovyeaws/go-html-parser/internal/lexer"
+)
+
+// ParseFragment parses input as the contents of context, the way browsers
+// parse a value assigned to innerHTML, and returns the top-level nodes. The
+// context decides how the input is read: in a tbody context "<tr>" stays a
+// row, and in a textarea or script context everything is text. A nil context
+// parses the input as the contents of a body element. A context with an SVG
+// or MathML Namespace parses the input as foreign content.
+//
+// The context element is only consulted, never modified. The returned nodes
+// have no parent or siblings, ready to be inserted into a tree.
+func ParseFragment(input string, context *Node) []*Node {
+	if context == nil {
+		context = &Node{Type: NodeElement, TagName: "body"}
+	}
+	if context.Namespace == "" && context.TagName != strings.ToLower(context.TagName) {
+		// The tree builder expects HTML names lower-cased, as the lexer
+		// reads them. The copy keeps context itself unmodified.
+		c := *context
+		c.TagName = strings.ToLower(c.TagName)
+		context = &c
+	}
+	l := lexer.New(input)
+	if context.Namespace == "" {
+		l.SetRawTextElement(context.TagName)
+	}
+	l.SetAllowCDATA(context.Namespace != "")
+
+	p := newParser(l, Options{})
+	p.root = &Node{Type: NodeDocument, Children: []*Node{}}
+	p.context = context
+	p.framesetOK = true
+	p.quirks = NoQuirks
+
+	html := &Node{
+		Type:     NodeElement,
+		TagName:  "html",
+		Children: []*Node{},
+		Span:     lexer.Span{Start: p.curr.Span.Start},
+	}
+	appendChild(p.root, html)
+	p.oe = []*Node{html}
+	if isOneOf(context, "template") {
+		p.templateModes = append(p.templateModes, inTemplateMode)
+	}
+	p.resetInsertionMode()
+	for n := context; n != nil; n = n.Parent {
+		if isOneOf(n, "form") {
+			p.form = n
+			break
+		}
+	}
+
+	p.run()
+
+	nodes := html.Children
+	for _, n := range nodes {
+		n.Parent, n.PrevSibling, n.NextSibling = nil, nil, nil
+	}
+	html.Children = []*Node{}
+	return nodes
+}
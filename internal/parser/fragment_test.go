@@ -0,0 +1,90 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFragment(t *testing.T) {
+	tests := []struct {
+		name     string
+		context  string // "" for a nil context
+		input    string
+		expected string
+	}{
+		{"Nil Context", "", `<li>a<li>b`, `<li>a</li><li>b</li>`},
+		{"Row In Tbody", "tbody", `<tr><td>1</td></tr>`, `<tr><td>1</td></tr>`},
+		{"Row In Body", "body", `<tr><td>1</td></tr>`, `1`},
+		{"Row In Table", "table", `<tr><td>1`, `<tbody><tr><td>1</td></tr></tbody>`},
+		{"Cells In Row", "tr", `<td>1<td>2`, `<td>1</td><td>2</td>`},
+		{"List Items", "ul", "<li>a</li>\n<li>b</li>", "<li>a</li>\n<li>b</li>"},
+		{"Options", "select", `<option>a<option>b<div>c</div>`, `<option>a</option><option>bc</option>`},
+		{"Misnested Formatting", "div", `<b>1<p>2</b>3`, `<b>1</b><p><b>2</b>3</p>`},
+		{"Head Elements", "head", `<title>T</title><meta charset="utf-8">`, `<title>T</title><meta charset="utf-8">`},
+		{"Stray End Tags", "div", `a</div></p>b`, `a<p></p>b`},
+		{"Empty", "div", ``, ``},
+		{"Rows In Template", "template", `<tr><td>1<tr><td>2`, `<tr><td>1</td></tr><tr><td>2</td></tr>`},
+		{"Upper-Case Context", "TBODY", `<tr><td>1</td></tr>`, `<tr><td>1</td></tr>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var context *Node
+			if tt.context != "" {
+				context = &Node{Type: NodeElement, TagName: tt.context}
+			}
+			var sb strings.Builder
+			for _, n := range ParseFragment(tt.input, context) {
+				if n.Parent != nil || n.PrevSibling != nil || n.NextSibling != nil {
+					t.Fatalf("Test '%s' - node %q is still linked", tt.name, n.OuterHTML())
+				}
+				sb.WriteString(n.OuterHTML())
+			}
+			if got := sb.String(); got != tt.expected {
+				t.Fatalf("Test '%s' - expected=%q, got=%q", tt.name, tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestParseFragmentRawText(t *testing.T) {
+	tests := []struct {
+		context  string
+		input    string
+		expected string
+	}{
+		{"textarea", `<b>a &amp; b</b>`, `<b>a & b</b>`},
+		{"title", `x<br>y`, `x<br>y`},
+		{"script", `if (a < b && c) {} // &amp;`, `if (a < b && c) {} // &amp;`},
+		{"style", `p > a { color: red }`, `p > a { color: red }`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.context, func(t *testing.T) {
+			nodes := ParseFragment(tt.input, &Node{Type: NodeElement, TagName: tt.context})
+			if len(nodes) != 1 || nodes[0].Type != NodeText {
+				t.Fatalf("Test '%s' - expected a single text node, got %d nodes", tt.context, len(nodes))
+			}
+			if nodes[0].Content != tt.expected {
+				t.Fatalf("Test '%s' - expected=%q, got=%q", tt.context, tt.expected, nodes[0].Content)
+			}
+		})
+	}
+}
+
+func TestParseFragmentInsertion(t *testing.T) {
+	doc := New(`<table id="t"><tbody><tr><td>1</td></tr></tbody></table>`).Parse()
+	tbody := doc.FindByTag("tbody")[0]
+	for _, n := range ParseFragment(`<tr><td>2</td></tr><tr><td>3</td></tr>`, tbody) {
+		tbody.AppendChild(n)
+	}
+	checkLinks(t, doc.Node)
+
+	cells, err := doc.QueryAll("table > tbody > tr > td")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := describe(cells); got != "td(1) td(2) td(3)" {
+		t.Fatalf("expected=%q, got=%q", "td(1) td(2) td(3)", got)
+	}
+}
@@ -0,0 +1,1287 @@
+package parser
+
+import (
+	"strings"
+
+	"github.com/rsolovyeaws/go-html-parser/internal/lexer"
+)
+
+// The insertion modes of the HTML5 tree construction stage. Each one handles
+// p.curr and returns false when the token has to be reprocessed in the mode
+// it switched to. Whitespace-only differences in text tokens are handled by
+// splitting the token: leading whitespace is processed first and the rest is
+// reprocessed.
+
+// initialIM is the mode before anything has been seen.
+func initialIM(p *Parser) bool {
+	switch p.curr.Type {
+	case lexer.TokenText:
+		p.splitSpace()
+		if p.curr.Value == "" {
+			return true
+		}
+	case lexer.TokenComment:
+		p.insertComment(p.root)
+		return true
+	case lexer.TokenDoctype:
+		appendChild(p.root, &Node{
+			Type:     NodeDoctype,
+			Content:  p.curr.Value,
+			PublicID: p.curr.PublicID,
+			SystemID: p.curr.SystemID,
+			Children: []*Node{},
+			Span:     p.curr.Span,
+		})
+		if p.curr.Value != "html" || p.curr.PublicID != "" ||
+			(p.curr.HasSystemID && p.curr.SystemID != "about:legacy-compat") {
+			p.parseError("unknown-doctype")
+		}
+		p.quirks = doctypeQuirksMode(p.curr)
+		p.mode = beforeHTMLMode
+		return true
+	}
+	// A document without a doctype is rendered in quirks mode
+	p.parseError("expected-doctype")
+	p.quirks = Quirks
+	p.mode = beforeHTMLMode
+	return false
+}
+
+func beforeHTMLIM(p *Parser) bool {
+	switch p.curr.Type {
+	case lexer.TokenText:
+		p.splitSpace()
+		if p.curr.Value == "" {
+			return true
+		}
+	case lexer.TokenComment:
+		p.insertComment(p.root)
+		return true
+	case lexer.TokenStartTag, lexer.TokenSelfClosingTag:
+		if p.tokenTag() == "html" {
+			p.insertElement()
+			p.mode = beforeHeadMode
+			return true
+		}
+	case lexer.TokenEndTag:
+		switch p.tokenTag() {
+		case "head", "body", "html", "br":
+		default:
+			p.unexpected()
+			return true
+		}
+	}
+	p.insertImplied("html")
+	p.mode = beforeHeadMode
+	return false
+}
+
+func beforeHeadIM(p *Parser) bool {
+	switch p.curr.Type {
+	case lexer.TokenText:
+		p.splitSpace()
+		if p.curr.Value == "" {
+			return true
+		}
+	case lexer.TokenComment:
+		p.insertComment(p.currentNode())
+		return true
+	case lexer.TokenStartTag, lexer.TokenSelfClosingTag:
+		switch p.tokenTag() {
+		case "html":
+			return inBodyIM(p)
+		case "head":
+			p.head = p.insertElement()
+			p.mode = inHeadMode
+			return true
+		}
+	case lexer.TokenEndTag:
+		switch p.tokenTag() {
+		case "head", "body", "html", "br":
+		default:
+			p.unexpected()
+			return true
+		}
+	}
+	p.head = p.insertImplied("head")
+	p.mode = inHeadMode
+	return false
+}
+
+func inHeadIM(p *Parser) bool {
+	switch p.curr.Type {
+	case lexer.TokenText:
+		if space, span := p.splitSpace(); space != "" {
+			p.insertText(space, span)
+		}
+		if p.curr.Value == "" {
+			return true
+		}
+	case lexer.TokenComment:
+		p.insertComment(p.currentNode())
+		return true
+	case lexer.TokenStartTag, lexer.TokenSelfClosingTag:
+		switch p.tokenTag() {
+		case "html":
+			return inBodyIM(p)
+		case "base", "basefont", "bgsound", "link", "meta":
+			p.insertVoid()
+			return true
+		case "title", "noframes", "style", "script":
+			p.insertRawText()
+			return true
+		case "noscript":
+			p.insertElement()
+			p.mode = inHeadNoscriptMode
+			return true
+		case "template":
+			p.insertElement()
+			p.insertMarker()
+			p.framesetOK = false
+			p.mode = inTemplateMode
+			p.templateModes = append(p.templateModes, inTemplateMode)
+			return true
+		case "head":
+			p.unexpected()
+			return true
+		}
+	case lexer.TokenEndTag:
+		switch p.tokenTag() {
+		case "head":
+			p.pop()
+			p.mode = afterHeadMode
+			return true
+		case "template":
+			p.closeTemplate()
+			return true
+		case "body", "html", "br":
+		default:
+			p.unexpected()
+			return true
+		}
+	}
+	p.pop()
+	p.mode = afterHeadMode
+	return false
+}
+
+// closeTemplate closes the innermost template element for </template>,
+// together with anything left open inside it.
+func (p *Parser) closeTemplate() {
+	if !p.isOnStack("template") {
+		p.unexpected()
+		return
+	}
+	p.generateAllImpliedEndTags()
+	p.expectCurrent("template")
+	p.popUntil("template")
+	p.clearFormattingToMarker()
+	p.popTemplateMode()
+	p.resetInsertionMode()
+}
+
+// inHeadNoscriptIM handles <noscript> in the head. Scripting is considered
+// disabled, so its contents are parsed as markup.
+func inHeadNoscriptIM(p *Parser) bool {
+	switch p.curr.Type {
+	case lexer.TokenText:
+		if space, span := p.splitSpace(); space != "" {
+			p.insertText(space, span)
+		}
+		if p.curr.Value == "" {
+			return true
+		}
+	case lexer.TokenComment:
+		return inHeadIM(p)
+	case lexer.TokenStartTag, lexer.TokenSelfClosingTag:
+		switch p.tokenTag() {
+		case "html":
+			return inBodyIM(p)
+		case "basefont", "bgsound", "link", "meta", "noframes", "style":
+			return inHeadIM(p)
+		case "head", "noscript":
+			p.unexpected()
+			return true
+		}
+	case lexer.TokenEndTag:
+		switch p.tokenTag() {
+		case "noscript":
+			p.pop()
+			p.mode = inHeadMode
+			return true
+		case "br":
+		default:
+			p.unexpected()
+			return true
+		}
+	}
+	p.unexpected()
+	p.pop()
+	p.mode = inHeadMode
+	return false
+}
+
+func afterHeadIM(p *Parser) bool {
+	switch p.curr.Type {
+	case lexer.TokenText:
+		if space, span := p.splitSpace(); space != "" {
+			p.insertText(space, span)
+		}
+		if p.curr.Value == "" {
+			return true
+		}
+	case lexer.TokenComment:
+		p.insertComment(p.currentNode())
+		return true
+	case lexer.TokenStartTag, lexer.TokenSelfClosingTag:
+		switch p.tokenTag() {
+		case "html":
+			return inBodyIM(p)
+		case "body":
+			p.insertElement()
+			p.framesetOK = false
+			p.mode = inBodyMode
+			return true
+		case "frameset":
+			p.insertElement()
+			p.mode = inFramesetMode
+			return true
+		case "base", "basefont", "bgsound", "link", "meta", "noframes", "script", "style", "template", "title":
+			// Elements that belong in the head go there even when they come late
+			p.unexpected()
+			p.push(p.head)
+			done := inHeadIM(p)
+			p.removeFromStack(p.head)
+			return done
+		case "head":
+			p.unexpected()
+			return true
+		}
+	case lexer.TokenEndTag:
+		switch p.tokenTag() {
+		case "template":
+			return inHeadIM(p)
+		case "body", "html", "br":
+		default:
+			p.unexpected()
+			return true
+		}
+	}
+	p.insertImplied("body")
+	p.mode = inBodyMode
+	return false
+}
+
+func inBodyIM(p *Parser) bool {
+	switch p.curr.Type {
+	case lexer.TokenText:
+		text := strings.ReplaceAll(p.curr.Value, "\x00", "")
+		if text == "" {
+			return true
+		}
+		p.reconstructFormatting()
+		p.insertText(text, p.curr.Span)
+		if !isAllSpace(text) {
+			p.framesetOK = false
+		}
+
+	case lexer.TokenComment:
+		p.insertComment(p.currentNode())
+
+	case lexer.TokenStartTag, lexer.TokenSelfClosingTag:
+		return p.inBodyStartTag()
+
+	case lexer.TokenEndTag:
+		return p.inBodyEndTag()
+
+	case lexer.TokenEOF:
+		if len(p.templateModes) > 0 {
+			return inTemplateIM(p)
+		}
+		p.checkUnclosed("expected-closing-tag-but-got-eof")
+	}
+	return true
+}
+
+func (p *Parser) inBodyStartTag() bool {
+	switch name := p.tokenTag(); name {
+	case "html":
+		p.unexpected()
+		p.addMissingAttributes(p.oe[0])
+	case "base", "basefont", "bgsound", "link", "meta", "noframes", "script", "style", "template", "title":
+		return inHeadIM(p)
+	case "body":
+		p.unexpected()
+		if len(p.oe) < 2 || nameOf(p.oe[1]) != "body" || p.isOnStack("template") {
+			return true
+		}
+		p.framesetOK = false
+		p.addMissingAttributes(p.oe[1])
+	case "frameset":
+		p.unexpected()
+		if len(p.oe) < 2 || nameOf(p.oe[1]) != "body" || !p.framesetOK {
+			return true
+		}
+		p.oe[1].Detach()
+		for len(p.oe) > 1 {
+			p.pop()
+		}
+		p.insertElement()
+		p.mode = inFramesetMode
+	case "address", "article", "aside", "blockquote", "center", "details", "dialog",
+		"dir", "div", "dl", "fieldset", "figcaption", "figure", "footer", "header",
+		"hgroup", "main", "menu", "nav", "ol", "p", "search", "section", "summary", "ul":
+		p.closeP()
+		p.insertElement()
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		p.closeP()
+		if isOneOf(p.currentNode(), "h1", "h2", "h3", "h4", "h5", "h6") {
+			p.unexpected()
+			p.pop()
+		}
+		p.insertElement()
+	case "pre", "listing":
+		p.closeP()
+		p.insertElement()
+		p.skipNewline = true
+		p.framesetOK = false
+	case "form":
+		// Forms in templates are not tracked by the form element pointer
+		inTemplate := p.isOnStack("template")
+		if p.form != nil && !inTemplate {
+			p.unexpected()
+			return true
+		}
+		p.closeP()
+		if form := p.insertElement(); !inTemplate {
+			p.form = form
+		}
+	case "li", "dd", "dt":
+		p.framesetOK = false
+		closes := []string{name}
+		if name != "li" {
+			closes = []string{"dd", "dt"}
+		}
+		for i := len(p.oe) - 1; i >= 0; i-- {
+			n := p.oe[i]
+			if isOneOf(n, closes...) {
+				p.generateImpliedEndTags(nameOf(n))
+				p.expectCurrent(nameOf(n))
+				p.popUntilNode(n)
+				break
+			}
+			if isSpecial(n) && !isOneOf(n, "address", "div", "p") {
+				break
+			}
+		}
+		p.closeP()
+		p.insertElement()
+	case "plaintext":
+		p.closeP()
+		p.insertElement()
+		p.lexer.SetRawTextElement("plaintext")
+	case "button":
+		if p.inScope(defaultScope, "button") {
+			p.unexpected()
+			p.generateImpliedEndTags()
+			p.popUntil("button")
+		}
+		p.reconstructFormatting()
+		p.insertElement()
+		p.framesetOK = false
+	case "a":
+		if a := p.activeFormatting("a"); a != nil {
+			p.unexpected()
+			p.adoptionAgency("a")
+			p.removeFormatting(a)
+			p.removeFromStack(a)
+		}
+		p.reconstructFormatting()
+		p.pushFormatting(p.insertElement())
+	case "b", "big", "code", "em", "font", "i", "s", "small", "strike", "strong", "tt", "u":
+		p.reconstructFormatting()
+		p.pushFormatting(p.insertElement())
+	case "nobr":
+		p.reconstructFormatting()
+		if p.inScope(defaultScope, "nobr") {
+			p.unexpected()
+			p.adoptionAgency("nobr")
+			p.reconstructFormatting()
+		}
+		p.pushFormatting(p.insertElement())
+	case "applet", "marquee", "object":
+		p.reconstructFormatting()
+		p.insertElement()
+		p.insertMarker()
+		p.framesetOK = false
+	case "table":
+		// Quirks mode lets a table sit inside a paragraph
+		if p.quirks != Quirks {
+			p.closeP()
+		}
+		p.insertElement()
+		p.framesetOK = false
+		p.mode = inTableMode
+	case "area", "br", "embed", "img", "keygen", "wbr":
+		p.reconstructFormatting()
+		p.insertVoid()
+		p.framesetOK = false
+	case "input":
+		p.reconstructFormatting()
+		n := p.insertVoid()
+		if typ, _ := n.Attr("type"); !strings.EqualFold(typ, "hidden") {
+			p.framesetOK = false
+		}
+	case "param", "source", "track":
+		p.insertVoid()
+	case "hr":
+		p.closeP()
+		p.insertVoid()
+		p.framesetOK = false
+	case "image":
+		// An old alias for img
+		p.unexpected()
+		p.curr.Value = "img"
+		return false
+	case "textarea":
+		p.insertRawText()
+		p.skipNewline = true
+		p.framesetOK = false
+	case "xmp":
+		p.closeP()
+		p.reconstructFormatting()
+		p.framesetOK = false
+		p.insertRawText()
+	case "iframe":
+		p.framesetOK = false
+		p.insertRawText()
+	case "noembed":
+		p.insertRawText()
+	case "select":
+		p.reconstructFormatting()
+		p.insertElement()
+		p.framesetOK = false
+		if p.inTableMode() {
+			p.mode = inSelectInTableMode
+		} else {
+			p.mode = inSelectMode
+		}
+	case "optgroup", "option":
+		if isOneOf(p.currentNode(), "option") {
+			p.pop()
+		}
+		p.reconstructFormatting()
+		p.insertElement()
+	case "rb", "rtc":
+		if p.inScope(defaultScope, "ruby") {
+			p.generateImpliedEndTags()
+			p.expectCurrent("ruby")
+		}
+		p.insertElement()
+	case "rp", "rt":
+		if p.inScope(defaultScope, "ruby") {
+			p.generateImpliedEndTags("rtc")
+			p.expectCurrent("ruby", "rtc")
+		}
+		p.insertElement()
+	case "caption", "col", "colgroup", "frame", "head", "tbody", "td", "tfoot", "th", "thead", "tr":
+		// Only allowed in tables and framesets
+		p.unexpected()
+	case "math":
+		p.reconstructFormatting()
+		p.insertForeign(NamespaceMathML)
+	case "svg":
+		p.reconstructFormatting()
+		p.insertForeign(NamespaceSVG)
+	default:
+		p.reconstructFormatting()
+		if p.curr.Type == lexer.TokenSelfClosingTag && isVoidElement(name) {
+			p.insertVoid()
+		} else {
+			p.insertElement()
+		}
+	}
+	return true
+}
+
+func (p *Parser) inBodyEndTag() bool {
+	switch name := p.tokenTag(); name {
+	case "body":
+		if !p.inScope(defaultScope, "body") {
+			p.unexpected()
+			return true
+		}
+		p.checkUnclosed("end-tag-too-early")
+		p.mode = afterBodyMode
+	case "html":
+		if !p.inScope(defaultScope, "body") {
+			p.unexpected()
+			return true
+		}
+		p.checkUnclosed("end-tag-too-early")
+		p.mode = afterBodyMode
+		return false
+	case "address", "article", "aside", "blockquote", "button", "center", "details",
+		"dialog", "dir", "div", "dl", "fieldset", "figcaption", "figure", "footer",
+		"header", "hgroup", "listing", "main", "menu", "nav", "ol", "pre", "search",
+		"section", "summary", "ul":
+		if !p.inScope(defaultScope, name) {
+			p.unexpected()
+			return true
+		}
+		p.generateImpliedEndTags()
+		p.expectCurrent(name)
+		p.popUntil(name)
+	case "form":
+		if p.isOnStack("template") {
+			if !p.inScope(defaultScope, "form") {
+				p.unexpected()
+				return true
+			}
+			p.generateImpliedEndTags()
+			p.expectCurrent("form")
+			p.popUntil("form")
+			return true
+		}
+		form := p.form
+		p.form = nil
+		if form == nil || !p.isNodeInScope(defaultScope, form) {
+			p.unexpected()
+			return true
+		}
+		p.generateImpliedEndTags()
+		if p.currentNode() != form {
+			p.parseError("end-tag-too-early")
+		}
+		p.removeFromStack(form)
+		p.closeSpan(form)
+	case "p":
+		if !p.inScope(buttonScope, "p") {
+			p.unexpected()
+			p.insertImplied("p")
+		}
+		p.generateImpliedEndTags("p")
+		p.expectCurrent("p")
+		p.popUntil("p")
+	case "li":
+		if !p.inScope(listItemScope, "li") {
+			p.unexpected()
+			return true
+		}
+		p.generateImpliedEndTags("li")
+		p.expectCurrent("li")
+		p.popUntil("li")
+	case "dd", "dt":
+		if !p.inScope(defaultScope, name) {
+			p.unexpected()
+			return true
+		}
+		p.generateImpliedEndTags(name)
+		p.expectCurrent(name)
+		p.popUntil(name)
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		if !p.inScope(defaultScope, "h1", "h2", "h3", "h4", "h5", "h6") {
+			p.unexpected()
+			return true
+		}
+		p.generateImpliedEndTags()
+		p.expectCurrent(name)
+		p.popUntil("h1", "h2", "h3", "h4", "h5", "h6")
+	case "a", "b", "big", "code", "em", "font", "i", "nobr", "s", "small", "strike", "strong", "tt", "u":
+		if !p.adoptionAgency(name) {
+			p.anyOtherEndTag(name)
+		}
+	case "applet", "marquee", "object":
+		if !p.inScope(defaultScope, name) {
+			p.unexpected()
+			return true
+		}
+		p.generateImpliedEndTags()
+		p.expectCurrent(name)
+		p.popUntil(name)
+		p.clearFormattingToMarker()
+	case "template":
+		return inHeadIM(p)
+	case "br":
+		// </br> is treated as <br>
+		p.unexpected()
+		p.curr.Type = lexer.TokenStartTag
+		p.curr.Attributes = nil
+		return false
+	default:
+		p.anyOtherEndTag(name)
+	}
+	return true
+}
+
+// anyOtherEndTag closes the innermost open element with the given name,
+// unless a special element is open inside it.
+func (p *Parser) anyOtherEndTag(name string) {
+	for i := len(p.oe) - 1; i >= 0; i-- {
+		n := p.oe[i]
+		if isOneOf(n, name) {
+			p.generateImpliedEndTags(name)
+			p.expectCurrent(name)
+			p.popUntilNode(n)
+			return
+		}
+		if isSpecial(n) {
+			p.unexpected()
+			return
+		}
+	}
+}
+
+// inTableMode reports whether the parser is in one of the table modes, where
+// a <select> needs to be closed by table markup.
+func (p *Parser) inTableMode() bool {
+	switch p.mode {
+	case inTableMode, inCaptionMode, inTableBodyMode, inRowMode, inCellMode:
+		return true
+	}
+	return false
+}
+
+// textIM handles the contents of raw-text elements such as <script> and
+// <textarea>, which the lexer returns as a single text token.
+func textIM(p *Parser) bool {
+	switch p.curr.Type {
+	case lexer.TokenText:
+		p.insertText(p.curr.Value, p.curr.Span)
+		return true
+	case lexer.TokenEndTag:
+		p.pop()
+		p.mode = p.originalMode
+		return true
+	}
+	// End of input closes the element too
+	p.unexpected()
+	p.pop()
+	p.mode = p.originalMode
+	return false
+}
+
+func inTableIM(p *Parser) bool {
+	switch p.curr.Type {
+	case lexer.TokenText:
+		if isOneOf(p.currentNode(), "table", "tbody", "template", "tfoot", "thead", "tr") && isAllSpace(p.curr.Value) {
+			p.insertText(p.curr.Value, p.curr.Span)
+			return true
+		}
+	case lexer.TokenComment:
+		p.insertComment(p.currentNode())
+		return true
+	case lexer.TokenStartTag, lexer.TokenSelfClosingTag:
+		switch p.tokenTag() {
+		case "caption":
+			p.clearStackToContext("table", "template")
+			p.insertMarker()
+			p.insertElement()
+			p.mode = inCaptionMode
+			return true
+		case "colgroup":
+			p.clearStackToContext("table", "template")
+			p.insertElement()
+			p.mode = inColumnGroupMode
+			return true
+		case "col":
+			p.clearStackToContext("table", "template")
+			p.insertImplied("colgroup")
+			p.mode = inColumnGroupMode
+			return false
+		case "tbody", "tfoot", "thead":
+			p.clearStackToContext("table", "template")
+			p.insertElement()
+			p.mode = inTableBodyMode
+			return true
+		case "td", "th", "tr":
+			p.clearStackToContext("table", "template")
+			p.insertImplied("tbody")
+			p.mode = inTableBodyMode
+			return false
+		case "table":
+			p.unexpected()
+			if !p.inScope(tableScope, "table") {
+				return true
+			}
+			p.popUntil("table")
+			p.resetInsertionMode()
+			return false
+		case "style", "script", "template":
+			return inHeadIM(p)
+		case "input":
+			if typ, _ := p.curr.Attr("type"); !strings.EqualFold(typ, "hidden") {
+				break
+			}
+			p.unexpected()
+			p.insertVoid()
+			return true
+		case "form":
+			p.unexpected()
+			if p.form == nil && !p.isOnStack("template") {
+				p.form = p.insertVoid()
+			}
+			return true
+		}
+	case lexer.TokenEndTag:
+		switch p.tokenTag() {
+		case "table":
+			if !p.inScope(tableScope, "table") {
+				p.unexpected()
+				return true
+			}
+			p.popUntil("table")
+			p.resetInsertionMode()
+			return true
+		case "template":
+			return inHeadIM(p)
+		case "body", "caption", "col", "colgroup", "html", "tbody", "td", "tfoot", "th", "thead", "tr":
+			p.unexpected()
+			return true
+		}
+	case lexer.TokenEOF:
+		return inBodyIM(p)
+	}
+	// Anything else is moved out of the table
+	p.unexpected()
+	p.fosterParenting = true
+	done := inBodyIM(p)
+	p.fosterParenting = false
+	return done
+}
+
+func inCaptionIM(p *Parser) bool {
+	switch p.curr.Type {
+	case lexer.TokenStartTag, lexer.TokenSelfClosingTag:
+		switch p.tokenTag() {
+		case "caption", "col", "colgroup", "tbody", "td", "tfoot", "th", "thead", "tr":
+			return !p.closeCaption()
+		}
+	case lexer.TokenEndTag:
+		switch p.tokenTag() {
+		case "caption":
+			p.closeCaption()
+			return true
+		case "table":
+			return !p.closeCaption()
+		case "body", "col", "colgroup", "html", "tbody", "td", "tfoot", "th", "thead", "tr":
+			p.unexpected()
+			return true
+		}
+	}
+	return inBodyIM(p)
+}
+
+// closeCaption closes an open caption element and reports whether there was one.
+func (p *Parser) closeCaption() bool {
+	if !p.inScope(tableScope, "caption") {
+		p.unexpected()
+		return false
+	}
+	p.generateImpliedEndTags()
+	p.expectCurrent("caption")
+	p.popUntil("caption")
+	p.clearFormattingToMarker()
+	p.mode = inTableMode
+	return true
+}
+
+func inColumnGroupIM(p *Parser) bool {
+	switch p.curr.Type {
+	case lexer.TokenText:
+		if space, span := p.splitSpace(); space != "" {
+			p.insertText(space, span)
+		}
+		if p.curr.Value == "" {
+			return true
+		}
+	case lexer.TokenComment:
+		p.insertComment(p.currentNode())
+		return true
+	case lexer.TokenStartTag, lexer.TokenSelfClosingTag:
+		switch p.tokenTag() {
+		case "html":
+			return inBodyIM(p)
+		case "col":
+			p.insertVoid()
+			return true
+		case "template":
+			return inHeadIM(p)
+		}
+	case lexer.TokenEndTag:
+		switch p.tokenTag() {
+		case "template":
+			return inHeadIM(p)
+		case "colgroup":
+			if !isOneOf(p.currentNode(), "colgroup") {
+				p.unexpected()
+				return true
+			}
+			p.pop()
+			p.mode = inTableMode
+			return true
+		case "col":
+			p.unexpected()
+			return true
+		}
+	case lexer.TokenEOF:
+		return inBodyIM(p)
+	}
+	if !isOneOf(p.currentNode(), "colgroup") {
+		p.unexpected()
+		return true
+	}
+	p.pop()
+	p.mode = inTableMode
+	return false
+}
+
+func inTableBodyIM(p *Parser) bool {
+	switch p.curr.Type {
+	case lexer.TokenStartTag, lexer.TokenSelfClosingTag:
+		switch p.tokenTag() {
+		case "tr":
+			p.clearStackToContext("tbody", "tfoot", "thead", "template")
+			p.insertElement()
+			p.mode = inRowMode
+			return true
+		case "td", "th":
+			p.unexpected()
+			p.clearStackToContext("tbody", "tfoot", "thead", "template")
+			p.insertImplied("tr")
+			p.mode = inRowMode
+			return false
+		case "caption", "col", "colgroup", "tbody", "tfoot", "thead":
+			if !p.inScope(tableScope, "tbody", "thead", "tfoot") {
+				p.unexpected()
+				return true
+			}
+			p.clearStackToContext("tbody", "tfoot", "thead", "template")
+			p.pop()
+			p.mode = inTableMode
+			return false
+		}
+	case lexer.TokenEndTag:
+		switch name := p.tokenTag(); name {
+		case "tbody", "tfoot", "thead":
+			if !p.inScope(tableScope, name) {
+				p.unexpected()
+				return true
+			}
+			p.clearStackToContext("tbody", "tfoot", "thead", "template")
+			p.pop()
+			p.mode = inTableMode
+			return true
+		case "table":
+			if !p.inScope(tableScope, "tbody", "thead", "tfoot") {
+				p.unexpected()
+				return true
+			}
+			p.clearStackToContext("tbody", "tfoot", "thead", "template")
+			p.pop()
+			p.mode = inTableMode
+			return false
+		case "body", "caption", "col", "colgroup", "html", "td", "th", "tr":
+			p.unexpected()
+			return true
+		}
+	}
+	return inTableIM(p)
+}
+
+func inRowIM(p *Parser) bool {
+	switch p.curr.Type {
+	case lexer.TokenStartTag, lexer.TokenSelfClosingTag:
+		switch p.tokenTag() {
+		case "td", "th":
+			p.clearStackToContext("tr", "template")
+			p.insertElement()
+			p.insertMarker()
+			p.mode = inCellMode
+			return true
+		case "caption", "col", "colgroup", "tbody", "tfoot", "thead", "tr":
+			return !p.closeRow()
+		}
+	case lexer.TokenEndTag:
+		switch name := p.tokenTag(); name {
+		case "tr":
+			p.closeRow()
+			return true
+		case "table":
+			return !p.closeRow()
+		case "tbody", "tfoot", "thead":
+			if !p.inScope(tableScope, name) {
+				p.unexpected()
+				return true
+			}
+			return !p.closeRow()
+		case "body", "caption", "col", "colgroup", "html", "td", "th":
+			p.unexpected()
+			return true
+		}
+	}
+	return inTableIM(p)
+}
+
+// closeRow closes an open tr element and reports whether there was one.
+func (p *Parser) closeRow() bool {
+	if !p.inScope(tableScope, "tr") {
+		p.unexpected()
+		return false
+	}
+	p.clearStackToContext("tr", "template")
+	p.pop()
+	p.mode = inTableBodyMode
+	return true
+}
+
+func inCellIM(p *Parser) bool {
+	switch p.curr.Type {
+	case lexer.TokenStartTag, lexer.TokenSelfClosingTag:
+		switch p.tokenTag() {
+		case "caption", "col", "colgroup", "tbody", "td", "tfoot", "th", "thead", "tr":
+			if !p.inScope(tableScope, "td", "th") {
+				p.unexpected()
+				return true
+			}
+			p.closeCell()
+			return false
+		}
+	case lexer.TokenEndTag:
+		switch name := p.tokenTag(); name {
+		case "td", "th":
+			if !p.inScope(tableScope, name) {
+				p.unexpected()
+				return true
+			}
+			p.generateImpliedEndTags()
+			p.expectCurrent(name)
+			p.popUntil(name)
+			p.clearFormattingToMarker()
+			p.resetInsertionMode()
+			return true
+		case "body", "caption", "col", "colgroup", "html":
+			p.unexpected()
+			return true
+		case "table", "tbody", "tfoot", "thead", "tr":
+			if !p.inScope(tableScope, name) {
+				p.unexpected()
+				return true
+			}
+			p.closeCell()
+			return false
+		}
+	}
+	return inBodyIM(p)
+}
+
+func (p *Parser) closeCell() {
+	p.generateImpliedEndTags()
+	p.expectCurrent("td", "th")
+	p.popUntil("td", "th")
+	p.clearFormattingToMarker()
+	p.resetInsertionMode()
+}
+
+func inSelectIM(p *Parser) bool {
+	switch p.curr.Type {
+	case lexer.TokenText:
+		if text := strings.ReplaceAll(p.curr.Value, "\x00", ""); text != "" {
+			p.insertText(text, p.curr.Span)
+		}
+	case lexer.TokenComment:
+		p.insertComment(p.currentNode())
+	case lexer.TokenStartTag, lexer.TokenSelfClosingTag:
+		switch p.tokenTag() {
+		case "html":
+			return inBodyIM(p)
+		case "option":
+			if isOneOf(p.currentNode(), "option") {
+				p.pop()
+			}
+			p.insertElement()
+		case "optgroup":
+			if isOneOf(p.currentNode(), "option") {
+				p.pop()
+			}
+			if isOneOf(p.currentNode(), "optgroup") {
+				p.pop()
+			}
+			p.insertElement()
+		case "hr":
+			if isOneOf(p.currentNode(), "option") {
+				p.pop()
+			}
+			if isOneOf(p.currentNode(), "optgroup") {
+				p.pop()
+			}
+			p.insertVoid()
+		case "select":
+			p.unexpected()
+			if p.inScope(selectScope, "select") {
+				p.popUntil("select")
+				p.resetInsertionMode()
+			}
+		case "input", "keygen", "textarea":
+			p.unexpected()
+			if !p.inScope(selectScope, "select") {
+				return true
+			}
+			p.popUntil("select")
+			p.resetInsertionMode()
+			return false
+		case "script", "template":
+			return inHeadIM(p)
+		default:
+			p.unexpected()
+		}
+	case lexer.TokenEndTag:
+		switch p.tokenTag() {
+		case "optgroup":
+			if n := len(p.oe); n > 1 && isOneOf(p.oe[n-1], "option") && isOneOf(p.oe[n-2], "optgroup") {
+				p.pop()
+			}
+			if isOneOf(p.currentNode(), "optgroup") {
+				p.pop()
+			} else {
+				p.unexpected()
+			}
+		case "option":
+			if isOneOf(p.currentNode(), "option") {
+				p.pop()
+			} else {
+				p.unexpected()
+			}
+		case "select":
+			if p.inScope(selectScope, "select") {
+				p.popUntil("select")
+				p.resetInsertionMode()
+			} else {
+				p.unexpected()
+			}
+		case "template":
+			return inHeadIM(p)
+		default:
+			p.unexpected()
+		}
+	case lexer.TokenEOF:
+		return inBodyIM(p)
+	}
+	return true
+}
+
+func inSelectInTableIM(p *Parser) bool {
+	switch p.curr.Type {
+	case lexer.TokenStartTag, lexer.TokenSelfClosingTag, lexer.TokenEndTag:
+		switch name := p.tokenTag(); name {
+		case "caption", "table", "tbody", "tfoot", "thead", "tr", "td", "th":
+			p.unexpected()
+			if p.curr.Type == lexer.TokenEndTag && !p.inScope(tableScope, name) {
+				return true
+			}
+			p.popUntil("select")
+			p.resetInsertionMode()
+			return false
+		}
+	}
+	return inSelectIM(p)
+}
+
+// inTemplateIM handles the contents of a template element. The first start
+// tag picks the mode for the rest, so that "<template><tr>" keeps its row.
+func inTemplateIM(p *Parser) bool {
+	switch p.curr.Type {
+	case lexer.TokenText, lexer.TokenComment:
+		return inBodyIM(p)
+	case lexer.TokenStartTag, lexer.TokenSelfClosingTag:
+		switch p.tokenTag() {
+		case "base", "basefont", "bgsound", "link", "meta", "noframes", "script", "style", "template", "title":
+			return inHeadIM(p)
+		case "caption", "colgroup", "tbody", "tfoot", "thead":
+			p.switchTemplateMode(inTableMode)
+		case "col":
+			p.switchTemplateMode(inColumnGroupMode)
+		case "tr":
+			p.switchTemplateMode(inTableBodyMode)
+		case "td", "th":
+			p.switchTemplateMode(inRowMode)
+		default:
+			p.switchTemplateMode(inBodyMode)
+		}
+		return false
+	case lexer.TokenEndTag:
+		if p.tokenTag() == "template" {
+			return inHeadIM(p)
+		}
+		p.unexpected()
+	case lexer.TokenEOF:
+		if !p.isOnStack("template") {
+			return true
+		}
+		p.unexpected()
+		p.popUntil("template")
+		p.clearFormattingToMarker()
+		p.popTemplateMode()
+		p.resetInsertionMode()
+		return false
+	}
+	return true
+}
+
+// switchTemplateMode replaces the current template insertion mode and
+// switches to it.
+func (p *Parser) switchTemplateMode(mode insertionMode) {
+	p.templateModes[len(p.templateModes)-1] = mode
+	p.mode = mode
+}
+
+func (p *Parser) popTemplateMode() {
+	p.templateModes = p.templateModes[:len(p.templateModes)-1]
+}
+
+func afterBodyIM(p *Parser) bool {
+	switch p.curr.Type {
+	case lexer.TokenText:
+		if isAllSpace(p.curr.Value) {
+			return inBodyIM(p)
+		}
+	case lexer.TokenComment:
+		p.insertComment(p.oe[0])
+		return true
+	case lexer.TokenStartTag, lexer.TokenSelfClosingTag:
+		if p.tokenTag() == "html" {
+			return inBodyIM(p)
+		}
+	case lexer.TokenEndTag:
+		if p.tokenTag() == "html" {
+			p.mode = afterAfterBodyMode
+			return true
+		}
+	case lexer.TokenEOF:
+		return true
+	}
+	p.unexpected()
+	p.mode = inBodyMode
+	return false
+}
+
+func inFramesetIM(p *Parser) bool {
+	switch p.curr.Type {
+	case lexer.TokenText:
+		p.insertFramesetSpace()
+	case lexer.TokenComment:
+		p.insertComment(p.currentNode())
+	case lexer.TokenStartTag, lexer.TokenSelfClosingTag:
+		switch p.tokenTag() {
+		case "html":
+			return inBodyIM(p)
+		case "frameset":
+			p.insertElement()
+		case "frame":
+			p.insertVoid()
+		case "noframes":
+			return inHeadIM(p)
+		default:
+			p.unexpected()
+		}
+	case lexer.TokenEndTag:
+		if p.tokenTag() != "frameset" || len(p.oe) < 2 {
+			p.unexpected()
+			return true
+		}
+		p.pop()
+		if !isOneOf(p.currentNode(), "frameset") {
+			p.mode = afterFramesetMode
+		}
+	case lexer.TokenEOF:
+		if len(p.oe) > 1 {
+			p.unexpected()
+		}
+	}
+	return true
+}
+
+func afterFramesetIM(p *Parser) bool {
+	switch p.curr.Type {
+	case lexer.TokenText:
+		p.insertFramesetSpace()
+	case lexer.TokenComment:
+		p.insertComment(p.currentNode())
+	case lexer.TokenStartTag, lexer.TokenSelfClosingTag:
+		switch p.tokenTag() {
+		case "html":
+			return inBodyIM(p)
+		case "noframes":
+			return inHeadIM(p)
+		default:
+			p.unexpected()
+		}
+	case lexer.TokenEndTag:
+		if p.tokenTag() == "html" {
+			p.mode = afterAfterFramesetMode
+		} else {
+			p.unexpected()
+		}
+	}
+	return true
+}
+
+// insertFramesetSpace keeps only the whitespace of a text token, as frameset
+// documents cannot contain text.
+func (p *Parser) insertFramesetSpace() {
+	var sb strings.Builder
+	for i := 0; i < len(p.curr.Value); i++ {
+		if isHTMLSpace(p.curr.Value[i]) {
+			sb.WriteByte(p.curr.Value[i])
+		}
+	}
+	if sb.Len() < len(p.curr.Value) {
+		p.unexpected()
+	}
+	if sb.Len() > 0 {
+		p.insertText(sb.String(), p.curr.Span)
+	}
+}
+
+func afterAfterBodyIM(p *Parser) bool {
+	switch p.curr.Type {
+	case lexer.TokenText:
+		if isAllSpace(p.curr.Value) {
+			return inBodyIM(p)
+		}
+	case lexer.TokenComment:
+		p.insertComment(p.root)
+		return true
+	case lexer.TokenStartTag, lexer.TokenSelfClosingTag:
+		if p.tokenTag() == "html" {
+			return inBodyIM(p)
+		}
+	case lexer.TokenEOF:
+		return true
+	}
+	p.unexpected()
+	p.mode = inBodyMode
+	return false
+}
+
+func afterAfterFramesetIM(p *Parser) bool {
+	switch p.curr.Type {
+	case lexer.TokenText:
+		if isAllSpace(p.curr.Value) {
+			return inBodyIM(p)
+		}
+	case lexer.TokenComment:
+		p.insertComment(p.root)
+	case lexer.TokenStartTag, lexer.TokenSelfClosingTag:
+		switch p.tokenTag() {
+		case "html":
+			return inBodyIM(p)
+		case "noframes":
+			return inHeadIM(p)
+		}
+	}
+	if p.curr.Type != lexer.TokenEOF {
+		p.unexpected()
+	}
+	return true
+}
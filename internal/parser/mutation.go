@@ -0,0 +1,165 @@
+package parser
+
+// The mutation methods keep Children, Parent, PrevSibling and NextSibling
+// consistent. A node that is inserted while it still has a parent is moved,
+// as in the DOM. Misuse, such as inserting a node into its own subtree or
+// referencing a node that is not a child, panics.
+
+// AppendChild adds child as the last child of n.
+func (n *Node) AppendChild(child *Node) {
+	n.checkInsert(child)
+	child.Detach()
+	appendChild(n, child)
+}
+
+// PrependChild adds child as the first child of n.
+func (n *Node) PrependChild(child *Node) {
+	n.checkInsert(child)
+	child.Detach()
+	n.insertAt(child, 0)
+}
+
+// InsertBefore inserts child into n just before ref. A nil ref appends.
+func (n *Node) InsertBefore(child, ref *Node) {
+	if ref == nil {
+		n.AppendChild(child)
+		return
+	}
+	if child == ref {
+		return
+	}
+	n.checkInsert(child)
+	n.insertAt(child, n.detachBefore(child, n.childIndex(ref)))
+}
+
+// InsertAfter inserts child into n just after ref. A nil ref prepends.
+func (n *Node) InsertAfter(child, ref *Node) {
+	if ref == nil {
+		n.PrependChild(child)
+		return
+	}
+	if child == ref {
+		return
+	}
+	n.checkInsert(child)
+	n.insertAt(child, n.detachBefore(child, n.childIndex(ref)+1))
+}
+
+// RemoveChild removes child from n. The removed node keeps its own subtree.
+func (n *Node) RemoveChild(child *Node) {
+	i := n.childIndex(child)
+
+	if child.PrevSibling != nil {
+		child.PrevSibling.NextSibling = child.NextSibling
+	}
+	if child.NextSibling != nil {
+		child.NextSibling.PrevSibling = child.PrevSibling
+	}
+	n.Children = append(n.Children[:i:i], n.Children[i+1:]...)
+	child.Parent = nil
+	child.PrevSibling = nil
+	child.NextSibling = nil
+}
+
+// Detach removes n from its parent, if it has one.
+func (n *Node) Detach() {
+	if n.Parent != nil {
+		n.Parent.RemoveChild(n)
+	}
+}
+
+// ReplaceWith puts replacement where n is in the tree and detaches n.
+func (n *Node) ReplaceWith(replacement *Node) {
+	parent := n.Parent
+	if parent == nil {
+		panic("parser: ReplaceWith called on a node without a parent")
+	}
+	if replacement == n {
+		return
+	}
+	parent.InsertBefore(replacement, n)
+	parent.RemoveChild(n)
+}
+
+// Unwrap replaces n by its children and returns the detached n, now empty.
+func (n *Node) Unwrap() *Node {
+	parent := n.Parent
+	if parent == nil {
+		panic("parser: Unwrap called on a node without a parent")
+	}
+	for len(n.Children) > 0 {
+		parent.InsertBefore(n.Children[0], n)
+	}
+	parent.RemoveChild(n)
+	return n
+}
+
+// Wrap puts wrapper where n is in the tree and moves n inside it as its last
+// child.
+func (n *Node) Wrap(wrapper *Node) {
+	parent := n.Parent
+	if parent == nil {
+		panic("parser: Wrap called on a node without a parent")
+	}
+	if wrapper == n {
+		panic("parser: cannot wrap a node in itself")
+	}
+	wrapper.checkInsert(n)
+	parent.InsertBefore(wrapper, n)
+	wrapper.AppendChild(n)
+}
+
+// insertAt inserts a detached child at index i of n.Children.
+func (n *Node) insertAt(child *Node, i int) {
+	n.Children = append(n.Children, nil)
+	copy(n.Children[i+1:], n.Children[i:])
+	n.Children[i] = child
+
+	child.Parent = n
+	child.PrevSibling = nil
+	child.NextSibling = nil
+	if i > 0 {
+		child.PrevSibling = n.Children[i-1]
+		child.PrevSibling.NextSibling = child
+	}
+	if i+1 < len(n.Children) {
+		child.NextSibling = n.Children[i+1]
+		child.NextSibling.PrevSibling = child
+	}
+}
+
+// childIndex returns the index of child in n.Children.
+func (n *Node) childIndex(child *Node) int {
+	if child.Parent == n {
+		for i, c := range n.Children {
+			if c == child {
+				return i
+			}
+		}
+	}
+	panic("parser: node is not a child of the given parent")
+}
+
+// detachBefore detaches child and returns the index i of n.Children it was
+// to be inserted at, moved down if child was one of the children before it.
+// The index is looked up before detaching, so that a ref that is not a child
+// panics before the tree is changed.
+func (n *Node) detachBefore(child *Node, i int) int {
+	if child.Parent == n && n.childIndex(child) < i {
+		i--
+	}
+	child.Detach()
+	return i
+}
+
+// checkInsert panics if child cannot become a child of n.
+func (n *Node) checkInsert(child *Node) {
+	if child == nil {
+		panic("parser: cannot insert a nil node")
+	}
+	for a := n; a != nil; a = a.Parent {
+		if a == child {
+			panic("parser: cannot insert a node into its own subtree")
+		}
+	}
+}
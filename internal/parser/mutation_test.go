@@ -0,0 +1,207 @@
+package parser
+
+import (
+	"testing"
+)
+
+// checkLinks verifies that Parent, PrevSibling and NextSibling agree with
+// Children everywhere in the subtree.
+func checkLinks(t *testing.T, n *Node) {
+	t.Helper()
+	for i, child := range n.Children {
+		if child.Parent != n {
+			t.Fatalf("child %d of <%s> has the wrong parent", i, n.TagName)
+		}
+		var prev, next *Node
+		if i > 0 {
+			prev = n.Children[i-1]
+		}
+		if i+1 < len(n.Children) {
+			next = n.Children[i+1]
+		}
+		if child.PrevSibling != prev {
+			t.Fatalf("child %d of <%s> has the wrong previous sibling", i, n.TagName)
+		}
+		if child.NextSibling != next {
+			t.Fatalf("child %d of <%s> has the wrong next sibling", i, n.TagName)
+		}
+		checkLinks(t, child)
+	}
+	if n.TemplateContent != nil {
+		checkLinks(t, n.TemplateContent)
+	}
+}
+
+func checkDetached(t *testing.T, n *Node) {
+	t.Helper()
+	if n.Parent != nil || n.PrevSibling != nil || n.NextSibling != nil {
+		t.Fatalf("node <%s> is not detached", n.TagName)
+	}
+}
+
+func TestMutation(t *testing.T) {
+	el := func(tag string) *Node {
+		return &Node{Type: NodeElement, TagName: tag}
+	}
+
+	tests := []struct {
+		name     string
+		mutate   func(root *Node)
+		expected string
+	}{
+		{
+			name:     "AppendChild",
+			mutate:   func(root *Node) { root.FindByID("a").AppendChild(el("i")) },
+			expected: `<div id="a"><b>1</b><u>2</u><s>3</s><i></i></div><p id="p">x</p>`,
+		},
+		{
+			name:     "PrependChild",
+			mutate:   func(root *Node) { root.FindByID("a").PrependChild(el("i")) },
+			expected: `<div id="a"><i></i><b>1</b><u>2</u><s>3</s></div><p id="p">x</p>`,
+		},
+		{
+			name: "InsertBefore",
+			mutate: func(root *Node) {
+				div := root.FindByID("a")
+				div.InsertBefore(el("i"), div.Children[1])
+			},
+			expected: `<div id="a"><b>1</b><i></i><u>2</u><s>3</s></div><p id="p">x</p>`,
+		},
+		{
+			name: "InsertAfter",
+			mutate: func(root *Node) {
+				div := root.FindByID("a")
+				div.InsertAfter(el("i"), div.Children[2])
+			},
+			expected: `<div id="a"><b>1</b><u>2</u><s>3</s><i></i></div><p id="p">x</p>`,
+		},
+		{
+			name: "Move Within Parent",
+			mutate: func(root *Node) {
+				div := root.FindByID("a")
+				div.InsertBefore(div.Children[2], div.Children[0])
+			},
+			expected: `<div id="a"><s>3</s><b>1</b><u>2</u></div><p id="p">x</p>`,
+		},
+		{
+			name: "Move Forward Within Parent",
+			mutate: func(root *Node) {
+				div := root.FindByID("a")
+				div.InsertBefore(div.Children[0], div.Children[2])
+			},
+			expected: `<div id="a"><u>2</u><b>1</b><s>3</s></div><p id="p">x</p>`,
+		},
+		{
+			name: "Move After Later Sibling",
+			mutate: func(root *Node) {
+				div := root.FindByID("a")
+				div.InsertAfter(div.Children[0], div.Children[2])
+			},
+			expected: `<div id="a"><u>2</u><s>3</s><b>1</b></div><p id="p">x</p>`,
+		},
+		{
+			name: "Move Between Parents",
+			mutate: func(root *Node) {
+				root.FindByID("p").AppendChild(root.FindByTag("u")[0])
+			},
+			expected: `<div id="a"><b>1</b><s>3</s></div><p id="p">x<u>2</u></p>`,
+		},
+		{
+			name: "RemoveChild",
+			mutate: func(root *Node) {
+				div := root.FindByID("a")
+				u := div.Children[1]
+				div.RemoveChild(u)
+				checkDetached(t, u)
+			},
+			expected: `<div id="a"><b>1</b><s>3</s></div><p id="p">x</p>`,
+		},
+		{
+			name: "Detach",
+			mutate: func(root *Node) {
+				b := root.FindByTag("b")[0]
+				b.Detach()
+				checkDetached(t, b)
+				b.Detach() // No-op on a detached node
+			},
+			expected: `<div id="a"><u>2</u><s>3</s></div><p id="p">x</p>`,
+		},
+		{
+			name: "ReplaceWith",
+			mutate: func(root *Node) {
+				u := root.FindByTag("u")[0]
+				u.ReplaceWith(el("em"))
+				checkDetached(t, u)
+			},
+			expected: `<div id="a"><b>1</b><em></em><s>3</s></div><p id="p">x</p>`,
+		},
+		{
+			name:     "Unwrap",
+			mutate:   func(root *Node) { root.FindByID("a").Unwrap() },
+			expected: `<b>1</b><u>2</u><s>3</s><p id="p">x</p>`,
+		},
+		{
+			name:     "Wrap",
+			mutate:   func(root *Node) { root.FindByTag("u")[0].Wrap(el("span")) },
+			expected: `<div id="a"><b>1</b><span><u>2</u></span><s>3</s></div><p id="p">x</p>`,
+		},
+		{
+			name: "Wrap Moves Existing Wrapper",
+			mutate: func(root *Node) {
+				root.FindByTag("s")[0].Wrap(root.FindByID("p"))
+			},
+			expected: `<div id="a"><b>1</b><u>2</u><p id="p">x<s>3</s></p></div>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := New(`<div id="a"><b>1</b><u>2</u><s>3</s></div><p id="p">x</p>`).Parse()
+			tt.mutate(root.Node)
+			checkLinks(t, root.Node)
+			if got := root.FindByTag("body")[0].InnerHTML(); got != tt.expected {
+				t.Fatalf("Test '%s' - expected=%q, got=%q", tt.name, tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestMutationPanics(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(root *Node)
+	}{
+		{"Insert Ancestor", func(root *Node) { root.FindByTag("b")[0].AppendChild(root.FindByID("a")) }},
+		{"Insert Self", func(root *Node) { root.FindByID("a").AppendChild(root.FindByID("a")) }},
+		{"Remove Non-Child", func(root *Node) { root.FindByID("p").RemoveChild(root.FindByTag("b")[0]) }},
+		{"Insert Before Non-Child", func(root *Node) {
+			root.FindByID("p").InsertBefore(&Node{Type: NodeElement, TagName: "i"}, root.FindByTag("b")[0])
+		}},
+		{"Move Before Non-Child", func(root *Node) {
+			root.FindByID("p").InsertBefore(root.FindByTag("b")[0], root.FindByID("a"))
+		}},
+		{"Move After Non-Child", func(root *Node) {
+			root.FindByID("p").InsertAfter(root.FindByTag("b")[0], root.FindByID("a"))
+		}},
+		{"Unwrap Root", func(root *Node) { root.Unwrap() }},
+		{"Wrap In Descendant", func(root *Node) { root.FindByID("a").Wrap(root.FindByTag("b")[0]) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := New(`<div id="a"><b>1</b></div><p id="p">x</p>`).Parse()
+			before := root.OuterHTML()
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("Test '%s' - expected a panic", tt.name)
+				}
+				checkLinks(t, root.Node)
+				// A failed call leaves the tree as it was
+				if got := root.OuterHTML(); got != before {
+					t.Fatalf("Test '%s' - tree changed. expected=%q, got=%q", tt.name, before, got)
+				}
+			}()
+			tt.mutate(root.Node)
+		})
+	}
+}
@@ -1,22 +1,51 @@
 package parser
 
+import (
+	"strings"
+
+	"github.com/rsolovyeaws/go-html-parser/internal/lexer"
+)
+
 type NodeType string
 
 const (
-	NodeElement NodeType = "Element"
-	NodeText    NodeType = "Text"
-	NodeComment NodeType = "Comment"
+	NodeDocument NodeType = "Document"
+	NodeElement  NodeType = "Element"
+	NodeText     NodeType = "Text"
+	NodeComment  NodeType = "Comment"
+	NodeDoctype  NodeType = "Doctype"
+
+	// NodeDocumentFragment nodes hold the contents of template elements, see
+	// Node.TemplateContent.
+	NodeDocumentFragment NodeType = "DocumentFragment"
+
+	// NodeAttribute nodes are only produced by XPath queries such as "//a/@href".
+	// TagName holds the attribute name, Content its value and Parent the owner
+	// element; they never appear in Children.
+	NodeAttribute NodeType = "Attribute"
 )
 
+// Attribute is an attribute of an element, see lexer.Attribute.
+type Attribute = lexer.Attribute
+
 type Node struct {
-	Type        NodeType          // Element, Text, Comment
-	TagName     string            // Only for Element nodes
-	Attributes  map[string]string // Only for Element nodes
-	Content     string            // Only for Text and Comment nodes
-	Children    []*Node           // Child nodes
-	Parent      *Node             // Pointer to parent node
-	PrevSibling *Node             // Previous sibling
-	NextSibling *Node             // Next sibling
+	Type        NodeType    // Document, Doctype, Element, Text, Comment
+	TagName     string      // Only for Element nodes
+	Namespace   string      // Only for Element nodes: NamespaceSVG, NamespaceMathML or empty for HTML
+	Attributes  []Attribute // Only for Element nodes, in source order
+	Content     string      // Text and Comment nodes, or the Doctype name
+	PublicID    string      // Only for Doctype nodes
+	SystemID    string      // Only for Doctype nodes
+	Children    []*Node     // Child nodes
+	Parent      *Node       // Pointer to parent node
+	PrevSibling *Node       // Previous sibling
+	NextSibling *Node       // Next sibling
+	Span        lexer.Span  // Source location, from the start tag to the end tag for elements
+
+	// TemplateContent holds the children of a template element. They are
+	// inert, so they are kept out of Children and skipped by traversal and
+	// queries, but Render writes them as the contents of the template.
+	TemplateContent *Node
 }
 
 func (n *Node) ParentNode() *Node {
@@ -44,3 +73,85 @@ func (n *Node) LastChildNode() *Node {
 	}
 	return nil
 }
+
+// NamespaceURI returns the namespace of an element, which is NamespaceHTML
+// unless it is an SVG or MathML element.
+func (n *Node) NamespaceURI() string {
+	if n.Namespace == "" {
+		return NamespaceHTML
+	}
+	return n.Namespace
+}
+
+// Clone returns a detached copy of n with its own attribute list. A deep clone
+// also copies all descendants, linked to each other but not to the original.
+func (n *Node) Clone(deep bool) *Node {
+	clone := &Node{
+		Type:      n.Type,
+		TagName:   n.TagName,
+		Namespace: n.Namespace,
+		Content:   n.Content,
+		PublicID:  n.PublicID,
+		SystemID:  n.SystemID,
+		Children:  []*Node{},
+		Span:      n.Span,
+	}
+	if n.Attributes != nil {
+		clone.Attributes = append([]Attribute{}, n.Attributes...)
+	}
+	if deep {
+		for _, child := range n.Children {
+			appendChild(clone, child.Clone(true))
+		}
+	}
+	if n.TemplateContent != nil {
+		clone.TemplateContent = &Node{Type: NodeDocumentFragment, Children: []*Node{}}
+		if deep {
+			clone.TemplateContent = n.TemplateContent.Clone(true)
+		}
+	}
+	return clone
+}
+
+// Attr returns the value of the attribute named name and whether n has it.
+// Names are matched case-insensitively.
+func (n *Node) Attr(name string) (string, bool) {
+	if i := n.attrIndex(name); i >= 0 {
+		return n.Attributes[i].Value, true
+	}
+	return "", false
+}
+
+// HasAttr reports whether n has an attribute named name.
+func (n *Node) HasAttr(name string) bool {
+	return n.attrIndex(name) >= 0
+}
+
+// SetAttr sets the value of the attribute named name, adding it after the
+// existing attributes if n does not have it yet.
+func (n *Node) SetAttr(name, value string) {
+	if i := n.attrIndex(name); i >= 0 {
+		n.Attributes[i].Value = value
+		return
+	}
+	n.Attributes = append(n.Attributes, Attribute{Name: name, Value: value})
+}
+
+// RemoveAttr removes the attribute named name and reports whether n had it.
+func (n *Node) RemoveAttr(name string) bool {
+	i := n.attrIndex(name)
+	if i < 0 {
+		return false
+	}
+	n.Attributes = append(n.Attributes[:i], n.Attributes[i+1:]...)
+	return true
+}
+
+func (n *Node) attrIndex(name string) int {
+	for i, a := range n.Attributes {
+		if strings.EqualFold(a.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,67 @@
+package parser
+
+import "testing"
+
+func TestClone(t *testing.T) {
+	root := New(`<div id="a" class="x"><p>one <b>two</b></p><!-- c --></div><span>s</span>`).Parse()
+	div := root.FindByID("a")
+	original := root.OuterHTML()
+
+	t.Run("Shallow", func(t *testing.T) {
+		clone := div.Clone(false)
+		checkDetached(t, clone)
+		if got, expected := clone.OuterHTML(), `<div id="a" class="x"></div>`; got != expected {
+			t.Fatalf("expected=%q, got=%q", expected, got)
+		}
+		clone.SetAttr("id", "b")
+		if id, _ := div.Attr("id"); id != "a" {
+			t.Fatalf("clone shares the attribute list with the original")
+		}
+	})
+
+	t.Run("Deep", func(t *testing.T) {
+		clone := div.Clone(true)
+		checkDetached(t, clone)
+		checkLinks(t, clone)
+		if got := clone.OuterHTML(); got != div.OuterHTML() {
+			t.Fatalf("expected=%q, got=%q", div.OuterHTML(), got)
+		}
+		if clone.Children[0] == div.Children[0] || clone.Children[0].Span != div.Children[0].Span {
+			t.Fatalf("deep clone should copy nodes and keep their spans")
+		}
+
+		// Modifying the copy leaves the original document intact
+		clone.FindByTag("b")[0].Unwrap()
+		clone.Children[0].SetAttr("lang", "en")
+		clone.AppendChild(&Node{Type: NodeText, Content: "new"})
+		if root.OuterHTML() != original {
+			t.Fatalf("original changed: %q", root.OuterHTML())
+		}
+
+		// And it can be inserted elsewhere
+		root.FindByTag("span")[0].AppendChild(clone)
+		checkLinks(t, root.Node)
+		if got, expected := root.FindByTag("span")[0].InnerHTML(), `s<div id="a" class="x"><p lang="en">one two</p><!--c-->new</div>`; got != expected {
+			t.Fatalf("expected=%q, got=%q", expected, got)
+		}
+	})
+}
+
+func TestAttributes(t *testing.T) {
+	n := New(`<input type="text" Name=q disabled>`).Parse().FindByTag("input")[0]
+
+	if value, ok := n.Attr("name"); !ok || value != "q" {
+		t.Fatalf("Attr wrong. expected=%q, got=%q (%v)", "q", value, ok)
+	}
+	if !n.HasAttr("DISABLED") || n.HasAttr("value") {
+		t.Fatalf("HasAttr wrong")
+	}
+	n.SetAttr("type", "search")
+	n.SetAttr("value", "x")
+	if !n.RemoveAttr("disabled") || n.RemoveAttr("disabled") {
+		t.Fatalf("RemoveAttr should remove the attribute once")
+	}
+	if got, expected := n.OuterHTML(), `<input type="search" name="q" value="x">`; got != expected {
+		t.Fatalf("expected=%q, got=%q", expected, got)
+	}
+}
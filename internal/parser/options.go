@@ -0,0 +1,83 @@
+package parser
+
+import "github.com/rsolovyeaws/go-html-parser/internal/lexer"
+
+// Options configures a Parser. The zero value gives the behaviour of New.
+// ParseEvents honours the options that concern single tokens: DropComments,
+// LowerCaseNames and KeepEntities.
+type Options struct {
+	// ReportErrors collects the recoverable errors in the input, see Errors.
+	ReportErrors bool
+
+	// DropWhitespace leaves out text nodes that contain only whitespace,
+	// such as the indentation between tags. Whitespace inside pre, listing,
+	// textarea and raw-text elements is kept.
+	DropWhitespace bool
+
+	// DropComments leaves out comments.
+	DropComments bool
+
+	// MergeText joins text nodes that end up next to each other once
+	// comments are dropped. Without it "a<!--x-->b" keeps two text nodes,
+	// each with the span of its own source text. The parser already joins
+	// text that is adjacent in the tree it builds, so MergeText only changes
+	// the result together with DropComments.
+	MergeText bool
+
+	// LowerCaseNames keeps the names of SVG and MathML elements and
+	// attributes lower-cased, such as "clippath" and "viewbox", instead of
+	// giving them back the case the specification defines. The names of HTML
+	// elements and attributes are always lower-cased, as HTML ignores their
+	// case.
+	LowerCaseNames bool
+
+	// KeepEntities leaves character references such as "&amp;" undecoded in
+	// text and attribute values. Such text is rendered as is, so it is
+	// escaped a second time by Render.
+	KeepEntities bool
+}
+
+// NewWithOptions creates a Parser for input configured by opts.
+func NewWithOptions(input string, opts Options) *Parser {
+	return newParser(lexer.New(input), opts)
+}
+
+// ParseWithOptions parses input like New(input).Parse and also returns the
+// parse errors when opts.ReportErrors is set.
+func ParseWithOptions(input string, opts Options) (*Document, []ParseError) {
+	p := NewWithOptions(input, opts)
+	doc := p.Parse()
+	return doc, p.Errors()
+}
+
+// applyOptions removes the nodes the options drop from the finished tree and
+// merges the text nodes that become adjacent. keepSpace is set inside
+// elements whose whitespace is significant.
+func (p *Parser) applyOptions(n *Node, keepSpace bool) {
+	if !p.opts.DropWhitespace && !p.opts.DropComments && !p.opts.MergeText {
+		return
+	}
+	keepSpace = keepSpace || isOneOf(n, "pre", "listing", "textarea") || isRawTextElement(nameOf(n))
+
+	for i := 0; i < len(n.Children); {
+		child := n.Children[i]
+		switch {
+		case child.Type == NodeComment && p.opts.DropComments:
+			n.RemoveChild(child)
+			continue
+		case child.Type == NodeText && p.opts.MergeText && child.PrevSibling != nil && child.PrevSibling.Type == NodeText:
+			child.PrevSibling.Content += child.Content
+			child.PrevSibling.Span.End = child.Span.End
+			n.RemoveChild(child)
+			continue
+		case child.Type == NodeText && p.opts.DropWhitespace && !keepSpace && isAllSpace(child.Content):
+			n.RemoveChild(child)
+			continue
+		}
+		p.applyOptions(child, keepSpace)
+		i++
+	}
+	if n.TemplateContent != nil {
+		p.applyOptions(n.TemplateContent, keepSpace)
+	}
+}
@@ -0,0 +1,104 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParserOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		input    string
+		expected string // InnerHTML of the body
+	}{
+		{
+			name:     "Defaults",
+			input:    "<ul>\n  <li>a<!-- x -->b</li>\n</ul>",
+			expected: "<ul>\n  <li>a<!--x-->b</li>\n</ul>",
+		},
+		{
+			name:     "Drop Whitespace",
+			opts:     Options{DropWhitespace: true},
+			input:    "<ul>\n  <li> a </li>\n</ul>\n<pre>\n\n  </pre><textarea> </textarea>",
+			expected: "<ul><li> a </li></ul><pre>\n\n  </pre><textarea> </textarea>",
+		},
+		{
+			name:     "Drop Comments",
+			opts:     Options{DropComments: true},
+			input:    "<p>a<!-- x -->b</p><!-- y -->",
+			expected: "<p>ab</p>",
+		},
+		{
+			name:     "Foreign Names",
+			input:    `<DIV ID=a><SVG ViewBox="0 0 1 1"><clipPath xlink:href="#x"/><foreignObject><p>t</foreignObject></SVG></DIV>`,
+			expected: `<div id="a"><svg viewBox="0 0 1 1"><clipPath xlink:href="#x"></clipPath><foreignObject><p>t</p></foreignObject></svg></div>`,
+		},
+		{
+			name:     "Lower-Case Names",
+			opts:     Options{LowerCaseNames: true},
+			input:    `<DIV ID=a><SVG ViewBox="0 0 1 1"><clipPath xlink:href="#x"/><foreignObject><p>t</foreignObject></SVG></DIV>`,
+			expected: `<div id="a"><svg viewbox="0 0 1 1"><clippath xlink:href="#x"></clippath><foreignobject><p>t</p></foreignobject></svg></div>`,
+		},
+		{
+			name:     "Keep Entities",
+			opts:     Options{KeepEntities: true},
+			input:    `<a href="?a=1&amp;b=2">&lt;x&gt;</a>`,
+			expected: `<a href="?a=1&amp;amp;b=2">&amp;lt;x&amp;gt;</a>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := NewWithOptions(tt.input, tt.opts).Parse()
+			checkLinks(t, doc.Node)
+			if got := doc.Body().InnerHTML(); got != tt.expected {
+				t.Fatalf("Test '%s' - expected=%q, got=%q", tt.name, tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestParserOptionsMergeText(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		expected []string // Text nodes of the p element
+	}{
+		{"Separate", Options{DropComments: true}, []string{"a", "b", "c"}},
+		{"Merged", Options{DropComments: true, MergeText: true}, []string{"abc"}},
+		{"Comments Kept", Options{MergeText: true}, []string{"a", "x", "b", "y", "c"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := NewWithOptions("<p>a<!-- x -->b<!-- y -->c</p>", tt.opts).Parse()
+			var got []string
+			for _, child := range doc.FindByTag("p")[0].Children {
+				got = append(got, child.Content)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.expected, "|") {
+				t.Fatalf("Test '%s' - expected=%q, got=%q", tt.name, tt.expected, got)
+			}
+		})
+	}
+
+	doc := NewWithOptions("<p>a<!-- x -->b</p>", Options{DropComments: true, MergeText: true}).Parse()
+	text := doc.FindByTag("p")[0].Children[0]
+	if text.Span.Start.Column != 4 || text.Span.End.Column != 16 {
+		t.Fatalf("merged span wrong. got=%v-%v", text.Span.Start, text.Span.End)
+	}
+}
+
+func TestParseEventsOptions(t *testing.T) {
+	r := &recorder{}
+	p := NewWithOptions(`<DIV Title="&amp;">a<!-- c --><svg viewBox=v><clipPath/></svg></div>`,
+		Options{DropComments: true, LowerCaseNames: true, KeepEntities: true})
+	if err := p.ParseEvents(r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := `<div title=&amp;> "a" <svg viewbox=v> <clippath> </clippath> </svg> </div>`
+	if got := strings.Join(r.events, " "); got != expected {
+		t.Fatalf("expected=%q, got=%q", expected, got)
+	}
+}
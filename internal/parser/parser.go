@@ -1,150 +1,182 @@
 package parser
 
 import (
-	"fmt"
+	"io"
 	"strings"
 
 	"github.com/rsolovyeaws/go-html-parser/internal/lexer"
 )
 
 type Parser struct {
-	lexer *lexer.Lexer
-	curr  lexer.Token
+	lexer  *lexer.Lexer
+	curr   lexer.Token
+	opts   Options
+	errors []ParseError
+
+	// Tree construction state, see treebuilder.go
+	root            *Node
+	mode            insertionMode
+	originalMode    insertionMode   // Mode to return to after the text mode
+	oe              []*Node         // Stack of open elements
+	openP           int             // Number of p elements in oe
+	afe             []*Node         // Active formatting elements, nil entries are markers
+	head            *Node           // The head element, once created
+	form            *Node           // The open form element
+	framesetOK      bool            // Whether a <frameset> may still replace the body
+	fosterParenting bool            // Insert misplaced table content before the table
+	skipNewline     bool            // Drop a newline at the start of the next text token
+	quirks          QuirksMode      // Set from the doctype in the initial mode
+	context         *Node           // Context element when parsing a fragment
+	templateModes   []insertionMode // Stack of template insertion modes
 }
 
 // New creates a new Parser instance
 func New(input string) *Parser {
-	l := lexer.New(input)
-	return &Parser{lexer: l, curr: l.NextToken()}
+	return newParser(lexer.New(input), Options{})
+}
+
+// NewReader creates a Parser that tokenizes its input incrementally from r
+func NewReader(r io.Reader) *Parser {
+	return newParser(lexer.NewReader(r), Options{})
+}
+
+func newParser(l *lexer.Lexer, opts Options) *Parser {
+	p := &Parser{lexer: l, opts: opts}
+	l.SetKeepEntities(opts.KeepEntities)
+	if opts.ReportErrors {
+		l.SetErrorHandler(func(e lexer.Error) {
+			p.errors = append(p.errors, ParseError{Code: e.Code, Pos: e.Pos})
+		})
+	}
+	p.curr = l.NextToken()
+	return p
+}
+
+// ParseReader parses HTML streamed from r without reading it into memory
+// first. If reading fails, the document built from the input read so far is
+// returned together with the error.
+func ParseReader(r io.Reader) (*Document, error) {
+	p := NewReader(r)
+	doc := p.Parse()
+	return doc, p.lexer.Err()
 }
 
 func (p *Parser) nextToken() {
 	p.curr = p.lexer.NextToken()
 }
 
-// Parse processes the input and returns the root Node of the parsed tree
-func (p *Parser) Parse() *Node {
-	root := &Node{
-		Type:     NodeElement,
-		TagName:  "root",
+// Parse processes the input and returns the parsed document.
+// The tree is built with the HTML5 tree construction rules, so it has the
+// same shape a browser would give the document: html, head and body elements
+// are implied, misnested formatting elements are repaired and stray end tags
+// are ignored.
+func (p *Parser) Parse() *Document {
+	p.root = &Node{
+		Type:     NodeDocument,
 		Children: []*Node{},
+		Span:     lexer.Span{Start: p.curr.Span.Start},
 	}
+	p.mode = initialMode
+	p.framesetOK = true
+	p.quirks = NoQuirks
 
-	stack := []*Node{root} // Stack to track open elements
+	p.run()
+	p.root.Span.End = p.curr.Span.Start
+	p.applyOptions(p.root, false)
 
-	for p.curr.Type != lexer.TokenEOF {
-		switch p.curr.Type {
-		case lexer.TokenStartTag:
-			node := p.parseElement()
-			// Implicitly close open tags based on HTML rules
-			for len(stack) > 1 && isImplicitClose(stack[len(stack)-1].TagName, node.TagName) {
-				stack = stack[:len(stack)-1] // Pop the stack
-			}
-			// Add the node to the current parent
-			appendChild(stack[len(stack)-1], node)
-			// Push non-void elements onto the stack
-			if !isVoidElement(node.TagName) {
-				stack = append(stack, node)
-			}
-
-		case lexer.TokenSelfClosingTag:
-			node := p.parseElement()
-			appendChild(stack[len(stack)-1], node)
-
-		case lexer.TokenEndTag:
-			// Pop the stack for matching end tags
-			for len(stack) > 1 {
-				if stack[len(stack)-1].TagName == p.curr.Value {
-					stack = stack[:len(stack)-1]
-					break
-				}
-				stack = stack[:len(stack)-1] // Implicitly close unclosed tags
-			}
+	return &Document{Node: p.root, QuirksMode: p.quirks}
+}
 
-		case lexer.TokenText:
-			content := DecodeEntities(p.curr.Value)
-			if content != "" {
-				textNode := &Node{
-					Type:    NodeText,
-					Content: content,
-				}
-				appendChild(stack[len(stack)-1], textNode)
-			}
+// run feeds tokens to the insertion modes until the end of input and then
+// closes the elements that are still open.
+func (p *Parser) run() {
+	for {
+		if p.curr.Type == lexer.TokenText && !p.prepareText() {
+			p.nextToken()
+			continue
+		}
+		p.skipNewline = false
+
+		// A doctype is only meaningful before anything else; every later
+		// insertion mode ignores it.
+		if p.curr.Type == lexer.TokenDoctype && p.mode != initialMode {
+			p.parseError("unexpected-doctype")
+			p.nextToken()
+			continue
+		}
+		if p.curr.Type == lexer.TokenSelfClosingTag && !isVoidElement(p.tokenTag()) &&
+			p.tokenTag() != "svg" && p.tokenTag() != "math" && !p.inForeignContent() {
+			p.parseError("non-void-html-element-start-tag-with-trailing-solidus")
+		}
 
-		case lexer.TokenComment:
-			commentNode := &Node{
-				Type:    NodeComment,
-				Content: p.curr.Value,
-			}
-			appendChild(stack[len(stack)-1], commentNode)
+		// The lexer reads the contents of a <style> or <script> as raw text,
+		// but only an element the tree builder inserts has such contents:
+		// "<select><style>" ignores the tag, and an SVG <style> holds markup
+		if p.curr.Type == lexer.TokenStartTag || p.curr.Type == lexer.TokenSelfClosingTag {
+			p.lexer.SetRawTextElement("")
+		}
+		for !p.process() {
 		}
 
-		// Move to the next token
+		if p.curr.Type == lexer.TokenEOF {
+			break
+		}
+		// CDATA sections only exist in foreign content
+		p.lexer.SetAllowCDATA(p.adjustedCurrentNode().Namespace != "")
 		p.nextToken()
 	}
 
-	// Close any remaining unclosed tags
-	for len(stack) > 1 {
-		stack = stack[:len(stack)-1]
+	// Close any remaining unclosed elements
+	for len(p.oe) > 0 {
+		p.pop()
 	}
-
-	// debugNode(root, "") // Debugging output for tree structure
-	return root
 }
 
-// parseElement creates a Node from the current token
-func (p *Parser) parseElement() *Node {
-	decodedAttributes := make(map[string]string)
-	for key, value := range p.curr.Attributes {
-		decodedAttributes[key] = DecodeEntities(value) // Decode entities in attributes
-	}
-	return &Node{
-		Type:       NodeElement,
-		TagName:    p.curr.Value,
-		Attributes: decodedAttributes,
-		Children:   []*Node{},
+// process handles the current token with the rules for foreign content or
+// those of the insertion mode. Like the mode handlers it returns false if
+// the token must be reprocessed.
+func (p *Parser) process() bool {
+	if p.inForeignContent() {
+		return foreignContentIM(p)
 	}
+	return modeHandlers[p.mode](p)
 }
 
-// debugNode prints the Node structure for debugging purposes
-func debugNode(node *Node, indent string) {
-	fmt.Printf("%sNode: Type=%s, TagName=%s, Attributes=%v, Content=%s\n",
-		indent, node.Type, node.TagName, node.Attributes, node.Content)
-	for _, child := range node.Children {
-		debugNode(child, indent+"  ")
+// prepareText drops the newline that may follow <pre>, <listing> and
+// <textarea> from the current text token. The lexer has already turned a
+// "\r\n" there into "\n". It reports whether any text is left.
+func (p *Parser) prepareText() bool {
+	if p.skipNewline && strings.HasPrefix(p.curr.Value, "\n") {
+		p.curr.Value = p.curr.Value[1:]
+		p.curr.Span.Start = advancePos(p.curr.Span.Start, "\n")
 	}
+	return p.curr.Value != ""
 }
 
-// isImplicitClose checks if a tag should be implicitly closed
-func isImplicitClose(current, next string) bool {
-	// Implicit closing rules for certain HTML elements
-	implicitCloseRules := map[string][]string{
-		"li":     {"li"},
-		"p":      {"p", "div", "ul", "ol"},
-		"dt":     {"dt", "dd"},
-		"dd":     {"dt", "dd"},
-		"thead":  {"tbody", "tfoot"},
-		"tbody":  {"tbody", "tfoot"},
-		"tfoot":  {"tbody"},
-		"tr":     {"tr"},
-		"td":     {"td", "th"},
-		"th":     {"td", "th"},
-		"option": {"option", "optgroup"},
+// parseElement creates a Node in the given namespace from the current token.
+// SVG and MathML names get their case back, and a template gets an empty
+// fragment for its contents.
+func (p *Parser) parseElement(namespace string) *Node {
+	n := &Node{
+		Type:       NodeElement,
+		TagName:    p.curr.Value,
+		Namespace:  namespace,
+		Attributes: append([]Attribute(nil), p.curr.Attributes...),
+		Children:   []*Node{},
+		Span:       p.curr.Span,
 	}
-
-	if nextTags, ok := implicitCloseRules[current]; ok {
-		for _, tag := range nextTags {
-			if next == tag {
-				return true
-			}
-		}
+	adjustForeignNames(n, p.opts.LowerCaseNames)
+	if isOneOf(n, "template") {
+		n.TemplateContent = &Node{Type: NodeDocumentFragment, Children: []*Node{}}
 	}
-	return false
+	return n
 }
 
+// FindByTag returns the elements named tag in n's subtree, including n.
+// Names are matched case-insensitively.
 func (n *Node) FindByTag(tag string) []*Node {
 	var result []*Node
-	if n.TagName == tag {
+	if n.Type == NodeElement && strings.EqualFold(n.TagName, tag) {
 		result = append(result, n)
 	}
 	for _, child := range n.Children {
@@ -154,7 +186,7 @@ func (n *Node) FindByTag(tag string) []*Node {
 }
 
 func (n *Node) FindByID(id string) *Node {
-	if val, ok := n.Attributes["id"]; ok && val == id {
+	if val, ok := n.Attr("id"); ok && val == id {
 		return n
 	}
 	for _, child := range n.Children {
@@ -167,7 +199,7 @@ func (n *Node) FindByID(id string) *Node {
 
 func (n *Node) FindByClass(class string) []*Node {
 	var result []*Node
-	if val, ok := n.Attributes["class"]; ok {
+	if val, ok := n.Attr("class"); ok {
 		classes := strings.Fields(val)
 		for _, c := range classes {
 			if c == class {
@@ -1,8 +1,13 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 func TestParser(t *testing.T) {
@@ -14,9 +19,8 @@ func TestParser(t *testing.T) {
 		{
 			name:  "Simple HTML",
 			input: `<div>Hello</div>`,
-			expectedRoot: &Node{
-				Type:    NodeElement,
-				TagName: "root",
+			expectedRoot: inBody(&Node{
+				Type: NodeDocument,
 				Children: []*Node{
 					{
 						Type:    NodeElement,
@@ -29,14 +33,13 @@ func TestParser(t *testing.T) {
 						},
 					},
 				},
-			},
+			}),
 		},
 		{
 			name:  "Nested Tags",
 			input: `<div><p>Nested</p></div>`,
-			expectedRoot: &Node{
-				Type:    NodeElement,
-				TagName: "root",
+			expectedRoot: inBody(&Node{
+				Type: NodeDocument,
 				Children: []*Node{
 					{
 						Type:    NodeElement,
@@ -55,14 +58,13 @@ func TestParser(t *testing.T) {
 						},
 					},
 				},
-			},
+			}),
 		},
 		{
 			name:  "Comments",
 			input: `<div><!-- A comment --></div>`,
-			expectedRoot: &Node{
-				Type:    NodeElement,
-				TagName: "root",
+			expectedRoot: inBody(&Node{
+				Type: NodeDocument,
 				Children: []*Node{
 					{
 						Type:    NodeElement,
@@ -75,29 +77,27 @@ func TestParser(t *testing.T) {
 						},
 					},
 				},
-			},
+			}),
 		},
 		{
 			name:  "Attributes and Self-Closing Tags",
 			input: `<img src="image.jpg" alt="An image" />`,
-			expectedRoot: &Node{
-				Type:    NodeElement,
-				TagName: "root",
+			expectedRoot: inBody(&Node{
+				Type: NodeDocument,
 				Children: []*Node{
 					{
 						Type:       NodeElement,
 						TagName:    "img",
-						Attributes: map[string]string{"src": "image.jpg", "alt": "An image"},
+						Attributes: []Attribute{{Name: "src", Value: "image.jpg"}, {Name: "alt", Value: "An image"}},
 					},
 				},
-			},
+			}),
 		},
 		{
 			name:  "Malformed HTML",
 			input: `<div><p>Unclosed Div`,
-			expectedRoot: &Node{
-				Type:    NodeElement,
-				TagName: "root",
+			expectedRoot: inBody(&Node{
+				Type: NodeDocument,
 				Children: []*Node{
 					{
 						Type:    NodeElement,
@@ -116,14 +116,13 @@ func TestParser(t *testing.T) {
 						},
 					},
 				},
-			},
+			}),
 		},
 		{
 			name:  "Decode Entities in Text",
 			input: `<p>Tom &amp; Jerry</p>`,
-			expectedRoot: &Node{
-				Type:    NodeElement,
-				TagName: "root",
+			expectedRoot: inBody(&Node{
+				Type: NodeDocument,
 				Children: []*Node{
 					{
 						Type:    NodeElement,
@@ -136,22 +135,21 @@ func TestParser(t *testing.T) {
 						},
 					},
 				},
-			},
+			}),
 		},
 		{
 			name:  "Decode Entities in Attributes",
 			input: `<img src="image.jpg" alt="Tom &amp; Jerry" />`,
-			expectedRoot: &Node{
-				Type:    NodeElement,
-				TagName: "root",
+			expectedRoot: inBody(&Node{
+				Type: NodeDocument,
 				Children: []*Node{
 					{
 						Type:       NodeElement,
 						TagName:    "img",
-						Attributes: map[string]string{"src": "image.jpg", "alt": "Tom & Jerry"},
+						Attributes: []Attribute{{Name: "src", Value: "image.jpg"}, {Name: "alt", Value: "Tom & Jerry"}},
 					},
 				},
-			},
+			}),
 		},
 	}
 
@@ -160,7 +158,7 @@ func TestParser(t *testing.T) {
 			p := New(tt.input)
 			root := p.Parse()
 
-			if !compareNodes(root, tt.expectedRoot) {
+			if !compareNodes(root.Node, tt.expectedRoot) {
 				t.Fatalf("Test '%s' failed: Expected %v, got %v", tt.name, tt.expectedRoot, root)
 			}
 		})
@@ -176,39 +174,36 @@ func TestParserExpanded(t *testing.T) {
 		{
 			name:  "Attributes Without Values",
 			input: `<input type="checkbox" checked>`,
-			expectedRoot: &Node{
-				Type:    NodeElement,
-				TagName: "root",
+			expectedRoot: inBody(&Node{
+				Type: NodeDocument,
 				Children: []*Node{
 					{
 						Type:       NodeElement,
 						TagName:    "input",
-						Attributes: map[string]string{"type": "checkbox", "checked": ""},
+						Attributes: []Attribute{{Name: "type", Value: "checkbox"}, {Name: "checked"}},
 					},
 				},
-			},
+			}),
 		},
 		{
 			name:  "Mixed Attribute Quoting",
 			input: `<tag key1="value1" key2='value2'>`,
-			expectedRoot: &Node{
-				Type:    NodeElement,
-				TagName: "root",
+			expectedRoot: inBody(&Node{
+				Type: NodeDocument,
 				Children: []*Node{
 					{
 						Type:       NodeElement,
 						TagName:    "tag",
-						Attributes: map[string]string{"key1": "value1", "key2": "value2"},
+						Attributes: []Attribute{{Name: "key1", Value: "value1"}, {Name: "key2", Value: "value2"}},
 					},
 				},
-			},
+			}),
 		},
 		{
 			name:  "Nested Self-Closing Tags",
 			input: `<div><img src="logo.png" /><br /></div>`,
-			expectedRoot: &Node{
-				Type:    NodeElement,
-				TagName: "root",
+			expectedRoot: inBody(&Node{
+				Type: NodeDocument,
 				Children: []*Node{
 					{
 						Type:    NodeElement,
@@ -217,7 +212,7 @@ func TestParserExpanded(t *testing.T) {
 							{
 								Type:       NodeElement,
 								TagName:    "img",
-								Attributes: map[string]string{"src": "logo.png"},
+								Attributes: []Attribute{{Name: "src", Value: "logo.png"}},
 							},
 							{
 								Type:    NodeElement,
@@ -226,14 +221,13 @@ func TestParserExpanded(t *testing.T) {
 						},
 					},
 				},
-			},
+			}),
 		},
 		{
 			name:  "Mixed Content",
 			input: `<div>Hello <span>world</span></div>`,
-			expectedRoot: &Node{
-				Type:    NodeElement,
-				TagName: "root",
+			expectedRoot: inBody(&Node{
+				Type: NodeDocument,
 				Children: []*Node{
 					{
 						Type:    NodeElement,
@@ -256,14 +250,13 @@ func TestParserExpanded(t *testing.T) {
 						},
 					},
 				},
-			},
+			}),
 		},
 		{
 			name:  "Malformed HTML",
 			input: `<div><span>Missing End Tags`,
-			expectedRoot: &Node{
-				Type:    NodeElement,
-				TagName: "root",
+			expectedRoot: inBody(&Node{
+				Type: NodeDocument,
 				Children: []*Node{
 					{
 						Type:    NodeElement,
@@ -282,14 +275,13 @@ func TestParserExpanded(t *testing.T) {
 						},
 					},
 				},
-			},
+			}),
 		},
 		{
 			name:  "Void Elements",
 			input: `<div><input type="text"><br></div>`,
-			expectedRoot: &Node{
-				Type:    NodeElement,
-				TagName: "root",
+			expectedRoot: inBody(&Node{
+				Type: NodeDocument,
 				Children: []*Node{
 					{
 						Type:    NodeElement,
@@ -298,7 +290,7 @@ func TestParserExpanded(t *testing.T) {
 							{
 								Type:       NodeElement,
 								TagName:    "input",
-								Attributes: map[string]string{"type": "text"},
+								Attributes: []Attribute{{Name: "type", Value: "text"}},
 							},
 							{
 								Type:    NodeElement,
@@ -307,14 +299,13 @@ func TestParserExpanded(t *testing.T) {
 						},
 					},
 				},
-			},
+			}),
 		},
 		{
 			name:  "Deeply Nested Structure",
 			input: `<div><ul><li><a href="link">Item</a></li></ul></div>`,
-			expectedRoot: &Node{
-				Type:    NodeElement,
-				TagName: "root",
+			expectedRoot: inBody(&Node{
+				Type: NodeDocument,
 				Children: []*Node{
 					{
 						Type:    NodeElement,
@@ -331,7 +322,7 @@ func TestParserExpanded(t *testing.T) {
 											{
 												Type:       NodeElement,
 												TagName:    "a",
-												Attributes: map[string]string{"href": "link"},
+												Attributes: []Attribute{{Name: "href", Value: "link"}},
 												Children: []*Node{
 													{
 														Type:    NodeText,
@@ -346,38 +337,40 @@ func TestParserExpanded(t *testing.T) {
 						},
 					},
 				},
-			},
+			}),
 		},
 		{
 			name:  "Complex Attribute Combinations",
 			input: `<input id="input1" class="form-input" type='text' data-value="123" />`,
-			expectedRoot: &Node{
-				Type:    NodeElement,
-				TagName: "root",
+			expectedRoot: inBody(&Node{
+				Type: NodeDocument,
 				Children: []*Node{
 					{
 						Type:       NodeElement,
 						TagName:    "input",
-						Attributes: map[string]string{"id": "input1", "class": "form-input", "type": "text", "data-value": "123"},
+						Attributes: []Attribute{{Name: "id", Value: "input1"}, {Name: "class", Value: "form-input"}, {Name: "type", Value: "text"}, {Name: "data-value", Value: "123"}},
 					},
 				},
-			},
+			}),
 		},
 		{
 			name:  "Handling Doctype",
 			input: `<!DOCTYPE html><html><body>Content</body></html>`,
 			expectedRoot: &Node{
-				Type:    NodeElement,
-				TagName: "root",
+				Type: NodeDocument,
 				Children: []*Node{
 					{
-						Type:    NodeComment,
-						Content: "DOCTYPE html",
+						Type:    NodeDoctype,
+						Content: "html",
 					},
 					{
 						Type:    NodeElement,
 						TagName: "html",
 						Children: []*Node{
+							{
+								Type:    NodeElement,
+								TagName: "head",
+							},
 							{
 								Type:    NodeElement,
 								TagName: "body",
@@ -397,17 +390,16 @@ func TestParserExpanded(t *testing.T) {
 			name:  "Real-World Sample",
 			input: `<!DOCTYPE html><html lang="en"><head><meta charset="UTF-8"><title>Test</title></head><body><div class="container"><h1>Main Heading</h1><p>A <strong>bold</strong> statement.</p></div></body></html>`,
 			expectedRoot: &Node{
-				Type:    NodeElement,
-				TagName: "root",
+				Type: NodeDocument,
 				Children: []*Node{
 					{
-						Type:    NodeComment,
-						Content: "DOCTYPE html",
+						Type:    NodeDoctype,
+						Content: "html",
 					},
 					{
 						Type:       NodeElement,
 						TagName:    "html",
-						Attributes: map[string]string{"lang": "en"},
+						Attributes: []Attribute{{Name: "lang", Value: "en"}},
 						Children: []*Node{
 							{
 								Type:    NodeElement,
@@ -416,7 +408,7 @@ func TestParserExpanded(t *testing.T) {
 									{
 										Type:       NodeElement,
 										TagName:    "meta",
-										Attributes: map[string]string{"charset": "UTF-8"},
+										Attributes: []Attribute{{Name: "charset", Value: "UTF-8"}},
 									},
 									{
 										Type:    NodeElement,
@@ -437,7 +429,7 @@ func TestParserExpanded(t *testing.T) {
 									{
 										Type:       NodeElement,
 										TagName:    "div",
-										Attributes: map[string]string{"class": "container"},
+										Attributes: []Attribute{{Name: "class", Value: "container"}},
 										Children: []*Node{
 											{
 												Type:    NodeElement,
@@ -485,9 +477,8 @@ func TestParserExpanded(t *testing.T) {
 		{
 			name:  "Malformed Large Document",
 			input: `<div><p>Paragraph 1<p>Paragraph 2</div>`,
-			expectedRoot: &Node{
-				Type:    NodeElement,
-				TagName: "root",
+			expectedRoot: inBody(&Node{
+				Type: NodeDocument,
 				Children: []*Node{
 					{
 						Type:    NodeElement,
@@ -516,7 +507,71 @@ func TestParserExpanded(t *testing.T) {
 						},
 					},
 				},
-			},
+			}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(tt.input)
+			root := p.Parse()
+
+			if !compareNodes(root.Node, tt.expectedRoot) {
+				t.Fatalf("Test '%s' failed: Trees do not match.\nExpected:\n%+v\nGot:\n%+v", tt.name, tt.expectedRoot, root)
+			}
+		})
+	}
+}
+
+func TestParserRawText(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string
+		expectedRoot *Node
+	}{
+		{
+			name:  "Script Is Not Parsed",
+			input: `<div><script>if (a<b) { s = "</div>&amp;"; }</script></div>`,
+			expectedRoot: inBody(&Node{
+				Type: NodeDocument,
+				Children: []*Node{
+					{
+						Type:    NodeElement,
+						TagName: "div",
+						Children: []*Node{
+							{
+								Type:    NodeElement,
+								TagName: "script",
+								Children: []*Node{
+									{
+										Type:    NodeText,
+										Content: `if (a<b) { s = "</div>&amp;"; }`,
+									},
+								},
+							},
+						},
+					},
+				},
+			}),
+		},
+		{
+			name:  "Textarea Decodes Entities",
+			input: `<textarea><p>Tom &amp; Jerry</textarea>`,
+			expectedRoot: inBody(&Node{
+				Type: NodeDocument,
+				Children: []*Node{
+					{
+						Type:    NodeElement,
+						TagName: "textarea",
+						Children: []*Node{
+							{
+								Type:    NodeText,
+								Content: "<p>Tom & Jerry",
+							},
+						},
+					},
+				},
+			}),
 		},
 	}
 
@@ -525,27 +580,359 @@ func TestParserExpanded(t *testing.T) {
 			p := New(tt.input)
 			root := p.Parse()
 
-			if !compareNodes(root, tt.expectedRoot) {
+			if !compareNodes(root.Node, tt.expectedRoot) {
 				t.Fatalf("Test '%s' failed: Trees do not match.\nExpected:\n%+v\nGot:\n%+v", tt.name, tt.expectedRoot, root)
 			}
 		})
 	}
 }
 
+func TestParserTreeConstruction(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string // Contents of the body
+	}{
+		{"Misnested Formatting", `<b><i></b></i>`, `<b><i></i></b>`},
+		{"Formatting Across Block", `<b>1<p>2</b>3</p>`, `<b>1</b><p><b>2</b>3</p>`},
+		{"Adoption Agency", `<b>1<i>2<p>3</b>4`, `<b>1<i>2</i></b><i><p><b>3</b>4</p></i>`},
+		{"Nested Links", `<a href="x">1<a href="y">2`, `<a href="x">1</a><a href="y">2</a>`},
+		{"Reconstruct Formatting", `<p><b>1</p>2`, `<p><b>1</b></p><b>2</b>`},
+		{"Paragraphs", `<p>1<p>2<div>3</div>`, `<p>1</p><p>2</p><div>3</div>`},
+		{"Stray Paragraph End Tag", `a</p>b`, `a<p></p>b`},
+		{"Stray End Tags", `<div>a</span>b</div></div>c`, `<div>ab</div>c`},
+		{"Headings", `<h1>a<h2>b`, `<h1>a</h1><h2>b</h2>`},
+		{"List Items", `<ul><li>a<div><li>b</ul>`, `<ul><li>a<div></div></li><li>b</li></ul>`},
+		{"Definition Lists", `<dl><dt>a<dd>b<dt>c</dl>`, `<dl><dt>a</dt><dd>b</dd><dt>c</dt></dl>`},
+		{"Buttons", `<button>a<button>b`, `<button>a</button><button>b</button>`},
+		{"Table Closes Paragraph", `<!DOCTYPE html><p>a<table></table>`, `<p>a</p><table></table>`},
+		{"Table In Paragraph In Quirks Mode", `<p>a<table></table>`, `<p>a<table></table></p>`},
+		{"Table Cells", `<table><tbody><tr><td>1<td>2</table>x`, `<table><tbody><tr><td>1</td><td>2</td></tr></tbody></table>x`},
+		{"Nested Tables", `<table><tbody><tr><td><table><tbody><tr><td>a</table>b</table>`, `<table><tbody><tr><td><table><tbody><tr><td>a</td></tr></tbody></table>b</td></tr></tbody></table>`},
+		{"Select", `<select><option>a<option>b<div>x</div></select>`, `<select><option>a</option><option>bx</option></select>`},
+		{"Select In Table Cell", `<table><tbody><tr><td><select><option>a</td></tr></table>b`, `<table><tbody><tr><td><select><option>a</option></select></td></tr></tbody></table>b`},
+		{"Implicit Tbody", `<table><tr><td>1</td></tr></table>`, `<table><tbody><tr><td>1</td></tr></tbody></table>`},
+		{"Implicit Row", `<table><td>1<td>2</table>`, `<table><tbody><tr><td>1</td><td>2</td></tr></tbody></table>`},
+		{"Whitespace In Table", "<table> <tr> <td>1</td> </tr> </table>", "<table> <tbody><tr> <td>1</td> </tr> </tbody></table>"},
+		{"Foster Parented Text", `x<table>y<tr><td>1</table>`, `xy<table><tbody><tr><td>1</td></tr></tbody></table>`},
+		{"Foster Parented Element", `<table><tr><div>x</div><td>1</table>`, `<div>x</div><table><tbody><tr><td>1</td></tr></tbody></table>`},
+		{"Foster Parented Formatting", `<table><b>x<tr><td>y</table>`, `<b>x</b><table><tbody><tr><td>y</td></tr></tbody></table>`},
+		{"Newline After Pre", "<pre>\nx</pre>", `<pre>x</pre>`},
+		{"Ignored Style", `<select><style>a<b>x</b></style></select>`, `<select>ax</select>`},
+		{"Ignored Xmp", `<select><xmp>a<b>x</b></select>`, `<select>ax</select>`},
+		{"Plaintext", `<p>a<plaintext>b</p><i>`, `<p>a</p><plaintext>b</p><i></plaintext>`},
+		{"CRLF After Pre", "<pre>\r\nx\r\ny</pre>", "<pre>x\ny</pre>"},
+		// Render repeats a leading newline, so that it survives parsing again
+		{"CR After Listing", "<listing>\r\rx</listing>", "<listing>\n\nx</listing>"},
+		{"CRLF After Textarea", "<textarea>\r\nt\r</textarea>", "<textarea>t\n</textarea>"},
+		{"CRLF In Text", "<p title=\"a\r\nb\">a\r\nb\rc</p>", "<p title=\"a\nb\">a\nb\nc</p>"},
+		{"Case Insensitive", `<DIV ID=x>a</div>b`, `<div id="x">a</div>b`},
+		{"Character References", `<a href="?x=1&notit=2&amp;y">&notit; &#x80;</a>`, `<a href="?x=1&amp;notit=2&amp;y">¬it; €</a>`},
+		{"Self-Closing Script", `<body><script/>alert("<b>x</b>")</script><p>after`, `<script>alert("<b>x</b>")</script><p>after</p>`},
+		{"References Decoded Once", `<textarea>&amp;lt;</textarea><script>a&amp;&amp;b</script>`, `<textarea>&amp;lt;</textarea><script>a&amp;&amp;b</script>`},
+		{"Stray Comment Opener", `<p>a<!-- <!-- --> b`, `<p>a<!--<!----> b</p>`},
+		{"Word Markup", `<p class=MsoNormal>a<o:p></o:p></p>`, `<p class="MsoNormal">a<o:p></o:p></p>`},
+		{"Duplicate Attributes", `<p id=a class=c ID=b>x`, `<p id="a" class="c">x</p>`},
+		{"SVG Names", `<svg ViewBox="0 0 1 1"><CLIPPATH></clippath><rect/></svg>`, `<svg viewBox="0 0 1 1"><clipPath></clipPath><rect></rect></svg>`},
+		{"SVG Foreign Object", `<svg><foreignObject><clippath viewbox=1></clippath></foreignObject></svg>`, `<svg><foreignObject><clippath viewbox="1"></clippath></foreignObject></svg>`},
+		{"MathML Names", `<math definitionurl="x"><mi>a</mi></math>`, `<math definitionURL="x"><mi>a</mi></math>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := New(tt.input).Parse()
+			checkLinks(t, root.Node)
+			if got := root.FindByTag("body")[0].InnerHTML(); got != tt.expected {
+				t.Fatalf("Test '%s' - expected=%q, got=%q", tt.name, tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestParserTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string // Contents of the body
+	}{
+		{"Template Contents", `<template><p>a</p></template><p>b</p>`, `<template><p>a</p></template><p>b</p>`},
+		{"Table Rows", `<template><tr><td>1</td></tr><tr><td>2</template>x`, `<template><tr><td>1</td></tr><tr><td>2</td></tr></template>x`},
+		{"Cells And Columns", `<template><td>1<th>2</template><template><col></template>`, `<template><td>1</td><th>2</th></template><template><col></template>`},
+		{"Nested Templates", `<template>a<template>b</template>c</template>`, `<template>a<template>b</template>c</template>`},
+		{"Closes Open Elements", `<template><div><b>a</template>b`, `<template><div><b>a</b></div></template>b`},
+		{"Stray End Tag", `</template><p>a</p>`, `<p>a</p>`},
+		{"Unclosed At End", `<template><table><tr><td>a`, `<template><table><tbody><tr><td>a</td></tr></tbody></table></template>`},
+		{"In Table", `<table><template><tr><td>1</template><tr><td>2</table>`, `<table><template><tr><td>1</td></tr></template><tbody><tr><td>2</td></tr></tbody></table>`},
+		{"Foster Parenting In Template", `<template><table>a</table></template>`, `<template>a<table></table></template>`},
+		{"Forms In Templates", `<form><template><form>a</form></template></form>`, `<form><template><form>a</form></template></form>`},
+		{"Comments", `<template><!--c--></template>`, `<template><!--c--></template>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A template before the body would be put into the head
+			root := New("<body>" + tt.input).Parse()
+			checkLinks(t, root.Node)
+			if got := root.Body().InnerHTML(); got != tt.expected {
+				t.Fatalf("Test '%s' - expected=%q, got=%q", tt.name, tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestParserTemplateContent(t *testing.T) {
+	root := New(`<head><template id="t"><style>s</style></template></head><table><template><tr id="r"><td>1</td></tr></template></table>`).Parse()
+
+	if got := len(root.FindByTag("tr")); got != 0 {
+		t.Fatalf("expected no rows outside templates, got %d", got)
+	}
+	if root.FindByID("r") != nil {
+		t.Fatalf("expected FindByID to skip template contents")
+	}
+
+	templates := root.FindByTag("template")
+	if len(templates) != 2 {
+		t.Fatalf("expected 2 templates, got %d", len(templates))
+	}
+	if templates[0].Parent != root.Head() {
+		t.Fatalf("expected the first template in the head")
+	}
+	for _, tmpl := range templates {
+		if len(tmpl.Children) != 0 {
+			t.Fatalf("expected no children in <template>, got %d", len(tmpl.Children))
+		}
+		if tmpl.TemplateContent == nil || tmpl.TemplateContent.Type != NodeDocumentFragment {
+			t.Fatalf("expected a document fragment, got %+v", tmpl.TemplateContent)
+		}
+	}
+
+	content := templates[1].TemplateContent
+	rows := content.FindByTag("tr")
+	if len(rows) != 1 || rows[0].Parent != content {
+		t.Fatalf("expected the row at the top of the template contents, got %d rows", len(rows))
+	}
+	expected := `<template><tr id="r"><td>1</td></tr></template>`
+	if got := templates[1].OuterHTML(); got != expected {
+		t.Fatalf("expected=%q, got=%q", expected, got)
+	}
+	if got := templates[1].Clone(true).OuterHTML(); got != expected {
+		t.Fatalf("deep clone - expected=%q, got=%q", expected, got)
+	}
+	if got := templates[1].Clone(false).OuterHTML(); got != `<template></template>` {
+		t.Fatalf("shallow clone - expected=%q, got=%q", `<template></template>`, got)
+	}
+}
+
+func TestParserDocumentStructure(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "Empty Input",
+			input:    ``,
+			expected: `<html><head></head><body></body></html>`,
+		},
+		{
+			name:     "Head Elements",
+			input:    "<title>T</title>\n<meta charset=\"utf-8\"><p>x",
+			expected: "<html><head><title>T</title>\n<meta charset=\"utf-8\"></head><body><p>x</p></body></html>",
+		},
+		{
+			name:     "Late Head Element",
+			input:    `<head></head><link rel="x"><p>x</p>`,
+			expected: `<html><head><link rel="x"></head><body><p>x</p></body></html>`,
+		},
+		{
+			name:     "Repeated Html And Body Tags",
+			input:    `<html><body><p>x<body class="b"><html lang="en">`,
+			expected: `<html lang="en"><head></head><body class="b"><p>x</p></body></html>`,
+		},
+		{
+			name:     "Content After Body",
+			input:    `<p>x</p></body></html><!--c--><p>y`,
+			expected: `<html><head></head><body><p>x</p><p>y</p></body></html><!--c-->`,
+		},
+		{
+			name:     "Frameset",
+			input:    `<frameset><frame src="a"></frameset>`,
+			expected: `<html><head></head><frameset><frame src="a"></frameset></html>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := New(tt.input).Parse()
+			checkLinks(t, root.Node)
+			if got := root.OuterHTML(); got != tt.expected {
+				t.Fatalf("Test '%s' - expected=%q, got=%q", tt.name, tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestParserSpans(t *testing.T) {
+	input := "<body>\n<table>\n  <tr><td>1</td></tr>\n</table>\n<pre>\nx</pre><p>open"
+
+	// Windows and old Mac line endings count as one line break each
+	for _, newline := range []string{"\n", "\r\n", "\r"} {
+		root := New(strings.ReplaceAll(input, "\n", newline)).Parse()
+
+		tests := []struct {
+			name  string
+			node  *Node
+			start string
+			end   string
+		}{
+			{"table", root.FindByTag("table")[0], "line 2, col 1", "line 4, col 9"},
+			{"td", root.FindByTag("td")[0], "line 3, col 7", "line 3, col 17"},
+			{"td text", root.FindByTag("td")[0].Children[0], "line 3, col 11", "line 3, col 12"},
+			{"pre text", root.FindByTag("pre")[0].Children[0], "line 6, col 1", "line 6, col 2"},
+			{"unclosed p", root.FindByTag("p")[0], "line 6, col 8", "line 6, col 15"},
+		}
+
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s %q", tt.name, newline), func(t *testing.T) {
+				if got := tt.node.Span.Start.String(); got != tt.start {
+					t.Fatalf("start wrong. expected=%q, got=%q", tt.start, got)
+				}
+				if got := tt.node.Span.End.String(); got != tt.end {
+					t.Fatalf("end wrong. expected=%q, got=%q", tt.end, got)
+				}
+			})
+		}
+	}
+}
+
+func TestParseReader(t *testing.T) {
+	input := `<!DOCTYPE html><html><head><title>T &amp; U</title></head><body>` +
+		strings.Repeat(`<table><tr><td class="c">Zemun</td><td>08:00</td></tr></table>`, 2000) +
+		`<script>if (a<b) {}</script></body></html>`
+
+	expected := New(input).Parse()
+	root, err := ParseReader(iotest.HalfReader(strings.NewReader(input)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !compareNodes(root.Node, expected.Node) {
+		t.Fatalf("streamed parse produced a different tree")
+	}
+	last := root.FindByTag("script")[0]
+	if last.Span != expected.FindByTag("script")[0].Span {
+		t.Fatalf("spans differ: expected=%+v, got=%+v", expected.FindByTag("script")[0].Span, last.Span)
+	}
+
+	failure := errors.New("connection reset")
+	root, err = ParseReader(io.MultiReader(strings.NewReader("<div><p>partial"), iotest.ErrReader(failure)))
+	if !errors.Is(err, failure) {
+		t.Fatalf("expected the read error, got %v", err)
+	}
+	if len(root.FindByTag("p")) != 1 {
+		t.Fatalf("expected the partial tree to be returned")
+	}
+}
+
+func TestParserFindByTagCase(t *testing.T) {
+	root := New(`<table><TR><td>1</TD></tr></table><svg><clipPath/></svg>`).Parse()
+	if n := len(root.FindByTag("TD")); n != 1 {
+		t.Fatalf("expected 1 td, got %d", n)
+	}
+	if n := len(root.FindByTag("clippath")); n != 1 {
+		t.Fatalf("expected 1 clipPath, got %d", n)
+	}
+}
+
+func TestParserDeepNesting(t *testing.T) {
+	const depth = 50000
+	tests := []struct {
+		name  string
+		input string
+		tag   string
+	}{
+		{"Divs", strings.Repeat("<div>", depth), "div"},
+		{"Paragraphs In Objects", strings.Repeat("<p><object>", depth), "object"},
+		{"Formatting", strings.Repeat("<b>", depth), "b"},
+		{"Tables", strings.Repeat("<table><tr><td>", depth), "td"},
+		{"SVG", "<svg>" + strings.Repeat("<g>", depth), "g"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Parsing used to take time quadratic in the depth, which ran
+			// for minutes at this depth
+			start := time.Now()
+			root := New(tt.input).Parse()
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Fatalf("Test '%s' - parsing took %v", tt.name, elapsed)
+			}
+
+			n, got := root.Body(), 0
+			for len(n.Children) > 0 {
+				n = n.Children[len(n.Children)-1]
+				if n.TagName == tt.tag {
+					got++
+				}
+			}
+			if got != depth {
+				t.Fatalf("Test '%s' - expected=%d nested <%s>, got=%d", tt.name, depth, tt.tag, got)
+			}
+		})
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		`<div><p>Paragraph 1<p>Paragraph 2</div>`,
+		`<!DOCTYPE html><html lang="en"><head><title>T</title></head><body></body></html>`,
+		`<table><tr><td>1<td>2</table>`,
+		`<script>a<b</script><textarea>&amp;</textarea>`,
+		`<!-x`, `<a href="x`, `<b><i></b></i>`,
+		`<table><template><tr><td>1</template></table>`,
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		root := New(input).Parse()
+		checkLinks(t, root.Node)
+		_ = root.OuterHTML()
+	})
+}
+
+// inBody puts the children of an expected document into the body of a document,
+// which is where Parse puts content that has no html, head or body tags.
+func inBody(root *Node) *Node {
+	return &Node{
+		Type: NodeDocument,
+		Children: []*Node{
+			{
+				Type:    NodeElement,
+				TagName: "html",
+				Children: []*Node{
+					{Type: NodeElement, TagName: "head"},
+					{Type: NodeElement, TagName: "body", Children: root.Children},
+				},
+			},
+		},
+	}
+}
+
 func compareNodes(a, b *Node) bool {
-	if a.Type != b.Type || a.TagName != b.TagName || a.Content != b.Content {
+	if a.Type != b.Type || a.TagName != b.TagName || a.Namespace != b.Namespace || a.Content != b.Content {
 		fmt.Printf("Node mismatch:\nExpected: %+v\nGot: %+v\n", b, a)
 		return false
 	}
 
-	// Compare attributes
+	// Compare attributes, ignoring how they were quoted
 	if len(a.Attributes) != len(b.Attributes) {
 		fmt.Printf("Attribute mismatch:\nExpected: %+v\nGot: %+v\n", b.Attributes, a.Attributes)
 		return false
 	}
-	for key, val := range a.Attributes {
-		if b.Attributes[key] != val {
-			fmt.Printf("Attribute mismatch for key '%s':\nExpected: %s\nGot: %s\n", key, val, b.Attributes[key])
+	for i, attr := range a.Attributes {
+		other := b.Attributes[i]
+		if attr.Name != other.Name || attr.Value != other.Value || attr.Namespace != other.Namespace {
+			fmt.Printf("Attribute mismatch at %d:\nExpected: %+v\nGot: %+v\n", i, other, attr)
 			return false
 		}
 	}
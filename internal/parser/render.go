@@ -0,0 +1,144 @@
+package parser
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// Render writes the HTML serialization of n to w, following the HTML5
+// fragment serialization rules: text and attribute values are escaped, void
+// elements have no end tag and raw text elements are written verbatim.
+func Render(w io.Writer, n *Node) error {
+	bw, ok := w.(*bufio.Writer)
+	if !ok {
+		bw = bufio.NewWriter(w)
+	}
+	if err := render(bw, n); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// OuterHTML returns the serialization of the node itself and its descendants.
+func (n *Node) OuterHTML() string {
+	var sb strings.Builder
+	_ = Render(&sb, n)
+	return sb.String()
+}
+
+// InnerHTML returns the serialization of the node's children, or of the
+// contents of a template element.
+func (n *Node) InnerHTML() string {
+	var sb strings.Builder
+	bw := bufio.NewWriter(&sb)
+	_ = renderChildren(bw, n)
+	_ = bw.Flush()
+	return sb.String()
+}
+
+func render(w *bufio.Writer, n *Node) error {
+	switch n.Type {
+	case NodeText:
+		if n.Parent != nil && n.Parent.Namespace == "" && isRawTextElement(n.Parent.TagName) {
+			_, err := w.WriteString(n.Content)
+			return err
+		}
+		return escapeText(w, n.Content)
+	case NodeComment:
+		_, err := w.WriteString("<!--" + n.Content + "-->")
+		return err
+	case NodeDoctype:
+		// Identifiers are dropped, as in the HTML serialization algorithm
+		_, err := w.WriteString("<!DOCTYPE " + n.Content + ">")
+		return err
+	case NodeAttribute:
+		return nil
+	}
+
+	// A document or fragment has no markup of its own
+	if n.Type == NodeDocument || n.Type == NodeDocumentFragment {
+		return renderChildren(w, n)
+	}
+
+	w.WriteByte('<')
+	w.WriteString(n.TagName)
+	for _, a := range n.Attributes {
+		w.WriteByte(' ')
+		w.WriteString(a.Name)
+		w.WriteString(`="`)
+		if err := escapeAttribute(w, a.Value); err != nil {
+			return err
+		}
+		w.WriteByte('"')
+	}
+	if _, err := w.WriteString(">"); err != nil {
+		return err
+	}
+	if isVoidElement(n.TagName) {
+		return nil
+	}
+
+	// A leading newline in these elements is dropped by the parser, so a
+	// meaningful one has to be doubled
+	switch strings.ToLower(n.TagName) {
+	case "pre", "textarea", "listing":
+		if first := n.FirstChildNode(); first != nil && first.Type == NodeText && strings.HasPrefix(first.Content, "\n") {
+			w.WriteByte('\n')
+		}
+	}
+
+	if err := renderChildren(w, n); err != nil {
+		return err
+	}
+	_, err := w.WriteString("</" + n.TagName + ">")
+	return err
+}
+
+func renderChildren(w *bufio.Writer, n *Node) error {
+	if n.TemplateContent != nil {
+		n = n.TemplateContent
+	}
+	for _, child := range n.Children {
+		if err := render(w, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func escapeText(w *bufio.Writer, s string) error {
+	return escape(w, s, false)
+}
+
+func escapeAttribute(w *bufio.Writer, s string) error {
+	return escape(w, s, true)
+}
+
+// escape writes s with &, non-breaking spaces and either < and > (text) or
+// double quotes (attribute values) replaced by character references.
+func escape(w *bufio.Writer, s string, attribute bool) error {
+	last := 0
+	for i, r := range s {
+		var ref string
+		switch {
+		case r == '&':
+			ref = "&amp;"
+		case r == '\u00a0':
+			ref = "&nbsp;"
+		case r == '"' && attribute:
+			ref = "&quot;"
+		case r == '<' && !attribute:
+			ref = "&lt;"
+		case r == '>' && !attribute:
+			ref = "&gt;"
+		default:
+			continue
+		}
+		w.WriteString(s[last:i])
+		w.WriteString(ref)
+		last = i + len(string(r))
+	}
+	_, err := w.WriteString(s[last:])
+	return err
+}
@@ -0,0 +1,100 @@
+package parser
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "Elements And Text",
+			input:    `<div><p>Hello <b>world</b></p></div>`,
+			expected: `<html><head></head><body><div><p>Hello <b>world</b></p></div></body></html>`,
+		},
+		{
+			name:     "Text Escaping",
+			input:    `<p>a &lt; b &amp;&amp; c &gt; d&nbsp;e</p>`,
+			expected: `<html><head></head><body><p>a &lt; b &amp;&amp; c &gt; d&nbsp;e</p></body></html>`,
+		},
+		{
+			name:     "Attribute Escaping",
+			input:    `<a title='say "hi" &amp; <go>' href="/x?a=1&amp;b=2">x</a>`,
+			expected: `<html><head></head><body><a title="say &quot;hi&quot; &amp; <go>" href="/x?a=1&amp;b=2">x</a></body></html>`,
+		},
+		{
+			name:     "Void Elements",
+			input:    `<div><img src="a.png"><br/><input type="text" disabled></div>`,
+			expected: `<html><head></head><body><div><img src="a.png"><br><input type="text" disabled=""></div></body></html>`,
+		},
+		{
+			name:     "Raw Text Elements",
+			input:    `<script>if (a < b && c) { x = "</div>"; }</script><style>a > b {}</style>`,
+			expected: `<html><head><script>if (a < b && c) { x = "</div>"; }</script><style>a > b {}</style></head><body></body></html>`,
+		},
+		{
+			name:     "RCDATA Is Escaped",
+			input:    `<textarea><b> &amp;</textarea>`,
+			expected: `<html><head></head><body><textarea>&lt;b&gt; &amp;</textarea></body></html>`,
+		},
+		{
+			name:     "Comments",
+			input:    `<div><!-- note --></div>`,
+			expected: `<html><head></head><body><div><!--note--></div></body></html>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := New(tt.input).Parse()
+			var buf bytes.Buffer
+			if err := Render(&buf, root.Node); err != nil {
+				t.Fatalf("Test '%s' - unexpected error: %v", tt.name, err)
+			}
+			if buf.String() != tt.expected {
+				t.Fatalf("Test '%s' - expected=%q, got=%q", tt.name, tt.expected, buf.String())
+			}
+		})
+	}
+}
+
+func TestOuterAndInnerHTML(t *testing.T) {
+	root := New(`<ul id="list"><li>one</li><li class="x">two &amp; three</li></ul>`).Parse()
+	ul := root.FindByID("list")
+
+	if got, expected := ul.OuterHTML(), `<ul id="list"><li>one</li><li class="x">two &amp; three</li></ul>`; got != expected {
+		t.Fatalf("OuterHTML - expected=%q, got=%q", expected, got)
+	}
+	if got, expected := ul.InnerHTML(), `<li>one</li><li class="x">two &amp; three</li>`; got != expected {
+		t.Fatalf("InnerHTML - expected=%q, got=%q", expected, got)
+	}
+	if got, expected := ul.Children[1].Children[0].OuterHTML(), `two &amp; three`; got != expected {
+		t.Fatalf("OuterHTML of text - expected=%q, got=%q", expected, got)
+	}
+}
+
+func TestRenderRoundTrip(t *testing.T) {
+	inputs := []string{
+		`<!DOCTYPE html><html lang="en"><head><meta charset="UTF-8"><title>T &amp; U</title></head><body><div class="container"><h1>Main</h1><p>A <strong>bold</strong> statement.</p></div></body></html>`,
+		`<table><tr><td data-x='a"b'>1 &lt; 2</td><td>&copy; 2024</td></tr></table>`,
+		`<div><script>var s = "<p>" + '&amp;';</script><textarea>&lt;/textarea&gt;</textarea></div>`,
+		`<ul><li>one<li>two</ul><p>Para<p>Para 2`,
+	}
+
+	for _, input := range inputs {
+		first := New(input).Parse()
+		html := first.OuterHTML()
+		second := New(html).Parse()
+
+		if !compareNodes(second.Node, first.Node) {
+			t.Fatalf("round trip changed the tree.\nInput: %s\nRendered: %s", input, html)
+		}
+		if again := second.OuterHTML(); again != html {
+			t.Fatalf("rendering is not stable.\nFirst: %s\nSecond: %s", html, again)
+		}
+	}
+}
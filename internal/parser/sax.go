@@ -0,0 +1,172 @@
+package parser
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/rsolovyeaws/go-html-parser/internal/lexer"
+)
+
+// Handler receives the events produced by Parser.ParseEvents. Returning an
+// error from any callback stops parsing; return ErrStop to stop early without
+// ParseEvents reporting a failure.
+type Handler interface {
+	StartElement(name string, attrs []Attribute) error
+	EndElement(name string) error
+	Text(text string) error
+	Comment(text string) error
+	Doctype(name string) error
+}
+
+// ErrStop can be returned by a Handler to stop parsing early.
+var ErrStop = errors.New("parser: stop")
+
+// NopHandler implements Handler by ignoring every event. Embed it to
+// implement only the callbacks you need.
+type NopHandler struct{}
+
+func (NopHandler) StartElement(name string, attrs []Attribute) error { return nil }
+func (NopHandler) EndElement(name string) error                      { return nil }
+func (NopHandler) Text(text string) error                            { return nil }
+func (NopHandler) Comment(text string) error                         { return nil }
+func (NopHandler) Doctype(name string) error                         { return nil }
+
+// ParseEvents reports the document to h as a stream of events instead of
+// building a tree. Events follow the source closely rather than the full tree
+// construction rules of Parse, so no elements are implied or moved, but they
+// are always well nested: optional end tags such as </li> and </p> are
+// implied, void and self-closing elements get an immediate EndElement, stray
+// end tags are dropped and elements still open at the end of input are closed.
+func (p *Parser) ParseEvents(h Handler) error {
+	var stack []*Node // The open elements, without children
+
+	// Foreign content reads CDATA sections, and its <style> holds markup
+	foreign := func() bool {
+		return len(stack) > 0 && stack[len(stack)-1].Namespace != ""
+	}
+	closeTo := func(depth int) error {
+		for len(stack) > depth {
+			n := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if err := h.EndElement(n.TagName); err != nil {
+				return err
+			}
+		}
+		p.lexer.SetAllowCDATA(foreign())
+		return nil
+	}
+
+	err := func() error {
+		for ; p.curr.Type != lexer.TokenEOF; p.nextToken() {
+			switch p.curr.Type {
+			case lexer.TokenStartTag, lexer.TokenSelfClosingTag:
+				var parent *Node
+				depth := len(stack)
+				if depth > 0 {
+					parent = stack[depth-1]
+				}
+				node := p.parseElement(elementNamespace(p.curr, parent))
+				for depth > 0 && node.Namespace == "" && stack[depth-1].Namespace == "" &&
+					impliesEnd(stack[depth-1].TagName, node.TagName) {
+					depth--
+				}
+				if err := closeTo(depth); err != nil {
+					return err
+				}
+				if err := h.StartElement(node.TagName, node.Attributes); err != nil {
+					return err
+				}
+				// The '/' of an HTML <script/> or <title/> is ignored, as the
+				// lexer reads their contents as text
+				selfClosing := p.curr.Type == lexer.TokenSelfClosingTag &&
+					!(node.Namespace == "" && isRawTextElement(node.TagName) || isOneOf(node, "textarea", "title"))
+				if selfClosing || node.Namespace == "" && isVoidElement(node.TagName) {
+					if err := h.EndElement(node.TagName); err != nil {
+						return err
+					}
+				} else {
+					stack = append(stack, node)
+				}
+				if node.Namespace != "" {
+					p.lexer.SetRawTextElement("")
+				}
+				p.lexer.SetAllowCDATA(foreign())
+
+			case lexer.TokenEndTag:
+				for i := len(stack) - 1; i >= 0; i-- {
+					if strings.EqualFold(stack[i].TagName, p.curr.Value) {
+						if err := closeTo(i); err != nil {
+							return err
+						}
+						break
+					}
+				}
+
+			case lexer.TokenText:
+				if p.curr.Value == "" {
+					continue
+				}
+				if err := h.Text(p.curr.Value); err != nil {
+					return err
+				}
+
+			case lexer.TokenComment:
+				if p.opts.DropComments {
+					continue
+				}
+				if err := h.Comment(p.curr.Value); err != nil {
+					return err
+				}
+
+			case lexer.TokenDoctype:
+				if err := h.Doctype(p.curr.Value); err != nil {
+					return err
+				}
+			}
+		}
+		return closeTo(0)
+	}()
+
+	if err == ErrStop {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return p.lexer.Err()
+}
+
+// impliesEnd reports whether a start tag named next closes an open element
+// named current, as the optional end tags of the tree construction rules do:
+// block-level elements close a paragraph, a list item closes the previous
+// one, and so on. ParseEvents only closes elements from the innermost one
+// outwards, so unlike Parse it keeps the div of "<p><b><div>" inside the b
+// and the p. It also ignores quirks mode, in which Parse keeps a table inside
+// a paragraph.
+func impliesEnd(current, next string) bool {
+	switch current {
+	case "p":
+		return closesP[next]
+	case "li":
+		return next == "li"
+	case "dd", "dt":
+		return next == "dd" || next == "dt"
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		return len(next) == 2 && next[0] == 'h' && '1' <= next[1] && next[1] <= '6'
+	case "option":
+		return next == "option" || next == "optgroup"
+	case "optgroup":
+		return next == "optgroup"
+	case "rb", "rp", "rt":
+		return next == "rb" || next == "rp" || next == "rt" || next == "rtc"
+	case "rtc":
+		return next == "rb" || next == "rtc"
+	case "thead", "tbody", "tfoot":
+		return next == "thead" || next == "tbody" || next == "tfoot"
+	case "tr":
+		return next == "tr"
+	case "td", "th":
+		return next == "td" || next == "th" || next == "tr"
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+package parser
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// recorder logs events in a compact form: <tag a=b>, </tag>, "text", !comment, doctype:name.
+type recorder struct {
+	events []string
+	stopAt string // Stop with ErrStop on this start tag
+}
+
+func (r *recorder) StartElement(name string, attrs []Attribute) error {
+	if name == r.stopAt {
+		return ErrStop
+	}
+	var parts []string
+	for _, a := range attrs {
+		parts = append(parts, " "+a.Name+"="+a.Value)
+	}
+	r.events = append(r.events, "<"+name+strings.Join(parts, "")+">")
+	return nil
+}
+
+func (r *recorder) EndElement(name string) error {
+	r.events = append(r.events, "</"+name+">")
+	return nil
+}
+
+func (r *recorder) Text(text string) error {
+	r.events = append(r.events, `"`+text+`"`)
+	return nil
+}
+
+func (r *recorder) Comment(text string) error {
+	r.events = append(r.events, "!"+text)
+	return nil
+}
+
+func (r *recorder) Doctype(text string) error {
+	r.events = append(r.events, "doctype:"+text)
+	return nil
+}
+
+func TestParseEvents(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "Simple Document",
+			input:    `<!DOCTYPE html><div class="a">Tom &amp; Jerry<!-- c --></div>`,
+			expected: `doctype:html <div class=a> "Tom & Jerry" !c </div>`,
+		},
+		{
+			name:     "Void And Self-Closing Elements",
+			input:    `<p>a<br>b<img src="x"/></p>`,
+			expected: `<p> "a" <br> </br> "b" <img src=x> </img> </p>`,
+		},
+		{
+			name:     "Implicit Close",
+			input:    `<ul><li>one<li>two</ul>`,
+			expected: `<ul> <li> "one" </li> <li> "two" </li> </ul>`,
+		},
+		{
+			name:     "Block Closes Paragraph",
+			input:    `<p>a<section>b<h1>c<h2>d</section><dl><dt>e<dd>f</dl>`,
+			expected: `<p> "a" </p> <section> "b" <h1> "c" </h1> <h2> "d" </h2> </section> <dl> <dt> "e" </dt> <dd> "f" </dd> </dl>`,
+		},
+		{
+			name:     "Unclosed Elements Are Closed At EOF",
+			input:    `<div><span>text`,
+			expected: `<div> <span> "text" </span> </div>`,
+		},
+		{
+			name:     "Missing End Tags Closed By Ancestor",
+			input:    `<div><b><i>x</div>y`,
+			expected: `<div> <b> <i> "x" </i> </b> </div> "y"`,
+		},
+		{
+			name:     "Stray End Tag Is Dropped",
+			input:    `<div>a</span>b</div>`,
+			expected: `<div> "a" "b" </div>`,
+		},
+		{
+			name:     "Raw Text",
+			input:    `<script>a<b &amp;</script>`,
+			expected: `<script> "a<b &amp;" </script>`,
+		},
+		{
+			name:     "Self-Closing Script",
+			input:    `<script/>a<b</script><p>x`,
+			expected: `<script> "a<b" </script> <p> "x" </p>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &recorder{}
+			if err := New(tt.input).ParseEvents(r); err != nil {
+				t.Fatalf("Test '%s' - unexpected error: %v", tt.name, err)
+			}
+			if got := strings.Join(r.events, " "); got != tt.expected {
+				t.Fatalf("Test '%s' - expected=%q, got=%q", tt.name, tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestParseEventsClosesP(t *testing.T) {
+	// ParseEvents and Parse must agree on which start tags close a paragraph
+	for name := range closesP {
+		input := "<!DOCTYPE html><p>a<" + name + ">"
+		doc := New(input).Parse()
+		if p := doc.FindByTag("p")[0]; len(p.Children) != 1 {
+			t.Fatalf("<%s> - expected Parse to close the p, got=%q", name, p.OuterHTML())
+		}
+
+		r := &recorder{}
+		if err := New(input).ParseEvents(r); err != nil {
+			t.Fatalf("<%s> - unexpected error: %v", name, err)
+		}
+		if got := strings.Join(r.events, " "); !strings.HasPrefix(got, `doctype:html <p> "a" </p>`) {
+			t.Fatalf("<%s> - expected ParseEvents to close the p, got=%q", name, got)
+		}
+	}
+}
+
+func TestParseEventsStop(t *testing.T) {
+	r := &recorder{stopAt: "table"}
+	if err := New(`<h1>Title</h1><table><tr><td>x</td></tr></table>`).ParseEvents(r); err != nil {
+		t.Fatalf("ErrStop should not be reported, got %v", err)
+	}
+	if got, expected := strings.Join(r.events, " "), `<h1> "Title" </h1>`; got != expected {
+		t.Fatalf("expected=%q, got=%q", expected, got)
+	}
+
+	failure := errors.New("handler failed")
+	h := &failingHandler{err: failure}
+	if err := New(`<p>a</p>`).ParseEvents(h); !errors.Is(err, failure) {
+		t.Fatalf("expected the handler error, got %v", err)
+	}
+}
+
+// failingHandler only implements Text, failing on the first call.
+type failingHandler struct {
+	NopHandler
+	err error
+}
+
+func (h *failingHandler) Text(text string) error {
+	return h.err
+}
+
+func TestParseEventsReader(t *testing.T) {
+	input := `<table>` + strings.Repeat(`<tr><td>x</td></tr>`, 1000) + `</table>`
+	count := &cellCounter{}
+	if err := NewReader(strings.NewReader(input)).ParseEvents(count); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count.cells != 1000 {
+		t.Fatalf("expected 1000 cells, got %d", count.cells)
+	}
+}
+
+type cellCounter struct {
+	NopHandler
+	cells int
+}
+
+func (c *cellCounter) StartElement(name string, attrs []Attribute) error {
+	if name == "td" {
+		c.cells++
+	}
+	return nil
+}
@@ -0,0 +1,717 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Selector is a compiled CSS selector list. Compile it once with
+// CompileSelector and reuse it to query any number of documents.
+type Selector struct {
+	source string
+	list   []complexSelector
+}
+
+// complexSelector is a chain of compound selectors joined by combinators.
+// combinators[i] sits between compounds[i] and compounds[i+1]: ' ' for
+// descendant, '>' for child, '+' for next sibling and '~' for subsequent
+// sibling.
+type complexSelector struct {
+	compounds   []compoundSelector
+	combinators []byte
+}
+
+type compoundSelector struct {
+	scope   bool   // Matches only the scoping element (leading part of a relative selector)
+	tag     string // Empty matches any element
+	attrs   []attrSelector
+	pseudos []pseudoSelector
+}
+
+type attrSelector struct {
+	name     string
+	op       string // "", "=", "~=", "|=", "^=", "$=" or "*="
+	value    string
+	foldCase bool // [a=v i]
+}
+
+type pseudoSelector struct {
+	name     string    // "nth", "only", "empty", "scope", "not", "is" or "has"
+	a, b     int       // an+b for the nth-* pseudo-classes
+	ofType   bool      // *-of-type variants count only same-tag siblings
+	fromEnd  bool      // nth-last-* and last-* count from the last sibling
+	selector *Selector // Argument of :not(), :is() and :has()
+}
+
+// CompileSelector parses a CSS selector list such as "table > tr td.x, #main".
+func CompileSelector(selector string) (*Selector, error) {
+	p := &selectorParser{input: selector}
+	list, err := p.parseList(false)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.input) {
+		return nil, p.errorf("unexpected %q", p.input[p.pos])
+	}
+	return &Selector{source: selector, list: list}, nil
+}
+
+// MustCompileSelector is like CompileSelector but panics if the selector
+// cannot be parsed. It is meant for selectors that are constants.
+func MustCompileSelector(selector string) *Selector {
+	s, err := CompileSelector(selector)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// String returns the source text of the selector.
+func (s *Selector) String() string {
+	return s.source
+}
+
+// Match reports whether the element matches any selector in the list.
+func (s *Selector) Match(n *Node) bool {
+	return s.matchScoped(n, nil)
+}
+
+// QueryAll returns every descendant of root that matches, in document order.
+func (s *Selector) QueryAll(root *Node) []*Node {
+	var result []*Node
+	walkDescendants(root, func(n *Node) bool {
+		if s.matchScoped(n, root) {
+			result = append(result, n)
+		}
+		return true
+	})
+	return result
+}
+
+// Query returns the first descendant of root that matches, or nil.
+func (s *Selector) Query(root *Node) *Node {
+	var found *Node
+	walkDescendants(root, func(n *Node) bool {
+		if s.matchScoped(n, root) {
+			found = n
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+// Query returns the first descendant matching the CSS selector, or nil.
+func (n *Node) Query(selector string) (*Node, error) {
+	s, err := CompileSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	return s.Query(n), nil
+}
+
+// QueryAll returns all descendants matching the CSS selector in document order.
+func (n *Node) QueryAll(selector string) ([]*Node, error) {
+	s, err := CompileSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	return s.QueryAll(n), nil
+}
+
+// walkDescendants visits the descendants of n in document order until visit
+// returns false. It reports whether the walk ran to completion.
+func walkDescendants(n *Node, visit func(*Node) bool) bool {
+	for _, child := range n.Children {
+		if !visit(child) || !walkDescendants(child, visit) {
+			return false
+		}
+	}
+	return true
+}
+
+//////////////
+// Matching //
+//////////////
+
+func (s *Selector) matchScoped(n, scope *Node) bool {
+	if n.Type != NodeElement {
+		return false
+	}
+	for i := range s.list {
+		c := &s.list[i]
+		if c.match(len(c.compounds)-1, n, scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// match reports whether compounds[:i+1] match with compounds[i] matching n.
+func (c *complexSelector) match(i int, n, scope *Node) bool {
+	if !c.compounds[i].match(n, scope) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+
+	switch c.combinators[i-1] {
+	case ' ':
+		for a := n.Parent; a != nil; a = a.Parent {
+			if c.match(i-1, a, scope) {
+				return true
+			}
+		}
+	case '>':
+		return n.Parent != nil && c.match(i-1, n.Parent, scope)
+	case '+':
+		prev := prevElementSibling(n)
+		return prev != nil && c.match(i-1, prev, scope)
+	case '~':
+		for prev := prevElementSibling(n); prev != nil; prev = prevElementSibling(prev) {
+			if c.match(i-1, prev, scope) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *compoundSelector) match(n, scope *Node) bool {
+	if c.scope {
+		return n == scope
+	}
+	if n.Type != NodeElement {
+		return false
+	}
+	if c.tag != "" && !strings.EqualFold(c.tag, n.TagName) {
+		return false
+	}
+	for _, a := range c.attrs {
+		if !a.match(n) {
+			return false
+		}
+	}
+	for _, p := range c.pseudos {
+		if !p.match(n, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *attrSelector) match(n *Node) bool {
+	value, found := n.Attr(a.name)
+	if !found {
+		return false
+	}
+
+	want := a.value
+	if a.foldCase {
+		value, want = strings.ToLower(value), strings.ToLower(want)
+	}
+
+	switch a.op {
+	case "":
+		return true
+	case "=":
+		return value == want
+	case "~=":
+		for _, field := range strings.Fields(value) {
+			if field == want {
+				return true
+			}
+		}
+		return false
+	case "|=":
+		return value == want || strings.HasPrefix(value, want+"-")
+	case "^=":
+		return want != "" && strings.HasPrefix(value, want)
+	case "$=":
+		return want != "" && strings.HasSuffix(value, want)
+	case "*=":
+		return want != "" && strings.Contains(value, want)
+	}
+	return false
+}
+
+func (p *pseudoSelector) match(n, scope *Node) bool {
+	switch p.name {
+	case "not":
+		return !p.selector.matchScoped(n, scope)
+	case "is":
+		return p.selector.matchScoped(n, scope)
+	case "has":
+		return p.matchHas(n)
+	case "empty":
+		for _, child := range n.Children {
+			if child.Type == NodeElement || (child.Type == NodeText && child.Content != "") {
+				return false
+			}
+		}
+		return true
+	case "scope":
+		return n == scope
+	case "nth":
+		return matchNth(p.a, p.b, siblingIndex(n, p.ofType, p.fromEnd))
+	case "only":
+		return siblingIndex(n, p.ofType, false) == 1 && siblingIndex(n, p.ofType, true) == 1
+	}
+	return false
+}
+
+// matchHas checks the relative selectors of :has() against the subtree and
+// following siblings of n, with n acting as the scoping element.
+func (p *pseudoSelector) matchHas(n *Node) bool {
+	matched := false
+	visit := func(candidate *Node) bool {
+		if p.selector.matchScoped(candidate, n) {
+			matched = true
+			return false
+		}
+		return true
+	}
+	walkDescendants(n, visit)
+	for sib := n.NextSibling; sib != nil && !matched; sib = sib.NextSibling {
+		if visit(sib) {
+			walkDescendants(sib, visit)
+		}
+	}
+	return matched
+}
+
+// siblingIndex returns the 1-based position of n among its element siblings.
+func siblingIndex(n *Node, ofType, fromEnd bool) int {
+	index := 1
+	next := prevElementSibling
+	if fromEnd {
+		next = nextElementSibling
+	}
+	for sib := next(n); sib != nil; sib = next(sib) {
+		if !ofType || strings.EqualFold(sib.TagName, n.TagName) {
+			index++
+		}
+	}
+	return index
+}
+
+// matchNth reports whether index == a*k + b for some k >= 0.
+func matchNth(a, b, index int) bool {
+	if a == 0 {
+		return index == b
+	}
+	diff := index - b
+	return diff/a >= 0 && diff%a == 0
+}
+
+func prevElementSibling(n *Node) *Node {
+	for sib := n.PrevSibling; sib != nil; sib = sib.PrevSibling {
+		if sib.Type == NodeElement {
+			return sib
+		}
+	}
+	return nil
+}
+
+func nextElementSibling(n *Node) *Node {
+	for sib := n.NextSibling; sib != nil; sib = sib.NextSibling {
+		if sib.Type == NodeElement {
+			return sib
+		}
+	}
+	return nil
+}
+
+/////////////
+// Parsing //
+/////////////
+
+type selectorParser struct {
+	input string
+	pos   int
+}
+
+func (p *selectorParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid selector %q at offset %d: %s", p.input, p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *selectorParser) peek() byte {
+	if p.pos >= len(p.input) {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *selectorParser) skipWhitespace() bool {
+	start := p.pos
+	for p.pos < len(p.input) && isSelectorSpace(p.input[p.pos]) {
+		p.pos++
+	}
+	return p.pos > start
+}
+
+// parseList parses a comma-separated selector list. Relative lists (inside
+// :has) may start each selector with a combinator.
+func (p *selectorParser) parseList(relative bool) ([]complexSelector, error) {
+	var list []complexSelector
+	for {
+		p.skipWhitespace()
+		c, err := p.parseComplex(relative)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+		p.skipWhitespace()
+		if p.peek() != ',' {
+			return list, nil
+		}
+		p.pos++
+	}
+}
+
+func (p *selectorParser) parseComplex(relative bool) (complexSelector, error) {
+	var c complexSelector
+	if relative {
+		comb := byte(' ')
+		if ch := p.peek(); ch == '>' || ch == '+' || ch == '~' {
+			comb = ch
+			p.pos++
+			p.skipWhitespace()
+		}
+		c.compounds = append(c.compounds, compoundSelector{scope: true})
+		c.combinators = append(c.combinators, comb)
+	}
+
+	for {
+		compound, err := p.parseCompound()
+		if err != nil {
+			return c, err
+		}
+		c.compounds = append(c.compounds, compound)
+
+		sawSpace := p.skipWhitespace()
+		ch := p.peek()
+		switch {
+		case ch == '>' || ch == '+' || ch == '~':
+			p.pos++
+			p.skipWhitespace()
+			c.combinators = append(c.combinators, ch)
+		case ch == 0 || ch == ',' || ch == ')':
+			return c, nil
+		case sawSpace:
+			c.combinators = append(c.combinators, ' ')
+		default:
+			return c, p.errorf("unexpected %q", ch)
+		}
+	}
+}
+
+func (p *selectorParser) parseCompound() (compoundSelector, error) {
+	var c compoundSelector
+	start := p.pos
+
+	if p.peek() == '*' {
+		p.pos++
+	} else if isIdentStart(p.peek()) {
+		name, err := p.parseIdent()
+		if err != nil {
+			return c, err
+		}
+		c.tag = name
+	}
+
+	for {
+		switch p.peek() {
+		case '#':
+			p.pos++
+			id, err := p.parseIdent()
+			if err != nil {
+				return c, err
+			}
+			c.attrs = append(c.attrs, attrSelector{name: "id", op: "=", value: id})
+		case '.':
+			p.pos++
+			class, err := p.parseIdent()
+			if err != nil {
+				return c, err
+			}
+			c.attrs = append(c.attrs, attrSelector{name: "class", op: "~=", value: class})
+		case '[':
+			attr, err := p.parseAttr()
+			if err != nil {
+				return c, err
+			}
+			c.attrs = append(c.attrs, attr)
+		case ':':
+			pseudo, err := p.parsePseudo()
+			if err != nil {
+				return c, err
+			}
+			c.pseudos = append(c.pseudos, pseudo)
+		default:
+			if p.pos == start {
+				if p.pos >= len(p.input) {
+					return c, p.errorf("unexpected end of selector")
+				}
+				return c, p.errorf("unexpected %q", p.input[p.pos])
+			}
+			return c, nil
+		}
+	}
+}
+
+func (p *selectorParser) parseAttr() (attrSelector, error) {
+	var a attrSelector
+	p.pos++ // Consume '['
+	p.skipWhitespace()
+
+	name, err := p.parseIdent()
+	if err != nil {
+		return a, err
+	}
+	a.name = name
+	p.skipWhitespace()
+
+	switch ch := p.peek(); ch {
+	case ']':
+		p.pos++
+		return a, nil
+	case '=':
+		a.op = "="
+		p.pos++
+	case '~', '|', '^', '$', '*':
+		if p.pos+1 >= len(p.input) || p.input[p.pos+1] != '=' {
+			return a, p.errorf("expected '=' after %q", ch)
+		}
+		a.op = string(ch) + "="
+		p.pos += 2
+	default:
+		return a, p.errorf("unexpected %q in attribute selector", ch)
+	}
+
+	p.skipWhitespace()
+	if ch := p.peek(); ch == '"' || ch == '\'' {
+		a.value, err = p.parseString()
+	} else {
+		a.value, err = p.parseIdent()
+	}
+	if err != nil {
+		return a, err
+	}
+
+	p.skipWhitespace()
+	if ch := p.peek(); ch == 'i' || ch == 'I' || ch == 's' || ch == 'S' {
+		a.foldCase = ch == 'i' || ch == 'I'
+		p.pos++
+		p.skipWhitespace()
+	}
+	if p.peek() != ']' {
+		return a, p.errorf("expected ']'")
+	}
+	p.pos++
+	return a, nil
+}
+
+func (p *selectorParser) parsePseudo() (pseudoSelector, error) {
+	var ps pseudoSelector
+	p.pos++ // Consume ':'
+	if p.peek() == ':' {
+		return ps, p.errorf("pseudo-elements are not supported")
+	}
+	name, err := p.parseIdent()
+	if err != nil {
+		return ps, err
+	}
+	name = strings.ToLower(name)
+
+	switch name {
+	case "first-child", "last-child", "first-of-type", "last-of-type":
+		ps.name, ps.b = "nth", 1
+		ps.ofType = strings.HasSuffix(name, "-of-type")
+		ps.fromEnd = strings.HasPrefix(name, "last-")
+		return ps, nil
+	case "only-child", "only-of-type":
+		ps.name = "only"
+		ps.ofType = name == "only-of-type"
+		return ps, nil
+	case "empty", "scope":
+		ps.name = name
+		return ps, nil
+	case "nth-child", "nth-last-child", "nth-of-type", "nth-last-of-type":
+		arg, err := p.parseArgument()
+		if err != nil {
+			return ps, err
+		}
+		ps.name = "nth"
+		ps.ofType = strings.HasSuffix(name, "-of-type")
+		ps.fromEnd = strings.HasPrefix(name, "nth-last-")
+		ps.a, ps.b, err = parseNth(arg)
+		if err != nil {
+			return ps, p.errorf("%v", err)
+		}
+		return ps, nil
+	case "not", "is", "has":
+		if p.peek() != '(' {
+			return ps, p.errorf("expected '(' after :%s", name)
+		}
+		p.pos++
+		start := p.pos
+		list, err := p.parseList(name == "has")
+		if err != nil {
+			return ps, err
+		}
+		p.skipWhitespace()
+		if p.peek() != ')' {
+			return ps, p.errorf("expected ')'")
+		}
+		ps.name = name
+		ps.selector = &Selector{source: p.input[start:p.pos], list: list}
+		p.pos++
+		return ps, nil
+	}
+	return ps, p.errorf("unsupported pseudo-class :%s", name)
+}
+
+// parseArgument returns the raw text between parentheses.
+func (p *selectorParser) parseArgument() (string, error) {
+	if p.peek() != '(' {
+		return "", p.errorf("expected '('")
+	}
+	end := strings.IndexByte(p.input[p.pos:], ')')
+	if end < 0 {
+		return "", p.errorf("expected ')'")
+	}
+	arg := p.input[p.pos+1 : p.pos+end]
+	p.pos += end + 1
+	return arg, nil
+}
+
+// parseNth parses the an+b microsyntax, including "odd" and "even".
+func parseNth(arg string) (a, b int, err error) {
+	s := strings.ToLower(strings.Join(strings.Fields(arg), ""))
+	switch s {
+	case "odd":
+		return 2, 1, nil
+	case "even":
+		return 2, 0, nil
+	}
+
+	n := strings.IndexByte(s, 'n')
+	if n < 0 {
+		b, err = strconv.Atoi(s)
+		if err != nil {
+			return 0, 0, fmt.Errorf("bad nth expression %q", arg)
+		}
+		return 0, b, nil
+	}
+
+	switch coef := s[:n]; coef {
+	case "", "+":
+		a = 1
+	case "-":
+		a = -1
+	default:
+		if a, err = strconv.Atoi(coef); err != nil {
+			return 0, 0, fmt.Errorf("bad nth expression %q", arg)
+		}
+	}
+	if rest := s[n+1:]; rest != "" {
+		if rest[0] != '+' && rest[0] != '-' {
+			return 0, 0, fmt.Errorf("bad nth expression %q", arg)
+		}
+		if b, err = strconv.Atoi(rest); err != nil {
+			return 0, 0, fmt.Errorf("bad nth expression %q", arg)
+		}
+	}
+	return a, b, nil
+}
+
+// parseIdent reads a CSS identifier, resolving backslash escapes.
+func (p *selectorParser) parseIdent() (string, error) {
+	var sb strings.Builder
+loop:
+	for p.pos < len(p.input) {
+		ch := p.input[p.pos]
+		switch {
+		case ch == '\\':
+			p.parseEscape(&sb)
+		case isIdentStart(ch) || isDigitChar(ch):
+			sb.WriteByte(ch)
+			p.pos++
+		default:
+			break loop
+		}
+	}
+	if sb.Len() == 0 {
+		if p.pos >= len(p.input) {
+			return "", p.errorf("expected identifier")
+		}
+		return "", p.errorf("expected identifier, got %q", p.input[p.pos])
+	}
+	return sb.String(), nil
+}
+
+// parseString reads a single- or double-quoted string.
+func (p *selectorParser) parseString() (string, error) {
+	quote := p.input[p.pos]
+	p.pos++
+	var sb strings.Builder
+	for p.pos < len(p.input) {
+		ch := p.input[p.pos]
+		switch {
+		case ch == quote:
+			p.pos++
+			return sb.String(), nil
+		case ch == '\\':
+			p.parseEscape(&sb)
+		default:
+			sb.WriteByte(ch)
+			p.pos++
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+// parseEscape handles "\XX " hex escapes and "\c" literal escapes.
+func (p *selectorParser) parseEscape(sb *strings.Builder) {
+	p.pos++ // Consume '\'
+	start := p.pos
+	for p.pos < len(p.input) && p.pos-start < 6 && isHexDigit(p.input[p.pos]) {
+		p.pos++
+	}
+	if p.pos > start {
+		code, _ := strconv.ParseUint(p.input[start:p.pos], 16, 32)
+		if code == 0 || code > utf8.MaxRune {
+			code = utf8.RuneError
+		}
+		sb.WriteRune(rune(code))
+		if p.pos < len(p.input) && isSelectorSpace(p.input[p.pos]) {
+			p.pos++
+		}
+		return
+	}
+	if p.pos < len(p.input) {
+		_, size := utf8.DecodeRuneInString(p.input[p.pos:])
+		sb.WriteString(p.input[p.pos : p.pos+size])
+		p.pos += size
+	}
+}
+
+func isIdentStart(ch byte) bool {
+	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || ch == '_' || ch == '-' || ch == '\\' || ch >= 0x80
+}
+
+func isDigitChar(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+func isHexDigit(ch byte) bool {
+	return isDigitChar(ch) || (ch >= 'a' && ch <= 'f') || (ch >= 'A' && ch <= 'F')
+}
+
+func isSelectorSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' || ch == '\f'
+}
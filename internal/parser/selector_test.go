@@ -0,0 +1,138 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+const selectorTestHTML = `<div id="main" class="content wide">
+<h1 lang="en-US">Title</h1>
+<p class="intro">First</p>
+<p>Second <a href="https://example.com/page.pdf" rel="nofollow external">link</a></p>
+<ul>
+<li data-id="1">one</li>
+<li data-id="2" class="sel">two</li>
+<li data-id="3">three</li>
+<li data-id="4">four</li>
+</ul>
+<table><tr><td>a</td><td>b</td></tr></table>
+<span></span>
+</div>
+<p id="footer">Footer</p>`
+
+// describe renders matched nodes as "tag" or "tag#id" or "tag[data-id]" for compact comparisons.
+func describe(nodes []*Node) string {
+	var parts []string
+	for _, n := range nodes {
+		d := n.TagName
+		if id, ok := n.Attr("id"); ok {
+			d += "#" + id
+		} else if id, ok := n.Attr("data-id"); ok {
+			d += "[" + id + "]"
+		} else if len(n.Children) > 0 && n.Children[0].Type == NodeText {
+			d += "(" + n.Children[0].Content + ")"
+		}
+		parts = append(parts, d)
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestSelectorQueryAll(t *testing.T) {
+	root := New(selectorTestHTML).Parse()
+
+	tests := []struct {
+		selector string
+		expected string
+	}{
+		{"p", "p(First) p(Second ) p#footer"},
+		{"*#main", "div#main"},
+		{"#main", "div#main"},
+		{".intro", "p(First)"},
+		{"div.content.wide", "div#main"},
+		{"DIV.content", "div#main"},
+		{"[href]", "a(link)"},
+		{"[data-id='2']", "li[2]"},
+		{"[href^=https]", "a(link)"},
+		{`[href$=".pdf"]`, "a(link)"},
+		{"[href*=example]", "a(link)"},
+		{"[rel~=external]", "a(link)"},
+		{"[lang|=en]", "h1(Title)"},
+		{"[class=INTRO i]", "p(First)"},
+		{"div p", "p(First) p(Second )"},
+		{"div > p a", "a(link)"},
+		{"h1 + p", "p(First)"},
+		{"h1 ~ p", "p(First) p(Second )"},
+		{"li.sel ~ li", "li[3] li[4]"},
+		{"h1, #footer", "h1(Title) p#footer"},
+		{"li:first-child", "li[1]"},
+		{"li:last-child", "li[4]"},
+		{"li:nth-child(2n+1)", "li[1] li[3]"},
+		{"li:nth-child(even)", "li[2] li[4]"},
+		{"li:nth-child(-n+2)", "li[1] li[2]"},
+		{"li:nth-last-child(1)", "li[4]"},
+		{"p:nth-of-type(2)", "p(Second )"},
+		{"p:first-of-type", "p(First) p#footer"},
+		{"td:only-child", ""},
+		{"table > tbody > tr > td", "td(a) td(b)"},
+		{"a:only-child", "a(link)"},
+		{"li:not(.sel):not([data-id='4'])", "li[1] li[3]"},
+		{"p:has(a)", "p(Second )"},
+		{"div:has(> table)", "div#main"},
+		{"div:has(> td)", ""},
+		{"h1:has(+ p.intro)", "h1(Title)"},
+		{"li:is(.sel, [data-id='3'])", "li[2] li[3]"},
+		{"span:empty", "span"},
+		{"#missing", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			nodes, err := root.QueryAll(tt.selector)
+			if err != nil {
+				t.Fatalf("selector %q - unexpected error: %v", tt.selector, err)
+			}
+			if got := describe(nodes); got != tt.expected {
+				t.Fatalf("selector %q - expected=%q, got=%q", tt.selector, tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestSelectorReuse(t *testing.T) {
+	sel := MustCompileSelector("td:nth-child(2)")
+
+	first := New(`<table><tr><td>a</td><td>b</td></tr></table>`).Parse()
+	second := New(`<table><tr><td>c</td><td>d</td><td>e</td></tr></table>`).Parse()
+
+	if got := describe(sel.QueryAll(first.Node)); got != "td(b)" {
+		t.Fatalf("first document - expected=%q, got=%q", "td(b)", got)
+	}
+	if got := sel.Query(second.Node); got == nil || got.Children[0].Content != "d" {
+		t.Fatalf("second document - expected td(d), got %v", got)
+	}
+	if !sel.Match(sel.Query(second.Node)) {
+		t.Fatalf("Match returned false for a node found by Query")
+	}
+}
+
+func TestSelectorErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"div >",
+		"p[",
+		"[a=]",
+		"a:unknown",
+		"li:nth-child(x)",
+		"p::before",
+		"div, ",
+		":not(p",
+	}
+
+	for _, selector := range tests {
+		t.Run(selector, func(t *testing.T) {
+			if _, err := CompileSelector(selector); err == nil {
+				t.Fatalf("selector %q - expected an error", selector)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package parser
+
+import (
+	"strings"
+)
+
+// Text returns the concatenated content of all descendant text nodes, as the
+// DOM textContent property does. Markup is ignored and no separators are added.
+func (n *Node) Text() string {
+	switch n.Type {
+	case NodeText, NodeComment, NodeAttribute:
+		return n.Content
+	}
+	var sb strings.Builder
+	walkDescendants(n, func(d *Node) bool {
+		if d.Type == NodeText {
+			sb.WriteString(d.Content)
+		}
+		return true
+	})
+	return sb.String()
+}
+
+// InnerText returns the text of the node roughly as a browser renders it:
+// whitespace is collapsed, block elements and <br> start new lines,
+// paragraphs are separated by a blank line, table cells are separated by
+// tabs and rows by newlines. Contents of script, style, template and noscript
+// are skipped, and whitespace inside <pre> is kept as is.
+func (n *Node) InnerText() string {
+	if n.Type != NodeElement && n.Type != NodeDocument {
+		return n.Text()
+	}
+	b := &innerTextBuilder{}
+	for _, child := range n.Children {
+		b.walk(child)
+	}
+	return b.sb.String()
+}
+
+// blockElements start and end on their own line in InnerText.
+var blockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true,
+	"body": true, "caption": true, "center": true, "dd": true, "details": true,
+	"dialog": true, "dir": true, "div": true, "dl": true, "dt": true,
+	"fieldset": true, "figcaption": true, "figure": true, "footer": true,
+	"form": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true,
+	"h6": true, "header": true, "hgroup": true, "hr": true, "html": true,
+	"legend": true, "li": true, "listing": true, "main": true, "menu": true,
+	"nav": true, "ol": true, "p": true, "pre": true, "section": true,
+	"summary": true, "table": true, "tr": true, "ul": true, "xmp": true,
+}
+
+type innerTextBuilder struct {
+	sb            strings.Builder
+	pendingBreaks int  // Line breaks required before the next text
+	pendingSpace  bool // Collapsed whitespace seen since the last word
+	preDepth      int  // Inside <pre> and similar, whitespace is kept
+}
+
+func (b *innerTextBuilder) walk(n *Node) {
+	switch n.Type {
+	case NodeText:
+		if b.preDepth > 0 {
+			b.writeLiteral(n.Content)
+		} else {
+			b.writeCollapsed(n.Content)
+		}
+		return
+	case NodeElement:
+	default:
+		return
+	}
+
+	tag := strings.ToLower(n.TagName)
+	switch tag {
+	case "script", "style", "template", "noscript":
+		return
+	case "br":
+		b.writeLiteral("\n")
+		return
+	}
+
+	breaks := 0
+	if blockElements[tag] {
+		breaks = 1
+		if tag == "p" {
+			breaks = 2
+		}
+	}
+	pre := tag == "pre" || tag == "textarea" || tag == "listing" || tag == "plaintext" || tag == "xmp"
+
+	b.requireBreaks(breaks)
+	if pre {
+		b.preDepth++
+	}
+	for _, child := range n.Children {
+		b.walk(child)
+	}
+	if pre {
+		b.preDepth--
+	}
+	b.requireBreaks(breaks)
+
+	if (tag == "td" || tag == "th") && nextCell(n) != nil {
+		b.writeLiteral("\t")
+	}
+}
+
+// nextCell returns the next td or th sibling of a cell.
+func nextCell(n *Node) *Node {
+	for sib := nextElementSibling(n); sib != nil; sib = nextElementSibling(sib) {
+		if tag := strings.ToLower(sib.TagName); tag == "td" || tag == "th" {
+			return sib
+		}
+	}
+	return nil
+}
+
+func (b *innerTextBuilder) requireBreaks(count int) {
+	if count > b.pendingBreaks {
+		b.pendingBreaks = count
+	}
+	if count > 0 {
+		b.pendingSpace = false
+	}
+}
+
+// flushBreaks writes the required line breaks, unless nothing has been
+// written yet: leading and trailing breaks are dropped.
+func (b *innerTextBuilder) flushBreaks() {
+	if b.sb.Len() > 0 {
+		for i := 0; i < b.pendingBreaks; i++ {
+			b.sb.WriteByte('\n')
+		}
+	}
+	b.pendingBreaks = 0
+}
+
+func (b *innerTextBuilder) writeLiteral(s string) {
+	if s == "" {
+		return
+	}
+	b.flushBreaks()
+	b.pendingSpace = false
+	b.sb.WriteString(s)
+}
+
+// writeCollapsed writes text with runs of ASCII whitespace collapsed to a
+// single space, dropping spaces at the start and end of lines.
+func (b *innerTextBuilder) writeCollapsed(s string) {
+	for len(s) > 0 {
+		if isHTMLSpace(s[0]) {
+			b.pendingSpace = true
+			s = s[1:]
+			continue
+		}
+		end := 0
+		for end < len(s) && !isHTMLSpace(s[end]) {
+			end++
+		}
+		if b.pendingBreaks > 0 {
+			b.flushBreaks()
+		} else if b.pendingSpace && b.sb.Len() > 0 {
+			if last := b.sb.String()[b.sb.Len()-1]; last != '\n' && last != '\t' {
+				b.sb.WriteByte(' ')
+			}
+		}
+		b.pendingSpace = false
+		b.sb.WriteString(s[:end])
+		s = s[end:]
+	}
+}
+
+func isHTMLSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' || ch == '\f'
+}
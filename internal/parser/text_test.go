@@ -0,0 +1,85 @@
+package parser
+
+import (
+	"testing"
+)
+
+func TestText(t *testing.T) {
+	root := New(`<div>Hello <b>big</b>
+<p>world</p><script>x()</script><!-- c --></div>`).Parse()
+
+	if got, expected := root.FindByTag("div")[0].Text(), "Hello big\nworldx()"; got != expected {
+		t.Fatalf("Text - expected=%q, got=%q", expected, got)
+	}
+	if got, expected := root.FindByTag("b")[0].Children[0].Text(), "big"; got != expected {
+		t.Fatalf("Text of text node - expected=%q, got=%q", expected, got)
+	}
+}
+
+func TestInnerText(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "Collapse Whitespace",
+			input:    "<div>  Hello \n\t  <b>big</b>   world  </div>",
+			expected: "Hello big world",
+		},
+		{
+			name:     "Paragraphs",
+			input:    "<div><p>One</p><p>Two</p>Three</div>",
+			expected: "One\n\nTwo\n\nThree",
+		},
+		{
+			name:     "Block Elements",
+			input:    "<div><h1>Title</h1><div>a</div><div><div>b</div></div><ul><li>x</li><li>y</li></ul></div>",
+			expected: "Title\na\nb\nx\ny",
+		},
+		{
+			name:     "Line Breaks",
+			input:    "<div>a<br>b<br><br>c <br> d</div>",
+			expected: "a\nb\n\nc\nd",
+		},
+		{
+			name:     "Inline Elements Are Not Glued",
+			input:    "<div><span>a</span> <span>b</span><span>c</span></div>",
+			expected: "a bc",
+		},
+		{
+			name:     "Table Cells",
+			input:    "<table><tr><th>Area</th><th>Time</th></tr><tr><td> Zemun </td><td>08:00</td></tr><tr><td>Vračar</td><td></td></tr></table>",
+			expected: "Area\tTime\nZemun\t08:00\nVračar\t",
+		},
+		{
+			name:     "Skipped Elements",
+			input:    "<div>a<script>var x = 1;</script><style>p {}</style><template><p>t</p></template>b</div>",
+			expected: "ab",
+		},
+		{
+			name:     "Preformatted",
+			input:    "<div>x  y<pre>  a\n    b  </pre>z</div>",
+			expected: "x y\n  a\n    b  \nz",
+		},
+		{
+			name:     "Non-Breaking Space Is Kept",
+			input:    "<div>a&nbsp;&nbsp;b</div>",
+			expected: "a\u00a0\u00a0b",
+		},
+		{
+			name:     "Comments Are Ignored",
+			input:    "<div>a<!-- hidden -->b</div>",
+			expected: "ab",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := New(tt.input).Parse()
+			if got := root.InnerText(); got != tt.expected {
+				t.Fatalf("Test '%s' - expected=%q, got=%q", tt.name, tt.expected, got)
+			}
+		})
+	}
+}
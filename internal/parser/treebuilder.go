@@ -0,0 +1,801 @@
+package parser
+
+import (
+	"strings"
+
+	"github.com/rsolovyeaws/go-html-parser/internal/lexer"
+)
+
+// This file holds the state shared by the insertion modes in modes.go: the
+// stack of open elements, the list of active formatting elements and the
+// algorithms of the HTML5 tree construction stage that work on them.
+
+// insertionMode selects how tokens are handled, depending on where in the
+// document the parser is.
+type insertionMode int
+
+const (
+	initialMode insertionMode = iota
+	beforeHTMLMode
+	beforeHeadMode
+	inHeadMode
+	inHeadNoscriptMode
+	afterHeadMode
+	inBodyMode
+	textMode
+	inTableMode
+	inCaptionMode
+	inColumnGroupMode
+	inTableBodyMode
+	inRowMode
+	inCellMode
+	inSelectMode
+	inSelectInTableMode
+	inTemplateMode
+	afterBodyMode
+	inFramesetMode
+	afterFramesetMode
+	afterAfterBodyMode
+	afterAfterFramesetMode
+)
+
+// modeHandlers handle the current token in each insertion mode. A handler
+// returns false if the token must be reprocessed, usually after switching to
+// another mode.
+var modeHandlers = [...]func(p *Parser) bool{
+	initialMode:            initialIM,
+	beforeHTMLMode:         beforeHTMLIM,
+	beforeHeadMode:         beforeHeadIM,
+	inHeadMode:             inHeadIM,
+	inHeadNoscriptMode:     inHeadNoscriptIM,
+	afterHeadMode:          afterHeadIM,
+	inBodyMode:             inBodyIM,
+	textMode:               textIM,
+	inTableMode:            inTableIM,
+	inCaptionMode:          inCaptionIM,
+	inColumnGroupMode:      inColumnGroupIM,
+	inTableBodyMode:        inTableBodyIM,
+	inRowMode:              inRowIM,
+	inCellMode:             inCellIM,
+	inSelectMode:           inSelectIM,
+	inSelectInTableMode:    inSelectInTableIM,
+	inTemplateMode:         inTemplateIM,
+	afterBodyMode:          afterBodyIM,
+	inFramesetMode:         inFramesetIM,
+	afterFramesetMode:      afterFramesetIM,
+	afterAfterBodyMode:     afterAfterBodyIM,
+	afterAfterFramesetMode: afterAfterFramesetIM,
+}
+
+// Element scopes, see "has an element in scope" in the HTML5 specification.
+type scope int
+
+const (
+	defaultScope scope = iota
+	listItemScope
+	buttonScope
+	tableScope
+	selectScope
+)
+
+// specialElements are the HTML elements the specification puts in the
+// "special" category. They are never reconstructed or adopted.
+var specialElements = map[string]bool{
+	"address": true, "applet": true, "area": true, "article": true, "aside": true,
+	"base": true, "basefont": true, "bgsound": true, "blockquote": true, "body": true,
+	"br": true, "button": true, "caption": true, "center": true, "col": true,
+	"colgroup": true, "dd": true, "details": true, "dir": true, "div": true, "dl": true,
+	"dt": true, "embed": true, "fieldset": true, "figcaption": true, "figure": true,
+	"footer": true, "form": true, "frame": true, "frameset": true, "h1": true,
+	"h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "head": true,
+	"header": true, "hgroup": true, "hr": true, "html": true, "iframe": true,
+	"img": true, "input": true, "keygen": true, "li": true, "link": true,
+	"listing": true, "main": true, "marquee": true, "menu": true, "meta": true,
+	"nav": true, "noembed": true, "noframes": true, "noscript": true, "object": true,
+	"ol": true, "p": true, "param": true, "plaintext": true, "pre": true,
+	"script": true, "search": true, "section": true, "select": true, "source": true,
+	"style": true, "summary": true, "table": true, "tbody": true, "td": true,
+	"template": true, "textarea": true, "tfoot": true, "th": true, "thead": true,
+	"title": true, "tr": true, "track": true, "ul": true, "wbr": true, "xmp": true,
+}
+
+// isSpecial reports whether n is in the special category, which also has the
+// SVG and MathML elements that contain HTML.
+func isSpecial(n *Node) bool {
+	if n.Namespace == "" {
+		return specialElements[nameOf(n)]
+	}
+	return isMathMLTextIntegrationPoint(n) || isElement(n, NamespaceMathML, "annotation-xml") ||
+		isElement(n, NamespaceSVG, "foreignobject", "desc", "title")
+}
+
+// nameOf returns the lower-cased name of an element. The lexer lower-cases
+// tag names, so HTML names are used as they are, but SVG names such as
+// clipPath get their case back in the tree.
+func nameOf(n *Node) string {
+	if n.Namespace == "" {
+		return n.TagName
+	}
+	return strings.ToLower(n.TagName)
+}
+
+// isOneOf reports whether n is an HTML element with one of the given names.
+func isOneOf(n *Node, names ...string) bool {
+	return isElement(n, "", names...)
+}
+
+// isElement reports whether n is an element in the given namespace with one
+// of the given lower-cased names.
+func isElement(n *Node, namespace string, names ...string) bool {
+	if n.Type != NodeElement || n.Namespace != namespace {
+		return false
+	}
+	name := nameOf(n)
+	for _, candidate := range names {
+		if name == candidate {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenTag returns the tag name of the current token, lower-cased by the lexer.
+func (p *Parser) tokenTag() string {
+	return p.curr.Value
+}
+
+/////////////////////////////
+// Stack of open elements  //
+/////////////////////////////
+
+func (p *Parser) currentNode() *Node {
+	if len(p.oe) == 0 {
+		return p.root
+	}
+	return p.oe[len(p.oe)-1]
+}
+
+// adjustedCurrentNode is the current node, except that the context element
+// stands in for the html root while parsing a fragment.
+func (p *Parser) adjustedCurrentNode() *Node {
+	if p.context != nil && len(p.oe) == 1 {
+		return p.context
+	}
+	return p.currentNode()
+}
+
+// closeSpan ends the span of an element leaving the stack of open elements.
+// An element closed by its own end tag spans to the end of that tag; one
+// closed implicitly ends where the token that closed it starts.
+func (p *Parser) closeSpan(n *Node) {
+	if p.curr.Type == lexer.TokenEndTag && strings.EqualFold(p.curr.Value, n.TagName) {
+		n.Span.End = p.curr.Span.End
+	} else {
+		n.Span.End = p.curr.Span.Start
+	}
+}
+
+// push opens n by pushing it onto the stack of open elements.
+func (p *Parser) push(n *Node) {
+	p.oe = append(p.oe, n)
+	if isOneOf(n, "p") {
+		p.openP++
+	}
+}
+
+func (p *Parser) pop() *Node {
+	n := p.oe[len(p.oe)-1]
+	p.oe = p.oe[:len(p.oe)-1]
+	if isOneOf(n, "p") {
+		p.openP--
+	}
+	p.closeSpan(n)
+	return n
+}
+
+// popUntil pops elements until one with one of the given names is popped.
+func (p *Parser) popUntil(names ...string) {
+	for len(p.oe) > 0 {
+		if isOneOf(p.pop(), names...) {
+			return
+		}
+	}
+}
+
+// popUntilNode pops elements until n is popped.
+func (p *Parser) popUntilNode(n *Node) {
+	for len(p.oe) > 0 {
+		if p.pop() == n {
+			return
+		}
+	}
+}
+
+func indexOf(list []*Node, n *Node) int {
+	for i := len(list) - 1; i >= 0; i-- {
+		if list[i] == n {
+			return i
+		}
+	}
+	return -1
+}
+
+// removeFromStack removes n from the stack of open elements, wherever it is.
+func (p *Parser) removeFromStack(n *Node) {
+	if i := indexOf(p.oe, n); i >= 0 {
+		p.removeFromStackAt(i)
+	}
+}
+
+// removeFromStackAt removes the element at index i of the stack of open
+// elements.
+func (p *Parser) removeFromStackAt(i int) {
+	if isOneOf(p.oe[i], "p") {
+		p.openP--
+	}
+	p.oe = append(p.oe[:i], p.oe[i+1:]...)
+}
+
+// isOnStack reports whether an HTML element with the given name is open.
+func (p *Parser) isOnStack(name string) bool {
+	for _, n := range p.oe {
+		if isOneOf(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// inScope reports whether an element with one of the given names is in the
+// given scope.
+func (p *Parser) inScope(s scope, names ...string) bool {
+	for i := len(p.oe) - 1; i >= 0; i-- {
+		n := p.oe[i]
+		if isOneOf(n, names...) {
+			return true
+		}
+		if isScopeBoundary(s, n) {
+			return false
+		}
+	}
+	return false
+}
+
+// isNodeInScope is inScope for a specific element.
+func (p *Parser) isNodeInScope(s scope, target *Node) bool {
+	for i := len(p.oe) - 1; i >= 0; i-- {
+		n := p.oe[i]
+		if n == target {
+			return true
+		}
+		if isScopeBoundary(s, n) {
+			return false
+		}
+	}
+	return false
+}
+
+func isScopeBoundary(s scope, n *Node) bool {
+	if n.Namespace != "" {
+		// Foreign elements are boundaries of the select scope too
+		return s == selectScope || s != tableScope && isSpecial(n)
+	}
+	name := nameOf(n)
+	switch s {
+	case tableScope:
+		return name == "html" || name == "table" || name == "template"
+	case selectScope:
+		return name != "optgroup" && name != "option"
+	}
+	switch name {
+	case "applet", "caption", "html", "table", "td", "th", "marquee", "object", "template":
+		return true
+	case "ol", "ul":
+		return s == listItemScope
+	case "button":
+		return s == buttonScope
+	}
+	return false
+}
+
+// generateImpliedEndTags pops elements whose end tag may be omitted, except
+// those named in except.
+func (p *Parser) generateImpliedEndTags(except ...string) {
+	for len(p.oe) > 0 {
+		n := p.currentNode()
+		if isOneOf(n, except...) ||
+			!isOneOf(n, "dd", "dt", "li", "optgroup", "option", "p", "rb", "rp", "rt", "rtc") {
+			return
+		}
+		p.pop()
+	}
+}
+
+// closesP are the start tags that close a p element in the in body insertion
+// mode. A table does so only outside quirks mode.
+var closesP = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "center": true,
+	"dd": true, "details": true, "dialog": true, "dir": true, "div": true, "dl": true,
+	"dt": true, "fieldset": true, "figcaption": true, "figure": true, "footer": true,
+	"form": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"header": true, "hgroup": true, "hr": true, "li": true, "listing": true, "main": true,
+	"menu": true, "nav": true, "ol": true, "p": true, "plaintext": true, "pre": true,
+	"search": true, "section": true, "summary": true, "table": true, "ul": true, "xmp": true,
+}
+
+// closeP closes a p element that is in button scope, as most block-level
+// start tags do. Counting the open p elements saves searching the whole
+// stack for one on every such tag, which is slow for deeply nested documents.
+func (p *Parser) closeP() {
+	if p.openP > 0 && p.inScope(buttonScope, "p") {
+		p.generateImpliedEndTags("p")
+		p.expectCurrent("p")
+		p.popUntil("p")
+	}
+}
+
+// generateAllImpliedEndTags also pops open table parts, as </template> does.
+func (p *Parser) generateAllImpliedEndTags() {
+	for len(p.oe) > 0 && isOneOf(p.currentNode(), "caption", "colgroup", "dd", "dt", "li",
+		"optgroup", "option", "p", "rb", "rp", "rt", "rtc", "tbody", "td", "tfoot", "th", "thead", "tr") {
+		p.pop()
+	}
+}
+
+// clearStackToContext pops elements until the current node is one of the
+// given elements or html.
+func (p *Parser) clearStackToContext(names ...string) {
+	for len(p.oe) > 0 {
+		n := p.currentNode()
+		if isOneOf(n, names...) || isOneOf(n, "html") {
+			return
+		}
+		p.pop()
+	}
+}
+
+/////////////////////////////
+// Inserting nodes         //
+/////////////////////////////
+
+// insertionPlace returns where a node inserted into target ends up: the
+// parent to insert into and the child to insert before, nil to append. Content
+// that is misplaced in a table is foster parented to just before the table.
+func (p *Parser) insertionPlace(target *Node) (parent, before *Node) {
+	if p.fosterParenting && isOneOf(target, "table", "tbody", "tfoot", "thead", "tr") {
+		target, before = p.fosterPlace()
+	}
+	return contentOf(target), before
+}
+
+// fosterPlace returns where foster parented content goes: before the last
+// open table, or into a template opened inside it.
+func (p *Parser) fosterPlace() (parent, before *Node) {
+	for i := len(p.oe) - 1; i > 0; i-- {
+		n := p.oe[i]
+		if isOneOf(n, "template") {
+			return n, nil
+		}
+		if !isOneOf(n, "table") {
+			continue
+		}
+		if n.Parent != nil {
+			return n.Parent, n
+		}
+		return p.oe[i-1], nil
+	}
+	return p.oe[0], nil
+}
+
+// contentOf returns the node that children inserted into n go to: the
+// contents of a template element, or n itself.
+func contentOf(n *Node) *Node {
+	if n.TemplateContent != nil {
+		return n.TemplateContent
+	}
+	return n
+}
+
+// insertNode inserts n at the appropriate place for inserting a node, which
+// is the end of the current node unless it is foster parented.
+func (p *Parser) insertNode(n *Node) {
+	parent, before := p.insertionPlace(p.currentNode())
+	insertChild(parent, n, before)
+}
+
+// insertChild inserts a detached child into parent just before ref, or at the
+// end for a nil ref. Unlike InsertBefore it does not check the ancestors of
+// parent, which would make deeply nested documents slow to parse.
+func insertChild(parent, child, ref *Node) {
+	if ref == nil {
+		appendChild(parent, child)
+		return
+	}
+	parent.insertAt(child, parent.childIndex(ref))
+}
+
+// insertElement inserts an element for the current start tag and pushes it
+// onto the stack of open elements.
+func (p *Parser) insertElement() *Node {
+	n := p.parseElement("")
+	p.insertNode(n)
+	p.push(n)
+	return n
+}
+
+// insertVoid inserts an element for the current start tag without opening it.
+func (p *Parser) insertVoid() *Node {
+	n := p.parseElement("")
+	p.insertNode(n)
+	return n
+}
+
+// insertImplied inserts and opens an element whose start tag was omitted from
+// the source. Its span starts at the current token.
+func (p *Parser) insertImplied(name string) *Node {
+	n := &Node{
+		Type:     NodeElement,
+		TagName:  name,
+		Children: []*Node{},
+		Span:     lexer.Span{Start: p.curr.Span.Start},
+	}
+	p.insertNode(n)
+	p.push(n)
+	return n
+}
+
+// insertText adds text at the appropriate place for inserting a node,
+// merging it with a preceding text node.
+func (p *Parser) insertText(text string, span lexer.Span) {
+	parent, before := p.insertionPlace(p.currentNode())
+	prev := parent.LastChildNode()
+	if before != nil {
+		prev = before.PrevSibling
+	}
+	if prev != nil && prev.Type == NodeText {
+		prev.Content += text
+		prev.Span.End = span.End
+		return
+	}
+	insertChild(parent, &Node{Type: NodeText, Content: text, Span: span}, before)
+}
+
+func (p *Parser) insertComment(parent *Node) {
+	appendChild(contentOf(parent), &Node{
+		Type:    NodeComment,
+		Content: p.curr.Value,
+		Span:    p.curr.Span,
+	})
+}
+
+// insertRawText inserts the current start tag as an element whose contents
+// the lexer reads as raw text, and switches to the text insertion mode.
+func (p *Parser) insertRawText() {
+	p.insertElement()
+	p.lexer.SetRawTextElement(p.tokenTag())
+	p.originalMode = p.mode
+	p.mode = textMode
+}
+
+// addMissingAttributes copies attributes of the current token onto n unless
+// n already has them, as a repeated <html> or <body> tag does.
+func (p *Parser) addMissingAttributes(n *Node) {
+	for _, a := range p.curr.Attributes {
+		if !n.HasAttr(a.Name) {
+			n.Attributes = append(n.Attributes, a)
+		}
+	}
+}
+
+// splitSpace removes leading whitespace from the current text token and
+// returns it with its span. The token keeps the rest of the text.
+func (p *Parser) splitSpace() (string, lexer.Span) {
+	text := p.curr.Value
+	i := 0
+	for i < len(text) && isHTMLSpace(text[i]) {
+		i++
+	}
+	span := lexer.Span{Start: p.curr.Span.Start, End: advancePos(p.curr.Span.Start, text[:i])}
+	p.curr.Value = text[i:]
+	p.curr.Span.Start = span.End
+	return text[:i], span
+}
+
+// advancePos moves pos past the text s.
+func advancePos(pos lexer.Pos, s string) lexer.Pos {
+	for i := 0; i < len(s); i++ {
+		pos.Offset++
+		if s[i] == '\n' {
+			pos.Line++
+			pos.Column = 1
+		} else if s[i]&0xC0 != 0x80 {
+			pos.Column++
+		}
+	}
+	return pos
+}
+
+func isAllSpace(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isHTMLSpace(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+/////////////////////////////////////
+// List of active formatting elements //
+/////////////////////////////////////
+
+// pushFormatting adds n to the list of active formatting elements. At most
+// three identical elements are kept after the last marker.
+func (p *Parser) pushFormatting(n *Node) {
+	identical := 0
+	for i := len(p.afe) - 1; i >= 0 && p.afe[i] != nil; i-- {
+		if sameElement(p.afe[i], n) {
+			identical++
+			if identical == 3 {
+				p.afe = append(p.afe[:i], p.afe[i+1:]...)
+				break
+			}
+		}
+	}
+	p.afe = append(p.afe, n)
+}
+
+// sameElement reports whether a and b have the same name and attributes, in
+// any order.
+func sameElement(a, b *Node) bool {
+	if nameOf(a) != nameOf(b) || len(a.Attributes) != len(b.Attributes) {
+		return false
+	}
+	for _, attr := range a.Attributes {
+		if other, ok := b.Attr(attr.Name); !ok || other != attr.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// insertMarker adds a scope marker, a nil entry, to the list of active
+// formatting elements.
+func (p *Parser) insertMarker() {
+	p.afe = append(p.afe, nil)
+}
+
+// clearFormattingToMarker removes entries up to and including the last marker.
+func (p *Parser) clearFormattingToMarker() {
+	for len(p.afe) > 0 {
+		n := p.afe[len(p.afe)-1]
+		p.afe = p.afe[:len(p.afe)-1]
+		if n == nil {
+			return
+		}
+	}
+}
+
+// activeFormatting returns the last active formatting element with the given
+// name after the last marker.
+func (p *Parser) activeFormatting(name string) *Node {
+	for i := len(p.afe) - 1; i >= 0 && p.afe[i] != nil; i-- {
+		if nameOf(p.afe[i]) == name {
+			return p.afe[i]
+		}
+	}
+	return nil
+}
+
+func (p *Parser) removeFormatting(n *Node) {
+	if i := indexOf(p.afe, n); i >= 0 {
+		p.afe = append(p.afe[:i], p.afe[i+1:]...)
+	}
+}
+
+// reconstructFormatting reopens formatting elements that were closed
+// implicitly, so that in "<b>1<p>2" the text "2" is bold too.
+func (p *Parser) reconstructFormatting() {
+	if len(p.afe) == 0 {
+		return
+	}
+	i := len(p.afe) - 1
+	if n := p.afe[i]; n == nil || indexOf(p.oe, n) >= 0 {
+		return
+	}
+	for i > 0 {
+		if n := p.afe[i-1]; n == nil || indexOf(p.oe, n) >= 0 {
+			break
+		}
+		i--
+	}
+	for ; i < len(p.afe); i++ {
+		clone := p.afe[i].Clone(false)
+		clone.Span = lexer.Span{Start: p.curr.Span.Start}
+		p.insertNode(clone)
+		p.push(clone)
+		p.afe[i] = clone
+	}
+}
+
+// adoptionAgency runs the adoption agency algorithm for the end tag of a
+// formatting element, which fixes up misnested markup such as
+// "<b>1<p>2</b>3</p>". It returns false if the end tag must be handled as any
+// other end tag instead.
+func (p *Parser) adoptionAgency(name string) bool {
+	if current := p.currentNode(); isOneOf(current, name) && indexOf(p.afe, current) < 0 {
+		p.pop()
+		return true
+	}
+
+	for outer := 0; outer < 8; outer++ {
+		formatting := p.activeFormatting(name)
+		if formatting == nil {
+			return false
+		}
+		feIndex := indexOf(p.oe, formatting)
+		if feIndex < 0 {
+			p.parseError("unexpected-end-tag")
+			p.removeFormatting(formatting)
+			return true
+		}
+		if !p.isNodeInScope(defaultScope, formatting) {
+			p.parseError("unexpected-end-tag")
+			return true
+		}
+		if outer == 0 && formatting != p.currentNode() {
+			p.parseError("end-tag-too-early")
+		}
+
+		// The furthest block is the topmost special element below the
+		// formatting element
+		var furthestBlock *Node
+		fbIndex := -1
+		for i := feIndex + 1; i < len(p.oe); i++ {
+			if isSpecial(p.oe[i]) {
+				furthestBlock, fbIndex = p.oe[i], i
+				break
+			}
+		}
+		if furthestBlock == nil {
+			p.popUntilNode(formatting)
+			p.removeFormatting(formatting)
+			return true
+		}
+
+		commonAncestor := p.oe[feIndex-1]
+		bookmark := indexOf(p.afe, formatting)
+
+		node, lastNode := furthestBlock, furthestBlock
+		nodeIndex := fbIndex
+		for inner := 1; ; inner++ {
+			nodeIndex--
+			node = p.oe[nodeIndex]
+			if node == formatting {
+				break
+			}
+			afeIndex := indexOf(p.afe, node)
+			if inner > 3 && afeIndex >= 0 {
+				p.afe = append(p.afe[:afeIndex], p.afe[afeIndex+1:]...)
+				if afeIndex < bookmark {
+					bookmark--
+				}
+				afeIndex = -1
+			}
+			if afeIndex < 0 {
+				p.removeFromStackAt(nodeIndex)
+				continue
+			}
+
+			clone := node.Clone(false)
+			p.afe[afeIndex] = clone
+			p.oe[nodeIndex] = clone
+			node = clone
+			if lastNode == furthestBlock {
+				bookmark = afeIndex + 1
+			}
+			lastNode.Detach()
+			appendChild(node, lastNode)
+			lastNode = node
+		}
+
+		lastNode.Detach()
+		parent, before := p.insertionPlace(commonAncestor)
+		insertChild(parent, lastNode, before)
+
+		clone := formatting.Clone(false)
+		for len(furthestBlock.Children) > 0 {
+			child := furthestBlock.Children[0]
+			furthestBlock.RemoveChild(child)
+			appendChild(clone, child)
+		}
+		appendChild(furthestBlock, clone)
+
+		if i := indexOf(p.afe, formatting); i >= 0 {
+			p.afe = append(p.afe[:i], p.afe[i+1:]...)
+			if i < bookmark {
+				bookmark--
+			}
+		}
+		p.afe = append(p.afe, nil)
+		copy(p.afe[bookmark+1:], p.afe[bookmark:])
+		p.afe[bookmark] = clone
+
+		p.removeFromStack(formatting)
+		p.closeSpan(formatting)
+		fbIndex = indexOf(p.oe, furthestBlock)
+		p.oe = append(p.oe, nil)
+		copy(p.oe[fbIndex+2:], p.oe[fbIndex+1:])
+		p.oe[fbIndex+1] = clone
+	}
+	return true
+}
+
+// resetInsertionMode picks the insertion mode from the stack of open
+// elements, after the stack was changed in a way that may leave a table or
+// select. When parsing a fragment the context element takes the place of the
+// html root.
+func (p *Parser) resetInsertionMode() {
+	for i := len(p.oe) - 1; i >= 0; i-- {
+		n := p.oe[i]
+		last := i == 0
+		if last && p.context != nil {
+			n = p.context
+		}
+		if n.Namespace != "" {
+			continue
+		}
+		switch nameOf(n) {
+		case "select":
+			for j := i - 1; j > 0; j-- {
+				if nameOf(p.oe[j]) == "table" {
+					p.mode = inSelectInTableMode
+					return
+				}
+			}
+			p.mode = inSelectMode
+			return
+		case "td", "th":
+			if !last {
+				p.mode = inCellMode
+				return
+			}
+		case "tr":
+			p.mode = inRowMode
+			return
+		case "tbody", "thead", "tfoot":
+			p.mode = inTableBodyMode
+			return
+		case "caption":
+			p.mode = inCaptionMode
+			return
+		case "colgroup":
+			p.mode = inColumnGroupMode
+			return
+		case "table":
+			p.mode = inTableMode
+			return
+		case "template":
+			p.mode = p.templateModes[len(p.templateModes)-1]
+			return
+		case "head":
+			if !last {
+				p.mode = inHeadMode
+				return
+			}
+		case "body":
+			p.mode = inBodyMode
+			return
+		case "frameset":
+			p.mode = inFramesetMode
+			return
+		case "html":
+			if p.head == nil {
+				p.mode = beforeHeadMode
+			} else {
+				p.mode = afterHeadMode
+			}
+			return
+		}
+	}
+	p.mode = inBodyMode
+}
@@ -1,19 +1,24 @@
 package parser
 
-import "html"
-
-// DecodeEntities decodes HTML entities using Go's built-in function.
-func DecodeEntities(input string) string {
-	return html.UnescapeString(input)
-}
+import "strings"
 
 func isVoidElement(tagName string) bool {
 	// List of void elements in HTML
 	voidElements := map[string]bool{
-		"area": true, "base": true, "br": true, "col": true,
-		"embed": true, "hr": true, "img": true, "input": true,
-		"link": true, "meta": true, "source": true, "track": true,
-		"wbr": true,
+		"area": true, "base": true, "basefont": true, "bgsound": true,
+		"br": true, "col": true, "embed": true, "frame": true, "hr": true,
+		"img": true, "input": true, "keygen": true, "link": true, "meta": true,
+		"param": true, "source": true, "track": true, "wbr": true,
 	}
 	return voidElements[tagName]
 }
+
+// isRawTextElement reports whether the element's text content is kept
+// verbatim. textarea and title (RCDATA) still have their entities decoded.
+func isRawTextElement(tagName string) bool {
+	switch strings.ToLower(tagName) {
+	case "script", "style", "xmp", "iframe", "noembed", "noframes", "plaintext":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,1383 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// XPathType is the type of value an XPath expression evaluates to.
+type XPathType string
+
+const (
+	XPathNodeSet XPathType = "NodeSet"
+	XPathString  XPathType = "String"
+	XPathNumber  XPathType = "Number"
+	XPathBoolean XPathType = "Boolean"
+)
+
+// XPath is a compiled XPath 1.0 expression. Compile it once with
+// CompileXPath and evaluate it against any number of documents.
+type XPath struct {
+	source string
+	expr   xpathExpr
+}
+
+// XPathResult is the value of an evaluated expression. Nodes is only set for
+// node sets; String, Number and Bool convert any result with the XPath
+// string(), number() and boolean() rules.
+type XPathResult struct {
+	Type  XPathType
+	Nodes []*Node // In document order
+	value interface{}
+}
+
+func (r XPathResult) String() string  { return xpathString(r.value) }
+func (r XPathResult) Number() float64 { return xpathNumber(r.value) }
+func (r XPathResult) Bool() bool      { return xpathBoolean(r.value) }
+
+// CompileXPath parses an XPath 1.0 expression such as "//tr[td[1]='x']/td[2]".
+func CompileXPath(expr string) (*XPath, error) {
+	tokens, err := tokenizeXPath(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &xpathParser{source: expr, tokens: tokens}
+	e, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != xtEOF {
+		return nil, p.errorf(tok, "unexpected %q", tok.value)
+	}
+	return &XPath{source: expr, expr: e}, nil
+}
+
+// MustCompileXPath is like CompileXPath but panics if the expression cannot be
+// parsed. It is meant for expressions that are constants.
+func MustCompileXPath(expr string) *XPath {
+	x, err := CompileXPath(expr)
+	if err != nil {
+		panic(err)
+	}
+	return x
+}
+
+// String returns the source text of the expression.
+func (x *XPath) String() string {
+	return x.source
+}
+
+// Evaluate evaluates the expression with context as the context node.
+func (x *XPath) Evaluate(context *Node) (XPathResult, error) {
+	root := context
+	for root.Parent != nil {
+		root = root.Parent
+	}
+	c := &xpathContext{node: context, position: 1, size: 1, doc: &xpathDocument{root: root}}
+
+	v, err := x.expr.eval(c)
+	if err != nil {
+		return XPathResult{}, fmt.Errorf("xpath %q: %v", x.source, err)
+	}
+
+	switch v := v.(type) {
+	case []*Node:
+		return XPathResult{Type: XPathNodeSet, Nodes: v, value: v}, nil
+	case string:
+		return XPathResult{Type: XPathString, value: v}, nil
+	case float64:
+		return XPathResult{Type: XPathNumber, value: v}, nil
+	default:
+		return XPathResult{Type: XPathBoolean, value: v}, nil
+	}
+}
+
+// Select evaluates an expression that must produce a node set.
+func (x *XPath) Select(context *Node) ([]*Node, error) {
+	r, err := x.Evaluate(context)
+	if err != nil {
+		return nil, err
+	}
+	if r.Type != XPathNodeSet {
+		return nil, fmt.Errorf("xpath %q: result is a %s, not a node set", x.source, strings.ToLower(string(r.Type)))
+	}
+	return r.Nodes, nil
+}
+
+// EvaluateXPath compiles and evaluates an XPath expression with n as the
+// context node.
+func (n *Node) EvaluateXPath(expr string) (XPathResult, error) {
+	x, err := CompileXPath(expr)
+	if err != nil {
+		return XPathResult{}, err
+	}
+	return x.Evaluate(n)
+}
+
+// SelectXPath returns the nodes selected by an XPath expression evaluated
+// with n as the context node.
+func (n *Node) SelectXPath(expr string) ([]*Node, error) {
+	x, err := CompileXPath(expr)
+	if err != nil {
+		return nil, err
+	}
+	return x.Select(n)
+}
+
+/////////////////
+// Evaluation  //
+/////////////////
+
+type xpathContext struct {
+	node     *Node
+	position int
+	size     int
+	doc      *xpathDocument
+}
+
+// xpathDocument holds state shared by one evaluation: attribute nodes are
+// created on demand and must keep their identity, and node sets are sorted
+// by document order.
+type xpathDocument struct {
+	root       *Node
+	attrNodes  map[*Node][]*Node
+	order      map[*Node]int
+	attrOrders map[*Node]int
+}
+
+func (c *xpathContext) with(n *Node, position, size int) *xpathContext {
+	return &xpathContext{node: n, position: position, size: size, doc: c.doc}
+}
+
+// attributes returns the attribute nodes of an element in source order.
+func (d *xpathDocument) attributes(n *Node) []*Node {
+	if n.Type != NodeElement || len(n.Attributes) == 0 {
+		return nil
+	}
+	if d.attrNodes == nil {
+		d.attrNodes = make(map[*Node][]*Node)
+		d.attrOrders = make(map[*Node]int)
+	}
+	if attrs, ok := d.attrNodes[n]; ok {
+		return attrs
+	}
+
+	attrs := make([]*Node, len(n.Attributes))
+	for i, a := range n.Attributes {
+		attrs[i] = &Node{Type: NodeAttribute, TagName: a.Name, Content: a.Value, Parent: n}
+		d.attrOrders[attrs[i]] = i + 1
+	}
+	d.attrNodes[n] = attrs
+	return attrs
+}
+
+// sortNodes sorts a node set into document order and removes duplicates.
+func (d *xpathDocument) sortNodes(nodes []*Node) []*Node {
+	if len(nodes) < 2 {
+		return nodes
+	}
+	if d.order == nil {
+		d.order = make(map[*Node]int)
+		i := 0
+		d.order[d.root] = i
+		walkDescendants(d.root, func(n *Node) bool {
+			i++
+			d.order[n] = i
+			return true
+		})
+	}
+
+	// Attributes sort after their owner element and before its children
+	key := func(n *Node) (int, int) {
+		if n.Type == NodeAttribute {
+			return d.order[n.Parent], d.attrOrders[n]
+		}
+		return d.order[n], 0
+	}
+
+	seen := make(map[*Node]bool, len(nodes))
+	unique := nodes[:0:0]
+	for _, n := range nodes {
+		if !seen[n] {
+			seen[n] = true
+			unique = append(unique, n)
+		}
+	}
+	sort.SliceStable(unique, func(i, j int) bool {
+		a1, a2 := key(unique[i])
+		b1, b2 := key(unique[j])
+		return a1 < b1 || (a1 == b1 && a2 < b2)
+	})
+	return unique
+}
+
+type xpathExpr interface {
+	eval(c *xpathContext) (interface{}, error)
+}
+
+type literalExpr struct {
+	value interface{} // string or float64
+}
+
+func (e *literalExpr) eval(c *xpathContext) (interface{}, error) {
+	return e.value, nil
+}
+
+type negateExpr struct {
+	expr xpathExpr
+}
+
+func (e *negateExpr) eval(c *xpathContext) (interface{}, error) {
+	v, err := e.expr.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	return -xpathNumber(v), nil
+}
+
+type binaryExpr struct {
+	op          string
+	left, right xpathExpr
+}
+
+func (e *binaryExpr) eval(c *xpathContext) (interface{}, error) {
+	left, err := e.left.eval(c)
+	if err != nil {
+		return nil, err
+	}
+
+	// and/or short-circuit
+	switch e.op {
+	case "and":
+		if !xpathBoolean(left) {
+			return false, nil
+		}
+	case "or":
+		if xpathBoolean(left) {
+			return true, nil
+		}
+	}
+
+	right, err := e.right.eval(c)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.op {
+	case "and", "or":
+		return xpathBoolean(right), nil
+	case "|":
+		l, lok := left.([]*Node)
+		r, rok := right.([]*Node)
+		if !lok || !rok {
+			return nil, fmt.Errorf("operands of '|' must be node sets")
+		}
+		return c.doc.sortNodes(append(append([]*Node{}, l...), r...)), nil
+	case "=", "!=", "<", "<=", ">", ">=":
+		return compareValues(e.op, left, right), nil
+	}
+
+	l, r := xpathNumber(left), xpathNumber(right)
+	switch e.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "div":
+		return l / r, nil
+	default: // mod
+		return math.Mod(l, r), nil
+	}
+}
+
+// compareValues implements the XPath comparison rules, where a node set
+// compares true if any of its nodes does.
+func compareValues(op string, left, right interface{}) bool {
+	if l, ok := left.([]*Node); ok {
+		if _, isBool := right.(bool); isBool {
+			return compareValues(op, len(l) > 0, right)
+		}
+		for _, n := range l {
+			if compareValues(op, stringValue(n), right) {
+				return true
+			}
+		}
+		return false
+	}
+	if r, ok := right.([]*Node); ok {
+		if _, isBool := left.(bool); isBool {
+			return compareValues(op, left, len(r) > 0)
+		}
+		for _, n := range r {
+			if compareValues(op, left, stringValue(n)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if op == "=" || op == "!=" {
+		var equal bool
+		_, lbool := left.(bool)
+		_, rbool := right.(bool)
+		_, lnum := left.(float64)
+		_, rnum := right.(float64)
+		switch {
+		case lbool || rbool:
+			equal = xpathBoolean(left) == xpathBoolean(right)
+		case lnum || rnum:
+			equal = xpathNumber(left) == xpathNumber(right)
+		default:
+			equal = xpathString(left) == xpathString(right)
+		}
+		return equal == (op == "=")
+	}
+
+	l, r := xpathNumber(left), xpathNumber(right)
+	switch op {
+	case "<":
+		return l < r
+	case "<=":
+		return l <= r
+	case ">":
+		return l > r
+	default:
+		return l >= r
+	}
+}
+
+type filterExpr struct {
+	primary    xpathExpr
+	predicates []xpathExpr
+}
+
+func (e *filterExpr) eval(c *xpathContext) (interface{}, error) {
+	v, err := e.primary.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	nodes, ok := v.([]*Node)
+	if !ok {
+		return nil, fmt.Errorf("predicates can only filter node sets")
+	}
+	for _, pred := range e.predicates {
+		if nodes, err = applyPredicate(c, pred, nodes); err != nil {
+			return nil, err
+		}
+	}
+	return nodes, nil
+}
+
+// pathExpr is a location path, optionally starting from a filter expression
+// ("(//a)[1]/b") or from the root ("/html/body").
+type pathExpr struct {
+	filter   xpathExpr
+	absolute bool
+	steps    []*xpathStep
+}
+
+func (e *pathExpr) eval(c *xpathContext) (interface{}, error) {
+	var nodes []*Node
+	switch {
+	case e.filter != nil:
+		v, err := e.filter.eval(c)
+		if err != nil {
+			return nil, err
+		}
+		ns, ok := v.([]*Node)
+		if !ok {
+			return nil, fmt.Errorf("path steps can only follow a node set")
+		}
+		nodes = ns
+	case e.absolute:
+		nodes = []*Node{c.doc.root}
+	default:
+		nodes = []*Node{c.node}
+	}
+
+	for _, step := range e.steps {
+		var result []*Node
+		for _, n := range nodes {
+			selected, err := step.apply(c, n)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, selected...)
+		}
+		nodes = c.doc.sortNodes(result)
+	}
+	return nodes, nil
+}
+
+type xpathStep struct {
+	axis       string
+	test       nodeTest
+	predicates []xpathExpr
+}
+
+type nodeTest struct {
+	kind string // "name", "node", "text", "comment" or "processing-instruction"
+	name string // For name tests: "*", "prefix:*" or a name
+}
+
+// apply selects the nodes on the step's axis from n, in axis order, and
+// filters them through the node test and predicates.
+func (s *xpathStep) apply(c *xpathContext, n *Node) ([]*Node, error) {
+	var nodes []*Node
+	forEachOnAxis(c.doc, s.axis, n, func(candidate *Node) {
+		if s.test.match(candidate, s.axis == "attribute") {
+			nodes = append(nodes, candidate)
+		}
+	})
+
+	var err error
+	for _, pred := range s.predicates {
+		if nodes, err = applyPredicate(c, pred, nodes); err != nil {
+			return nil, err
+		}
+	}
+	return nodes, nil
+}
+
+func applyPredicate(c *xpathContext, pred xpathExpr, nodes []*Node) ([]*Node, error) {
+	var kept []*Node
+	for i, n := range nodes {
+		v, err := pred.eval(c.with(n, i+1, len(nodes)))
+		if err != nil {
+			return nil, err
+		}
+		if num, ok := v.(float64); ok {
+			if num == float64(i+1) {
+				kept = append(kept, n)
+			}
+		} else if xpathBoolean(v) {
+			kept = append(kept, n)
+		}
+	}
+	return kept, nil
+}
+
+func (t *nodeTest) match(n *Node, attributeAxis bool) bool {
+	switch t.kind {
+	case "node":
+		// Doctypes are not part of the XPath data model
+		return n.Type != NodeDoctype
+	case "text":
+		return n.Type == NodeText
+	case "comment":
+		return n.Type == NodeComment
+	case "processing-instruction":
+		return false
+	}
+
+	// Name tests select the principal node type of the axis
+	if attributeAxis {
+		if n.Type != NodeAttribute {
+			return false
+		}
+	} else if n.Type != NodeElement {
+		return false
+	}
+	switch {
+	case t.name == "*":
+		return true
+	case strings.HasSuffix(t.name, ":*"):
+		return len(n.TagName) > len(t.name)-1 && strings.EqualFold(n.TagName[:len(t.name)-1], t.name[:len(t.name)-1])
+	}
+	return strings.EqualFold(n.TagName, t.name)
+}
+
+// forEachOnAxis calls visit for each node on the axis from n, in axis order:
+// reverse document order for the reverse axes.
+func forEachOnAxis(d *xpathDocument, axis string, n *Node, visit func(*Node)) {
+	each := func(nodes []*Node) {
+		for _, child := range nodes {
+			visit(child)
+		}
+	}
+	descendants := func(n *Node) {
+		walkDescendants(n, func(d *Node) bool {
+			visit(d)
+			return true
+		})
+	}
+
+	switch axis {
+	case "self":
+		visit(n)
+	case "child":
+		each(n.Children)
+	case "attribute":
+		each(d.attributes(n))
+	case "descendant":
+		descendants(n)
+	case "descendant-or-self":
+		visit(n)
+		descendants(n)
+	case "parent":
+		if n.Parent != nil {
+			visit(n.Parent)
+		}
+	case "ancestor", "ancestor-or-self":
+		if axis == "ancestor-or-self" {
+			visit(n)
+		}
+		for a := n.Parent; a != nil; a = a.Parent {
+			visit(a)
+		}
+	case "following-sibling":
+		if n.Type != NodeAttribute {
+			for sib := n.NextSibling; sib != nil; sib = sib.NextSibling {
+				visit(sib)
+			}
+		}
+	case "preceding-sibling":
+		if n.Type != NodeAttribute {
+			for sib := n.PrevSibling; sib != nil; sib = sib.PrevSibling {
+				visit(sib)
+			}
+		}
+	case "following":
+		if n.Type == NodeAttribute {
+			// The owner's children come after its attributes
+			n = n.Parent
+			descendants(n)
+		}
+		for a := n; a != nil; a = a.Parent {
+			for sib := a.NextSibling; sib != nil; sib = sib.NextSibling {
+				visit(sib)
+				descendants(sib)
+			}
+		}
+	case "preceding":
+		if n.Type == NodeAttribute {
+			n = n.Parent
+		}
+		for a := n; a != nil; a = a.Parent {
+			for sib := a.PrevSibling; sib != nil; sib = sib.PrevSibling {
+				visitReverse(sib, visit)
+			}
+		}
+	}
+}
+
+// visitReverse visits a subtree in reverse document order.
+func visitReverse(n *Node, visit func(*Node)) {
+	for i := len(n.Children) - 1; i >= 0; i-- {
+		visitReverse(n.Children[i], visit)
+	}
+	visit(n)
+}
+
+////////////////
+// Conversion //
+////////////////
+
+// stringValue returns the XPath string-value of a node: the concatenated text
+// of all descendant text nodes for elements.
+func stringValue(n *Node) string {
+	return n.Text()
+}
+
+func xpathString(v interface{}) string {
+	switch v := v.(type) {
+	case []*Node:
+		if len(v) == 0 {
+			return ""
+		}
+		return stringValue(v[0])
+	case string:
+		return v
+	case float64:
+		return formatXPathNumber(v)
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	}
+	return ""
+}
+
+func xpathNumber(v interface{}) float64 {
+	switch v := v.(type) {
+	case float64:
+		return v
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	default:
+		return parseXPathNumber(xpathString(v))
+	}
+}
+
+func xpathBoolean(v interface{}) bool {
+	switch v := v.(type) {
+	case []*Node:
+		return len(v) > 0
+	case string:
+		return v != ""
+	case float64:
+		return v != 0 && !math.IsNaN(v)
+	case bool:
+		return v
+	}
+	return false
+}
+
+// parseXPathNumber accepts only the XPath Number syntax with optional
+// surrounding whitespace and a leading minus; anything else is NaN.
+func parseXPathNumber(s string) float64 {
+	s = strings.Trim(s, " \t\r\n")
+	digits := strings.TrimPrefix(s, "-")
+	if digits == "" || digits == "." {
+		return math.NaN()
+	}
+	dot := false
+	for i := 0; i < len(digits); i++ {
+		switch {
+		case digits[i] == '.' && !dot:
+			dot = true
+		case digits[i] < '0' || digits[i] > '9':
+			return math.NaN()
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return f
+}
+
+func formatXPathNumber(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	case f == 0:
+		return "0"
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+///////////////
+// Functions //
+///////////////
+
+type xpathFunc struct {
+	minArgs, maxArgs int // maxArgs < 0 means any number
+	call             func(c *xpathContext, args []interface{}) (interface{}, error)
+}
+
+type functionExpr struct {
+	name string
+	fn   xpathFunc
+	args []xpathExpr
+}
+
+func (e *functionExpr) eval(c *xpathContext) (interface{}, error) {
+	args := make([]interface{}, len(e.args))
+	for i, arg := range e.args {
+		v, err := arg.eval(c)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	v, err := e.fn.call(c, args)
+	if err != nil {
+		return nil, fmt.Errorf("%s(): %v", e.name, err)
+	}
+	return v, nil
+}
+
+var xpathFunctions = map[string]xpathFunc{
+	"last":     {0, 0, func(c *xpathContext, args []interface{}) (interface{}, error) { return float64(c.size), nil }},
+	"position": {0, 0, func(c *xpathContext, args []interface{}) (interface{}, error) { return float64(c.position), nil }},
+	"count": {1, 1, func(c *xpathContext, args []interface{}) (interface{}, error) {
+		nodes, err := nodeSetArg(args[0])
+		return float64(len(nodes)), err
+	}},
+	"id": {1, 1, xpathID},
+	"local-name": {0, 1, func(c *xpathContext, args []interface{}) (interface{}, error) {
+		n, err := firstNodeArg(c, args)
+		if err != nil || n == nil {
+			return "", err
+		}
+		name := n.TagName
+		if i := strings.IndexByte(name, ':'); i >= 0 {
+			name = name[i+1:]
+		}
+		return name, nil
+	}},
+	"name": {0, 1, func(c *xpathContext, args []interface{}) (interface{}, error) {
+		n, err := firstNodeArg(c, args)
+		if err != nil || n == nil {
+			return "", err
+		}
+		if n.Type != NodeElement && n.Type != NodeAttribute {
+			return "", nil
+		}
+		return n.TagName, nil
+	}},
+	"namespace-uri": {0, 1, func(c *xpathContext, args []interface{}) (interface{}, error) {
+		_, err := firstNodeArg(c, args)
+		return "", err
+	}},
+	"string": {0, 1, func(c *xpathContext, args []interface{}) (interface{}, error) {
+		return xpathString(contextArg(c, args)), nil
+	}},
+	"concat": {2, -1, func(c *xpathContext, args []interface{}) (interface{}, error) {
+		var sb strings.Builder
+		for _, arg := range args {
+			sb.WriteString(xpathString(arg))
+		}
+		return sb.String(), nil
+	}},
+	"starts-with": {2, 2, func(c *xpathContext, args []interface{}) (interface{}, error) {
+		return strings.HasPrefix(xpathString(args[0]), xpathString(args[1])), nil
+	}},
+	"contains": {2, 2, func(c *xpathContext, args []interface{}) (interface{}, error) {
+		return strings.Contains(xpathString(args[0]), xpathString(args[1])), nil
+	}},
+	"substring-before": {2, 2, func(c *xpathContext, args []interface{}) (interface{}, error) {
+		s, sep := xpathString(args[0]), xpathString(args[1])
+		if i := strings.Index(s, sep); i >= 0 {
+			return s[:i], nil
+		}
+		return "", nil
+	}},
+	"substring-after": {2, 2, func(c *xpathContext, args []interface{}) (interface{}, error) {
+		s, sep := xpathString(args[0]), xpathString(args[1])
+		if i := strings.Index(s, sep); i >= 0 {
+			return s[i+len(sep):], nil
+		}
+		return "", nil
+	}},
+	"substring": {2, 3, xpathSubstring},
+	"string-length": {0, 1, func(c *xpathContext, args []interface{}) (interface{}, error) {
+		return float64(utf8.RuneCountInString(xpathString(contextArg(c, args)))), nil
+	}},
+	"normalize-space": {0, 1, func(c *xpathContext, args []interface{}) (interface{}, error) {
+		return strings.Join(strings.Fields(xpathString(contextArg(c, args))), " "), nil
+	}},
+	"translate": {3, 3, xpathTranslate},
+	"boolean": {1, 1, func(c *xpathContext, args []interface{}) (interface{}, error) {
+		return xpathBoolean(args[0]), nil
+	}},
+	"not": {1, 1, func(c *xpathContext, args []interface{}) (interface{}, error) {
+		return !xpathBoolean(args[0]), nil
+	}},
+	"true":  {0, 0, func(c *xpathContext, args []interface{}) (interface{}, error) { return true, nil }},
+	"false": {0, 0, func(c *xpathContext, args []interface{}) (interface{}, error) { return false, nil }},
+	"lang":  {1, 1, xpathLang},
+	"number": {0, 1, func(c *xpathContext, args []interface{}) (interface{}, error) {
+		return xpathNumber(contextArg(c, args)), nil
+	}},
+	"sum": {1, 1, func(c *xpathContext, args []interface{}) (interface{}, error) {
+		nodes, err := nodeSetArg(args[0])
+		total := 0.0
+		for _, n := range nodes {
+			total += parseXPathNumber(stringValue(n))
+		}
+		return total, err
+	}},
+	"floor": {1, 1, func(c *xpathContext, args []interface{}) (interface{}, error) {
+		return math.Floor(xpathNumber(args[0])), nil
+	}},
+	"ceiling": {1, 1, func(c *xpathContext, args []interface{}) (interface{}, error) {
+		return math.Ceil(xpathNumber(args[0])), nil
+	}},
+	"round": {1, 1, func(c *xpathContext, args []interface{}) (interface{}, error) {
+		return xpathRound(xpathNumber(args[0])), nil
+	}},
+}
+
+// contextArg returns the single optional argument, defaulting to a node set
+// containing the context node.
+func contextArg(c *xpathContext, args []interface{}) interface{} {
+	if len(args) == 0 {
+		return []*Node{c.node}
+	}
+	return args[0]
+}
+
+func nodeSetArg(arg interface{}) ([]*Node, error) {
+	nodes, ok := arg.([]*Node)
+	if !ok {
+		return nil, fmt.Errorf("argument must be a node set")
+	}
+	return nodes, nil
+}
+
+func firstNodeArg(c *xpathContext, args []interface{}) (*Node, error) {
+	nodes, err := nodeSetArg(contextArg(c, args))
+	if err != nil || len(nodes) == 0 {
+		return nil, err
+	}
+	return nodes[0], nil
+}
+
+func xpathRound(f float64) float64 {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return f
+	}
+	if f < 0 && f >= -0.5 {
+		return math.Copysign(0, -1)
+	}
+	return math.Floor(f + 0.5)
+}
+
+func xpathSubstring(c *xpathContext, args []interface{}) (interface{}, error) {
+	runes := []rune(xpathString(args[0]))
+	start := xpathRound(xpathNumber(args[1]))
+	end := math.Inf(1)
+	if len(args) == 3 {
+		end = start + xpathRound(xpathNumber(args[2]))
+	}
+
+	var sb strings.Builder
+	for i, r := range runes {
+		pos := float64(i + 1)
+		if pos >= start && pos < end {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String(), nil
+}
+
+func xpathTranslate(c *xpathContext, args []interface{}) (interface{}, error) {
+	from, to := []rune(xpathString(args[1])), []rune(xpathString(args[2]))
+	mapping := make(map[rune]rune, len(from))
+	for i, r := range from {
+		if _, ok := mapping[r]; ok {
+			continue // The first occurrence wins
+		}
+		if i < len(to) {
+			mapping[r] = to[i]
+		} else {
+			mapping[r] = -1
+		}
+	}
+	return strings.Map(func(r rune) rune {
+		if m, ok := mapping[r]; ok {
+			return m
+		}
+		return r
+	}, xpathString(args[0])), nil
+}
+
+// xpathID selects elements by whitespace-separated id tokens.
+func xpathID(c *xpathContext, args []interface{}) (interface{}, error) {
+	var ids []string
+	if nodes, ok := args[0].([]*Node); ok {
+		for _, n := range nodes {
+			ids = append(ids, strings.Fields(stringValue(n))...)
+		}
+	} else {
+		ids = strings.Fields(xpathString(args[0]))
+	}
+
+	var result []*Node
+	for _, id := range ids {
+		if n := c.doc.root.FindByID(id); n != nil {
+			result = append(result, n)
+		}
+	}
+	return c.doc.sortNodes(result), nil
+}
+
+// xpathLang checks the nearest lang attribute of the context node.
+func xpathLang(c *xpathContext, args []interface{}) (interface{}, error) {
+	want := strings.ToLower(xpathString(args[0]))
+	for n := c.node; n != nil; n = n.Parent {
+		if lang, ok := n.Attr("lang"); ok {
+			lang = strings.ToLower(lang)
+			return lang == want || strings.HasPrefix(lang, want+"-"), nil
+		}
+	}
+	return false, nil
+}
+
+//////////////
+// Lexing   //
+//////////////
+
+type xpathTokenKind int
+
+const (
+	xtEOF xpathTokenKind = iota
+	xtName
+	xtNumber
+	xtLiteral
+	xtSymbol // Operators and punctuation, including "and", "or", "div" and "mod"
+)
+
+type xpathToken struct {
+	kind  xpathTokenKind
+	value string
+	pos   int
+}
+
+// tokenizeXPath splits an expression into tokens, resolving whether "*" and
+// names like "div" are operators from the preceding token as the XPath 1.0
+// lexical rules require.
+func tokenizeXPath(expr string) ([]xpathToken, error) {
+	var tokens []xpathToken
+	pos := 0
+
+	operatorAllowed := func() bool {
+		if len(tokens) == 0 {
+			return false
+		}
+		prev := tokens[len(tokens)-1]
+		if prev.kind != xtSymbol {
+			return true
+		}
+		switch prev.value {
+		case ")", "]", ".", "..":
+			return true
+		}
+		return false
+	}
+
+	for {
+		for pos < len(expr) && isSelectorSpace(expr[pos]) {
+			pos++
+		}
+		if pos >= len(expr) {
+			tokens = append(tokens, xpathToken{kind: xtEOF, pos: pos})
+			return tokens, nil
+		}
+
+		start := pos
+		ch := expr[pos]
+		switch {
+		case ch == '"' || ch == '\'':
+			end := strings.IndexByte(expr[pos+1:], ch)
+			if end < 0 {
+				return nil, fmt.Errorf("invalid xpath %q at offset %d: unterminated string", expr, pos)
+			}
+			tokens = append(tokens, xpathToken{xtLiteral, expr[pos+1 : pos+1+end], start})
+			pos += end + 2
+
+		case isDigitChar(ch) || (ch == '.' && pos+1 < len(expr) && isDigitChar(expr[pos+1])):
+			for pos < len(expr) && isDigitChar(expr[pos]) {
+				pos++
+			}
+			if pos < len(expr) && expr[pos] == '.' {
+				pos++
+				for pos < len(expr) && isDigitChar(expr[pos]) {
+					pos++
+				}
+			}
+			tokens = append(tokens, xpathToken{xtNumber, expr[start:pos], start})
+
+		case ch == '*':
+			pos++
+			kind := xtName
+			if operatorAllowed() {
+				kind = xtSymbol
+			}
+			tokens = append(tokens, xpathToken{kind, "*", start})
+
+		case isNameStartChar(ch):
+			pos = scanXPathName(expr, pos)
+			// A prefixed name or prefix:*, but not an axis "name::"
+			if pos+1 < len(expr) && expr[pos] == ':' && expr[pos+1] != ':' {
+				if expr[pos+1] == '*' {
+					pos += 2
+				} else if isNameStartChar(expr[pos+1]) {
+					pos = scanXPathName(expr, pos+1)
+				}
+			}
+			name := expr[start:pos]
+			kind := xtName
+			if operatorAllowed() {
+				if name != "and" && name != "or" && name != "div" && name != "mod" {
+					return nil, fmt.Errorf("invalid xpath %q at offset %d: expected an operator, got %q", expr, start, name)
+				}
+				kind = xtSymbol
+			}
+			tokens = append(tokens, xpathToken{kind, name, start})
+
+		default:
+			sym := ""
+			for _, s := range []string{"::", "//", "..", "!=", "<=", ">=", "(", ")", "[", "]", ".", "@", ",", "/", "|", "+", "-", "=", "<", ">", "$"} {
+				if strings.HasPrefix(expr[pos:], s) {
+					sym = s
+					break
+				}
+			}
+			if sym == "" {
+				return nil, fmt.Errorf("invalid xpath %q at offset %d: unexpected %q", expr, pos, ch)
+			}
+			if sym == "$" {
+				return nil, fmt.Errorf("invalid xpath %q at offset %d: variables are not supported", expr, pos)
+			}
+			pos += len(sym)
+			tokens = append(tokens, xpathToken{xtSymbol, sym, start})
+		}
+	}
+}
+
+func scanXPathName(expr string, pos int) int {
+	for pos < len(expr) && (isNameStartChar(expr[pos]) || isDigitChar(expr[pos]) || expr[pos] == '-' || expr[pos] == '.') {
+		pos++
+	}
+	return pos
+}
+
+func isNameStartChar(ch byte) bool {
+	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || ch == '_' || ch >= 0x80
+}
+
+/////////////
+// Parsing //
+/////////////
+
+type xpathParser struct {
+	source string
+	tokens []xpathToken
+	pos    int
+}
+
+var xpathAxes = map[string]bool{
+	"ancestor": true, "ancestor-or-self": true, "attribute": true, "child": true,
+	"descendant": true, "descendant-or-self": true, "following": true,
+	"following-sibling": true, "namespace": true, "parent": true,
+	"preceding": true, "preceding-sibling": true, "self": true,
+}
+
+func (p *xpathParser) errorf(tok xpathToken, format string, args ...interface{}) error {
+	return fmt.Errorf("invalid xpath %q at offset %d: %s", p.source, tok.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *xpathParser) peek() xpathToken {
+	return p.tokens[p.pos]
+}
+
+func (p *xpathParser) peekAt(offset int) xpathToken {
+	if p.pos+offset >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.pos+offset]
+}
+
+func (p *xpathParser) next() xpathToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != xtEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// acceptSymbol consumes the next token if it is one of the given symbols.
+func (p *xpathParser) acceptSymbol(symbols ...string) (string, bool) {
+	tok := p.peek()
+	if tok.kind != xtSymbol {
+		return "", false
+	}
+	for _, s := range symbols {
+		if tok.value == s {
+			p.pos++
+			return s, true
+		}
+	}
+	return "", false
+}
+
+func (p *xpathParser) expectSymbol(symbol string) error {
+	if _, ok := p.acceptSymbol(symbol); !ok {
+		tok := p.peek()
+		if tok.kind == xtEOF {
+			return p.errorf(tok, "expected %q, got end of expression", symbol)
+		}
+		return p.errorf(tok, "expected %q, got %q", symbol, tok.value)
+	}
+	return nil
+}
+
+func (p *xpathParser) parseExpr() (xpathExpr, error) {
+	return p.parseBinary(0)
+}
+
+// xpathPrecedence lists binary operators from the loosest binding to the
+// tightest.
+var xpathPrecedence = [][]string{
+	{"or"},
+	{"and"},
+	{"=", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "div", "mod"},
+}
+
+func (p *xpathParser) parseBinary(level int) (xpathExpr, error) {
+	if level == len(xpathPrecedence) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.acceptSymbol(xpathPrecedence[level]...)
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: op, left: left, right: right}
+	}
+}
+
+func (p *xpathParser) parseUnary() (xpathExpr, error) {
+	if _, ok := p.acceptSymbol("-"); ok {
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &negateExpr{expr: e}, nil
+	}
+
+	left, err := p.parsePath()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.acceptSymbol("|"); !ok {
+			return left, nil
+		}
+		right, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: "|", left: left, right: right}
+	}
+}
+
+// parsePath parses a location path or a filter expression optionally
+// followed by more steps.
+func (p *xpathParser) parsePath() (xpathExpr, error) {
+	tok := p.peek()
+	if p.startsPrimary() {
+		primary, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		predicates, err := p.parsePredicates()
+		if err != nil {
+			return nil, err
+		}
+		var filter xpathExpr = primary
+		if len(predicates) > 0 {
+			filter = &filterExpr{primary: primary, predicates: predicates}
+		}
+		if tok := p.peek(); tok.kind != xtSymbol || (tok.value != "/" && tok.value != "//") {
+			return filter, nil
+		}
+		path := &pathExpr{filter: filter}
+		return path, p.parseRelativePath(path, true)
+	}
+
+	path := &pathExpr{}
+	if tok.kind == xtSymbol && (tok.value == "/" || tok.value == "//") {
+		path.absolute = true
+		if tok.value == "/" {
+			p.next()
+			if !p.startsStep() {
+				return path, nil // The root node alone
+			}
+			return path, p.parseRelativePath(path, false)
+		}
+	}
+	return path, p.parseRelativePath(path, path.absolute)
+}
+
+// startsPrimary reports whether the next token begins a primary expression
+// rather than a location step.
+func (p *xpathParser) startsPrimary() bool {
+	tok := p.peek()
+	switch tok.kind {
+	case xtLiteral, xtNumber:
+		return true
+	case xtSymbol:
+		return tok.value == "("
+	case xtName:
+		next := p.peekAt(1)
+		if next.kind != xtSymbol || next.value != "(" {
+			return false
+		}
+		switch tok.value {
+		case "node", "text", "comment", "processing-instruction":
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+func (p *xpathParser) startsStep() bool {
+	tok := p.peek()
+	switch tok.kind {
+	case xtName:
+		return true
+	case xtSymbol:
+		return tok.value == "." || tok.value == ".." || tok.value == "@"
+	}
+	return false
+}
+
+// parseRelativePath parses steps separated by "/" or "//". If leadingSlash
+// is set the next token is a separator that must be consumed first.
+func (p *xpathParser) parseRelativePath(path *pathExpr, leadingSlash bool) error {
+	if !leadingSlash {
+		if err := p.parseStep(path); err != nil {
+			return err
+		}
+	}
+	for {
+		sep, ok := p.acceptSymbol("/", "//")
+		if !ok {
+			return nil
+		}
+		if sep == "//" {
+			path.steps = append(path.steps, &xpathStep{axis: "descendant-or-self", test: nodeTest{kind: "node"}})
+		}
+		if err := p.parseStep(path); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *xpathParser) parseStep(path *pathExpr) error {
+	if sym, ok := p.acceptSymbol(".", ".."); ok {
+		axis := "self"
+		if sym == ".." {
+			axis = "parent"
+		}
+		path.steps = append(path.steps, &xpathStep{axis: axis, test: nodeTest{kind: "node"}})
+		return nil
+	}
+
+	step := &xpathStep{axis: "child"}
+	if _, ok := p.acceptSymbol("@"); ok {
+		step.axis = "attribute"
+	} else if tok := p.peek(); tok.kind == xtName && p.peekAt(1).kind == xtSymbol && p.peekAt(1).value == "::" {
+		if !xpathAxes[tok.value] {
+			return p.errorf(tok, "unknown axis %q", tok.value)
+		}
+		step.axis = tok.value
+		p.pos += 2
+	}
+
+	tok := p.next()
+	if tok.kind != xtName {
+		if tok.kind == xtEOF {
+			return p.errorf(tok, "expected a node test, got end of expression")
+		}
+		return p.errorf(tok, "expected a node test, got %q", tok.value)
+	}
+	step.test = nodeTest{kind: "name", name: tok.value}
+	if next := p.peek(); next.kind == xtSymbol && next.value == "(" {
+		switch tok.value {
+		case "node", "text", "comment", "processing-instruction":
+		default:
+			return p.errorf(tok, "unknown node type %q", tok.value)
+		}
+		p.next()
+		if tok.value == "processing-instruction" && p.peek().kind == xtLiteral {
+			p.next()
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return err
+		}
+		step.test = nodeTest{kind: tok.value}
+	}
+
+	predicates, err := p.parsePredicates()
+	if err != nil {
+		return err
+	}
+	step.predicates = predicates
+	path.steps = append(path.steps, step)
+	return nil
+}
+
+func (p *xpathParser) parsePredicates() ([]xpathExpr, error) {
+	var predicates []xpathExpr
+	for {
+		if _, ok := p.acceptSymbol("["); !ok {
+			return predicates, nil
+		}
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol("]"); err != nil {
+			return nil, err
+		}
+		predicates = append(predicates, e)
+	}
+}
+
+func (p *xpathParser) parsePrimary() (xpathExpr, error) {
+	tok := p.next()
+	switch tok.kind {
+	case xtLiteral:
+		return &literalExpr{value: tok.value}, nil
+	case xtNumber:
+		f, err := strconv.ParseFloat(tok.value, 64)
+		if err != nil {
+			return nil, p.errorf(tok, "bad number %q", tok.value)
+		}
+		return &literalExpr{value: f}, nil
+	case xtSymbol: // "("
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expectSymbol(")")
+	}
+
+	fn, ok := xpathFunctions[tok.value]
+	if !ok {
+		return nil, p.errorf(tok, "unknown function %s()", tok.value)
+	}
+	p.next() // Consume '('
+
+	var args []xpathExpr
+	if _, ok := p.acceptSymbol(")"); !ok {
+		for {
+			arg, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if _, ok := p.acceptSymbol(","); !ok {
+				break
+			}
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+	}
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		return nil, p.errorf(tok, "wrong number of arguments to %s()", tok.value)
+	}
+	return &functionExpr{name: tok.value, fn: fn, args: args}, nil
+}
@@ -0,0 +1,195 @@
+package parser
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+const xpathTestHTML = `<html><body>
+<h1 id="top">Outages</h1>
+<table id="t">
+<tr><th>Area</th><th>Time</th></tr>
+<tr class="row"><td>Zemun</td><td>  08:00 -
+ 12:00 </td></tr>
+<tr class="row"><td>Vračar</td><td>09:00</td></tr>
+</table>
+<p lang="sr-Latn">Kraj <a href="/next">dalje</a><!-- note --></p>
+</body></html>`
+
+// describeXPath renders a node set compactly: elements by tag and first text,
+// text nodes quoted, attributes as @name=value.
+func describeXPath(nodes []*Node) string {
+	var parts []string
+	for _, n := range nodes {
+		switch n.Type {
+		case NodeText:
+			parts = append(parts, "'"+strings.TrimSpace(n.Content)+"'")
+		case NodeAttribute:
+			parts = append(parts, "@"+n.TagName+"="+n.Content)
+		case NodeComment:
+			parts = append(parts, "<!--"+strings.TrimSpace(n.Content)+"-->")
+		default:
+			d := n.TagName
+			if len(n.Children) > 0 && n.Children[0].Type == NodeText {
+				d += "(" + strings.TrimSpace(n.Children[0].Content) + ")"
+			}
+			parts = append(parts, d)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestXPathNodeSets(t *testing.T) {
+	root := New(xpathTestHTML).Parse()
+
+	tests := []struct {
+		expr     string
+		expected string
+	}{
+		{"//td[1]", "td(Zemun) td(Vračar)"},
+		{"//tr[@class='row']/td[2]", "td(08:00 -\n 12:00) td(09:00)"},
+		{"/html/body/h1", "h1(Outages)"},
+		{"//tr[td='Vračar']/td[last()]", "td(09:00)"},
+		{"//td[contains(., ':')][position() = 1]", "td(08:00 -\n 12:00) td(09:00)"},
+		{"(//td[contains(., ':')])[position() = 1]", "td(08:00 -\n 12:00)"},
+		{"//td[normalize-space()='08:00 - 12:00']", "td(08:00 -\n 12:00)"},
+		{"//td[text()='Zemun']/following-sibling::td", "td(08:00 -\n 12:00)"},
+		{"//td[.='09:00']/preceding-sibling::*", "td(Vračar)"},
+		{"//a/ancestor::*[position() <= 2]", "body() p(Kraj)"},
+		{"//a/ancestor::*[1]", "p(Kraj)"},
+		{"//a/ancestor-or-self::p", "p(Kraj)"},
+		{"//th[2]/following::td[1]", "td(Zemun)"},
+		{"//p/preceding::h1", "h1(Outages)"},
+		{"(//td)[last()]", "td(09:00)"},
+		{"(//td)[2]/..", "tr"},
+		{"//a/@href", "@href=/next"},
+		{"//*[@id]/@id", "@id=top @id=t"},
+		{"//p/text()", "'Kraj'"},
+		{"//p/comment()", "<!--note-->"},
+		{"//p/node()", "'Kraj' a(dalje) <!--note-->"},
+		{"//h1 | //a | //h1", "h1(Outages) a(dalje)"},
+		{"//tr[count(td) = 2][not(@class)]", ""},
+		{"//tr[th]", "tr"},
+		{"id('t')/tbody/tr[2]/td[1]", "td(Zemun)"},
+		{"//*[lang('sr')]", "p(Kraj) a(dalje)"},
+		{"//td[starts-with(., 'Vr')]/self::td", "td(Vračar)"},
+		{"//table/descendant::th[position() > 1]", "th(Time)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			nodes, err := root.SelectXPath(tt.expr)
+			if err != nil {
+				t.Fatalf("xpath %q - unexpected error: %v", tt.expr, err)
+			}
+			if got := describeXPath(nodes); got != tt.expected {
+				t.Fatalf("xpath %q - expected=%q, got=%q", tt.expr, tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestXPathValues(t *testing.T) {
+	root := New(xpathTestHTML).Parse()
+
+	tests := []struct {
+		expr     string
+		typ      XPathType
+		expected string
+	}{
+		{"count(//tr)", XPathNumber, "3"},
+		{"count(//td) div 3", XPathNumber, "1.3333333333333333"},
+		{"string(//h1)", XPathString, "Outages"},
+		{"normalize-space(//tr[2]/td[2])", XPathString, "08:00 - 12:00"},
+		{"concat(//td[1], '/', //th[1])", XPathString, "Zemun/Area"},
+		{"substring-before(//tr[3]/td[2], ':')", XPathString, "09"},
+		{"substring-after(//tr[3]/td[2], ':')", XPathString, "00"},
+		{"substring('12345', 1.5, 2.6)", XPathString, "234"},
+		{"string-length(//tr[3]/td[1])", XPathNumber, "6"},
+		{"translate('bar', 'abc', 'ABC')", XPathString, "BAr"},
+		{"//h1 = 'Outages'", XPathBoolean, "true"},
+		{"//td != 'Zemun'", XPathBoolean, "true"},
+		{"boolean(//h2)", XPathBoolean, "false"},
+		{"sum(//tr[3]/td[2]) > 8", XPathBoolean, "false"},
+		{"number('12') + -2 * 3 mod 4", XPathNumber, "10"},
+		{"round(2.5) + floor(-1.5) + ceiling(0.2)", XPathNumber, "2"},
+		{"1 div 0", XPathNumber, "Infinity"},
+		{"number('x')", XPathNumber, "NaN"},
+		{"local-name(//a/@href)", XPathString, "href"},
+		{"name(//tr[1]/..)", XPathString, "tbody"},
+		{"true() and not(false()) or 1 = 2", XPathBoolean, "true"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			r, err := root.EvaluateXPath(tt.expr)
+			if err != nil {
+				t.Fatalf("xpath %q - unexpected error: %v", tt.expr, err)
+			}
+			if r.Type != tt.typ {
+				t.Fatalf("xpath %q - type wrong. expected=%s, got=%s", tt.expr, tt.typ, r.Type)
+			}
+			if got := r.String(); got != tt.expected {
+				t.Fatalf("xpath %q - expected=%q, got=%q", tt.expr, tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestXPathRelativeContext(t *testing.T) {
+	root := New(xpathTestHTML).Parse()
+	rows := MustCompileXPath("//tr[td]")
+	cell := MustCompileXPath("td[2]")
+
+	nodes, err := rows.Select(root.Node)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []string
+	for _, row := range nodes {
+		r, err := cell.Evaluate(row)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got = append(got, strings.Join(strings.Fields(r.String()), " "))
+	}
+	if strings.Join(got, "|") != "08:00 - 12:00|09:00" {
+		t.Fatalf("expected cells %q, got %q", "08:00 - 12:00|09:00", got)
+	}
+
+	r, _ := MustCompileXPath("number(//h1)").Evaluate(root.Node)
+	if !math.IsNaN(r.Number()) || r.Bool() {
+		t.Fatalf("expected NaN converting to false, got %v", r.Number())
+	}
+}
+
+func TestXPathErrors(t *testing.T) {
+	root := New(xpathTestHTML).Parse()
+
+	tests := []string{
+		"",
+		"//",
+		"//td[",
+		"foo()",
+		"count()",
+		"bogus::td",
+		"//td/$x",
+		"'unterminated",
+		"//td td",
+		"'a'/td",
+		"count('a')",
+	}
+
+	for _, expr := range tests {
+		t.Run(expr, func(t *testing.T) {
+			if _, err := root.EvaluateXPath(expr); err == nil {
+				t.Fatalf("xpath %q - expected an error", expr)
+			}
+		})
+	}
+
+	if _, err := root.SelectXPath("count(//td)"); err == nil {
+		t.Fatalf("expected an error selecting a number as a node set")
+	}
+}
@@ -18,22 +18,26 @@ func NewScraper(headers map[string]string) *Scraper {
 }
 
 // Scrape fetches and parses the HTML from the given URL.
-func (s *Scraper) Scrape(url string) (*parser.Node, error) {
+func (s *Scraper) Scrape(url string) (*parser.Document, error) {
 	fmt.Printf("Fetching URL: %s\n", url)
 
 	// Fetch the HTML content
-	html, err := httpclient.FetchHTML(url, s.Headers)
+	body, err := httpclient.FetchReader(url, s.Headers)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch URL: %v", err)
 	}
+	defer body.Close()
 
 	fmt.Println("Parsing HTML...")
-	// Parse the HTML content
-	p := parser.New(html)
-	root := p.Parse()
+	// Parse the HTML content as it is downloaded
+	doc, err := parser.ParseReader(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+	doc.Encoding = body.Encoding
 
 	fmt.Println("HTML parsing complete.")
-	return root, nil
+	return doc, nil
 }
 
 // PrintTree traverses and prints the parsed HTML tree.
@@ -44,7 +48,7 @@ func PrintTree(root *parser.Node) {
 func printNode(node *parser.Node, indent string) {
 	fmt.Printf("%sNode: Type=%s, TagName=%s, Content=%s\n", indent, node.Type, node.TagName, node.Content)
 	for _, attr := range node.Attributes {
-		fmt.Printf("%s  Attribute: %s\n", indent, attr)
+		fmt.Printf("%s  Attribute: %s=%q\n", indent, attr.Name, attr.Value)
 	}
 	for _, child := range node.Children {
 		printNode(child, indent+"  ")
@@ -1 +1,35 @@
 package scraper
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestScrape(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") != "Go-HTML-Parser" {
+			t.Errorf("Expected User-Agent header to be sent, got %q", r.Header.Get("User-Agent"))
+		}
+		w.Header().Set("Content-Type", "text/html; charset=windows-1250")
+		_, _ = w.Write([]byte("<table><tr><td>Zemun</td><td>08:00</td></tr><tr><td>\x8aabac</td></tr></table>"))
+	}))
+	defer server.Close()
+
+	s := NewScraper(map[string]string{"User-Agent": "Go-HTML-Parser"})
+	root, err := s.Scrape(server.URL)
+	if err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+	cells := root.FindByTag("td")
+	if len(cells) != 3 || cells[0].Text() != "Zemun" {
+		t.Fatalf("Expected three cells starting with Zemun, got %d", len(cells))
+	}
+	if root.Encoding != "windows-1250" || cells[2].Text() != "Šabac" {
+		t.Fatalf("Expected Šabac decoded from windows-1250, got %q from %q", cells[2].Text(), root.Encoding)
+	}
+
+	if _, err := s.Scrape(server.URL + "/missing\x7f"); err == nil {
+		t.Fatalf("Expected an error for an invalid URL but got none")
+	}
+}